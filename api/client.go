@@ -14,21 +14,47 @@ import (
 )
 
 func NewClient(token, endpoint string) graphql.Client {
+	return NewClientWithTokenSource(StaticToken(token), endpoint)
+}
+
+// NewClientWithTokenSource creates a client that gets the API token from
+// tokens for each request, so that the token can change while the client is in
+// use.
+func NewClientWithTokenSource(tokens TokenSource, endpoint string) graphql.Client {
 	if endpoint == "" {
 		endpoint = "https://graphql.buildkite.com/v1"
 	}
 	httpClient := http.Client{
 		Timeout: 60 * time.Second,
-		Transport: NewLogger(&authedTransport{
-			key:     token,
-			wrapped: http.DefaultTransport,
+		Transport: NewLogger(&retryTransport{
+			// Retries get the token again, in case it was reloaded.
+			wrapped: &authedTransport{
+				tokens:  tokens,
+				wrapped: http.DefaultTransport,
+			},
 		}),
 	}
 	return graphql.NewClient(endpoint, &httpClient)
 }
 
+// TokenSource supplies the Buildkite API token.
+type TokenSource interface {
+	// Token returns the token to use for a request.
+	Token() string
+
+	// Rejected is called when a request made with the token fails with
+	// 401 Unauthorized, e.g. because the token was rotated.
+	Rejected(token string)
+}
+
+// StaticToken is a TokenSource that never changes.
+type StaticToken string
+
+func (t StaticToken) Token() string { return string(t) }
+func (StaticToken) Rejected(string) {}
+
 type authedTransport struct {
-	key     string
+	tokens  TokenSource
 	wrapped http.RoundTripper
 }
 
@@ -46,11 +72,16 @@ func (t *authedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 		}()
 	}
 
+	token := t.tokens.Token()
 	reqCopy := req.Clone(req.Context())
-	reqCopy.Header.Set("Authorization", "Bearer "+t.key)
+	reqCopy.Header.Set("Authorization", "Bearer "+token)
 
 	reqBodyClosed = true
-	return t.wrapped.RoundTrip(reqCopy)
+	resp, err := t.wrapped.RoundTrip(reqCopy)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.tokens.Rejected(token)
+	}
+	return resp, err
 }
 
 type logTransport struct {
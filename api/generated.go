@@ -6,10 +6,35 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/Khan/genqlient/graphql"
 )
 
+// The visual style of the annotation
+type AnnotationStyle string
+
+const (
+	// The default styling of an annotation
+	AnnotationStyleDefault AnnotationStyle = "DEFAULT"
+	// The annotation has a red border with a cross next to it
+	AnnotationStyleError AnnotationStyle = "ERROR"
+	// The annotation has a blue border with an information icon next to it
+	AnnotationStyleInfo AnnotationStyle = "INFO"
+	// The annotation has a green border with a tick next to it
+	AnnotationStyleSuccess AnnotationStyle = "SUCCESS"
+	// The annotation has an orange border with a warning icon next to it
+	AnnotationStyleWarning AnnotationStyle = "WARNING"
+)
+
+var AllAnnotationStyle = []AnnotationStyle{
+	AnnotationStyleDefault,
+	AnnotationStyleError,
+	AnnotationStyleInfo,
+	AnnotationStyleSuccess,
+	AnnotationStyleWarning,
+}
+
 // Build includes the GraphQL fields of Build requested by the fragment Build.
 // The GraphQL type's documentation follows.
 //
@@ -40,6 +65,65 @@ func (v *Build) GetState() BuildStates { return v.State }
 // GetJobs returns Build.Jobs, and is useful for accessing the field via an interface.
 func (v *Build) GetJobs() BuildJobsJobConnection { return v.Jobs }
 
+// BuildAnnotateBuildAnnotateBuildAnnotatePayload includes the requested fields of the GraphQL type BuildAnnotatePayload.
+// The GraphQL type's documentation follows.
+//
+// Autogenerated return type of BuildAnnotate.
+type BuildAnnotateBuildAnnotateBuildAnnotatePayload struct {
+	// A unique identifier for the client performing the mutation.
+	ClientMutationId string `json:"clientMutationId"`
+}
+
+// GetClientMutationId returns BuildAnnotateBuildAnnotateBuildAnnotatePayload.ClientMutationId, and is useful for accessing the field via an interface.
+func (v *BuildAnnotateBuildAnnotateBuildAnnotatePayload) GetClientMutationId() string {
+	return v.ClientMutationId
+}
+
+// Autogenerated input type of BuildAnnotate
+type BuildAnnotateInput struct {
+	// Append to an existing annotation
+	Append bool `json:"append"`
+	// The body of the annotation. Markdown and some limited HTML is supported
+	Body string `json:"body"`
+	// The GraphQL ID of the build you want to annotate
+	BuildID string `json:"buildID"`
+	// A unique identifier for the client performing the mutation.
+	ClientMutationId string `json:"clientMutationId"`
+	// A string label to differentiate this annotation from other annotations. The default is `default`
+	Context string `json:"context"`
+	// The style of the annotation. The default is `DEFAULT`
+	Style AnnotationStyle `json:"style"`
+}
+
+// GetAppend returns BuildAnnotateInput.Append, and is useful for accessing the field via an interface.
+func (v *BuildAnnotateInput) GetAppend() bool { return v.Append }
+
+// GetBody returns BuildAnnotateInput.Body, and is useful for accessing the field via an interface.
+func (v *BuildAnnotateInput) GetBody() string { return v.Body }
+
+// GetBuildID returns BuildAnnotateInput.BuildID, and is useful for accessing the field via an interface.
+func (v *BuildAnnotateInput) GetBuildID() string { return v.BuildID }
+
+// GetClientMutationId returns BuildAnnotateInput.ClientMutationId, and is useful for accessing the field via an interface.
+func (v *BuildAnnotateInput) GetClientMutationId() string { return v.ClientMutationId }
+
+// GetContext returns BuildAnnotateInput.Context, and is useful for accessing the field via an interface.
+func (v *BuildAnnotateInput) GetContext() string { return v.Context }
+
+// GetStyle returns BuildAnnotateInput.Style, and is useful for accessing the field via an interface.
+func (v *BuildAnnotateInput) GetStyle() AnnotationStyle { return v.Style }
+
+// BuildAnnotateResponse is returned by BuildAnnotate on success.
+type BuildAnnotateResponse struct {
+	// Annotate a build with information to appear on the build page.
+	BuildAnnotate BuildAnnotateBuildAnnotateBuildAnnotatePayload `json:"buildAnnotate"`
+}
+
+// GetBuildAnnotate returns BuildAnnotateResponse.BuildAnnotate, and is useful for accessing the field via an interface.
+func (v *BuildAnnotateResponse) GetBuildAnnotate() BuildAnnotateBuildAnnotateBuildAnnotatePayload {
+	return v.BuildAnnotate
+}
+
 // Author for a build
 type BuildAuthorInput struct {
 	// The email for the build author
@@ -414,6 +498,10 @@ type CommandJob struct {
 	AgentQueryRules []string `json:"agentQueryRules"`
 	// The command the job will run
 	Command string `json:"command"`
+	// The time when the job became scheduled for running
+	ScheduledAt time.Time `json:"scheduledAt"`
+	// The pipeline that this job is a part of
+	Pipeline CommandJobPipeline `json:"pipeline"`
 }
 
 // GetUuid returns CommandJob.Uuid, and is useful for accessing the field via an interface.
@@ -431,6 +519,24 @@ func (v *CommandJob) GetAgentQueryRules() []string { return v.AgentQueryRules }
 // GetCommand returns CommandJob.Command, and is useful for accessing the field via an interface.
 func (v *CommandJob) GetCommand() string { return v.Command }
 
+// GetScheduledAt returns CommandJob.ScheduledAt, and is useful for accessing the field via an interface.
+func (v *CommandJob) GetScheduledAt() time.Time { return v.ScheduledAt }
+
+// GetPipeline returns CommandJob.Pipeline, and is useful for accessing the field via an interface.
+func (v *CommandJob) GetPipeline() CommandJobPipeline { return v.Pipeline }
+
+// CommandJobPipeline includes the requested fields of the GraphQL type Pipeline.
+// The GraphQL type's documentation follows.
+//
+// A pipeline
+type CommandJobPipeline struct {
+	// The slug of the pipeline
+	Slug string `json:"slug"`
+}
+
+// GetSlug returns CommandJobPipeline.Slug, and is useful for accessing the field via an interface.
+func (v *CommandJobPipeline) GetSlug() string { return v.Slug }
+
 // CommandJobPriority includes the requested fields of the GraphQL type JobPriority.
 // The GraphQL type's documentation follows.
 //
@@ -758,6 +864,240 @@ func (v *GetClusterQueuesResponse) GetOrganization() GetClusterQueuesOrganizatio
 	return v.Organization
 }
 
+// GetCommandJobBuildJob includes the requested fields of the GraphQL interface Job.
+//
+// GetCommandJobBuildJob is implemented by the following types:
+// GetCommandJobBuildJobJobTypeBlock
+// GetCommandJobBuildJobJobTypeCommand
+// GetCommandJobBuildJobJobTypeTrigger
+// GetCommandJobBuildJobJobTypeWait
+// The GraphQL type's documentation follows.
+//
+// Kinds of jobs that can exist on a build
+type GetCommandJobBuildJob interface {
+	implementsGraphQLInterfaceGetCommandJobBuildJob()
+	// GetTypename returns the receiver's concrete GraphQL type-name (see interface doc for possible values).
+	GetTypename() string
+}
+
+func (v *GetCommandJobBuildJobJobTypeBlock) implementsGraphQLInterfaceGetCommandJobBuildJob()   {}
+func (v *GetCommandJobBuildJobJobTypeCommand) implementsGraphQLInterfaceGetCommandJobBuildJob() {}
+func (v *GetCommandJobBuildJobJobTypeTrigger) implementsGraphQLInterfaceGetCommandJobBuildJob() {}
+func (v *GetCommandJobBuildJobJobTypeWait) implementsGraphQLInterfaceGetCommandJobBuildJob()    {}
+
+func __unmarshalGetCommandJobBuildJob(b []byte, v *GetCommandJobBuildJob) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	var tn struct {
+		TypeName string `json:"__typename"`
+	}
+	err := json.Unmarshal(b, &tn)
+	if err != nil {
+		return err
+	}
+
+	switch tn.TypeName {
+	case "JobTypeBlock":
+		*v = new(GetCommandJobBuildJobJobTypeBlock)
+		return json.Unmarshal(b, *v)
+	case "JobTypeCommand":
+		*v = new(GetCommandJobBuildJobJobTypeCommand)
+		return json.Unmarshal(b, *v)
+	case "JobTypeTrigger":
+		*v = new(GetCommandJobBuildJobJobTypeTrigger)
+		return json.Unmarshal(b, *v)
+	case "JobTypeWait":
+		*v = new(GetCommandJobBuildJobJobTypeWait)
+		return json.Unmarshal(b, *v)
+	case "":
+		return fmt.Errorf(
+			"response was missing Job.__typename")
+	default:
+		return fmt.Errorf(
+			`unexpected concrete type for GetCommandJobBuildJob: "%v"`, tn.TypeName)
+	}
+}
+
+func __marshalGetCommandJobBuildJob(v *GetCommandJobBuildJob) ([]byte, error) {
+
+	var typename string
+	switch v := (*v).(type) {
+	case *GetCommandJobBuildJobJobTypeBlock:
+		typename = "JobTypeBlock"
+
+		result := struct {
+			TypeName string `json:"__typename"`
+			*GetCommandJobBuildJobJobTypeBlock
+		}{typename, v}
+		return json.Marshal(result)
+	case *GetCommandJobBuildJobJobTypeCommand:
+		typename = "JobTypeCommand"
+
+		result := struct {
+			TypeName string `json:"__typename"`
+			*GetCommandJobBuildJobJobTypeCommand
+		}{typename, v}
+		return json.Marshal(result)
+	case *GetCommandJobBuildJobJobTypeTrigger:
+		typename = "JobTypeTrigger"
+
+		result := struct {
+			TypeName string `json:"__typename"`
+			*GetCommandJobBuildJobJobTypeTrigger
+		}{typename, v}
+		return json.Marshal(result)
+	case *GetCommandJobBuildJobJobTypeWait:
+		typename = "JobTypeWait"
+
+		result := struct {
+			TypeName string `json:"__typename"`
+			*GetCommandJobBuildJobJobTypeWait
+		}{typename, v}
+		return json.Marshal(result)
+	case nil:
+		return []byte("null"), nil
+	default:
+		return nil, fmt.Errorf(
+			`unexpected concrete type for GetCommandJobBuildJob: "%T"`, v)
+	}
+}
+
+// GetCommandJobBuildJobJobTypeBlock includes the requested fields of the GraphQL type JobTypeBlock.
+// The GraphQL type's documentation follows.
+//
+// A type of job that requires a user to unblock it before proceeding in a build pipeline
+type GetCommandJobBuildJobJobTypeBlock struct {
+	Typename string `json:"__typename"`
+}
+
+// GetTypename returns GetCommandJobBuildJobJobTypeBlock.Typename, and is useful for accessing the field via an interface.
+func (v *GetCommandJobBuildJobJobTypeBlock) GetTypename() string { return v.Typename }
+
+// GetCommandJobBuildJobJobTypeCommand includes the requested fields of the GraphQL type JobTypeCommand.
+// The GraphQL type's documentation follows.
+//
+// A type of job that runs a command on an agent
+type GetCommandJobBuildJobJobTypeCommand struct {
+	Typename string `json:"__typename"`
+	// The build that this job is a part of
+	Build GetCommandJobBuildJobJobTypeCommandBuild `json:"build"`
+}
+
+// GetTypename returns GetCommandJobBuildJobJobTypeCommand.Typename, and is useful for accessing the field via an interface.
+func (v *GetCommandJobBuildJobJobTypeCommand) GetTypename() string { return v.Typename }
+
+// GetBuild returns GetCommandJobBuildJobJobTypeCommand.Build, and is useful for accessing the field via an interface.
+func (v *GetCommandJobBuildJobJobTypeCommand) GetBuild() GetCommandJobBuildJobJobTypeCommandBuild {
+	return v.Build
+}
+
+// GetCommandJobBuildJobJobTypeCommandBuild includes the requested fields of the GraphQL type Build.
+// The GraphQL type's documentation follows.
+//
+// A build from a pipeline
+type GetCommandJobBuildJobJobTypeCommandBuild struct {
+	Id string `json:"id"`
+}
+
+// GetId returns GetCommandJobBuildJobJobTypeCommandBuild.Id, and is useful for accessing the field via an interface.
+func (v *GetCommandJobBuildJobJobTypeCommandBuild) GetId() string { return v.Id }
+
+// GetCommandJobBuildJobJobTypeTrigger includes the requested fields of the GraphQL type JobTypeTrigger.
+// The GraphQL type's documentation follows.
+//
+// A type of job that triggers another build on a pipeline
+type GetCommandJobBuildJobJobTypeTrigger struct {
+	Typename string `json:"__typename"`
+}
+
+// GetTypename returns GetCommandJobBuildJobJobTypeTrigger.Typename, and is useful for accessing the field via an interface.
+func (v *GetCommandJobBuildJobJobTypeTrigger) GetTypename() string { return v.Typename }
+
+// GetCommandJobBuildJobJobTypeWait includes the requested fields of the GraphQL type JobTypeWait.
+// The GraphQL type's documentation follows.
+//
+// A type of job that waits for all previous jobs to pass before proceeding the build pipeline
+type GetCommandJobBuildJobJobTypeWait struct {
+	Typename string `json:"__typename"`
+}
+
+// GetTypename returns GetCommandJobBuildJobJobTypeWait.Typename, and is useful for accessing the field via an interface.
+func (v *GetCommandJobBuildJobJobTypeWait) GetTypename() string { return v.Typename }
+
+// GetCommandJobBuildResponse is returned by GetCommandJobBuild on success.
+type GetCommandJobBuildResponse struct {
+	// Find a build job
+	Job GetCommandJobBuildJob `json:"-"`
+}
+
+// GetJob returns GetCommandJobBuildResponse.Job, and is useful for accessing the field via an interface.
+func (v *GetCommandJobBuildResponse) GetJob() GetCommandJobBuildJob { return v.Job }
+
+func (v *GetCommandJobBuildResponse) UnmarshalJSON(b []byte) error {
+
+	if string(b) == "null" {
+		return nil
+	}
+
+	var firstPass struct {
+		*GetCommandJobBuildResponse
+		Job json.RawMessage `json:"job"`
+		graphql.NoUnmarshalJSON
+	}
+	firstPass.GetCommandJobBuildResponse = v
+
+	err := json.Unmarshal(b, &firstPass)
+	if err != nil {
+		return err
+	}
+
+	{
+		dst := &v.Job
+		src := firstPass.Job
+		if len(src) != 0 && string(src) != "null" {
+			err = __unmarshalGetCommandJobBuildJob(
+				src, dst)
+			if err != nil {
+				return fmt.Errorf(
+					"unable to unmarshal GetCommandJobBuildResponse.Job: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+type __premarshalGetCommandJobBuildResponse struct {
+	Job json.RawMessage `json:"job"`
+}
+
+func (v *GetCommandJobBuildResponse) MarshalJSON() ([]byte, error) {
+	premarshaled, err := v.__premarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(premarshaled)
+}
+
+func (v *GetCommandJobBuildResponse) __premarshalJSON() (*__premarshalGetCommandJobBuildResponse, error) {
+	var retval __premarshalGetCommandJobBuildResponse
+
+	{
+
+		dst := &retval.Job
+		src := v.Job
+		var err error
+		*dst, err = __marshalGetCommandJobBuildJob(
+			&src)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to marshal GetCommandJobBuildResponse.Job: %w", err)
+		}
+	}
+	return &retval, nil
+}
+
 // GetCommandJobJob includes the requested fields of the GraphQL interface Job.
 //
 // GetCommandJobJob is implemented by the following types:
@@ -1365,6 +1705,12 @@ func (v *JobJobTypeCommand) GetAgentQueryRules() []string { return v.CommandJob.
 // GetCommand returns JobJobTypeCommand.Command, and is useful for accessing the field via an interface.
 func (v *JobJobTypeCommand) GetCommand() string { return v.CommandJob.Command }
 
+// GetScheduledAt returns JobJobTypeCommand.ScheduledAt, and is useful for accessing the field via an interface.
+func (v *JobJobTypeCommand) GetScheduledAt() time.Time { return v.CommandJob.ScheduledAt }
+
+// GetPipeline returns JobJobTypeCommand.Pipeline, and is useful for accessing the field via an interface.
+func (v *JobJobTypeCommand) GetPipeline() CommandJobPipeline { return v.CommandJob.Pipeline }
+
 func (v *JobJobTypeCommand) UnmarshalJSON(b []byte) error {
 
 	if string(b) == "null" {
@@ -1400,6 +1746,10 @@ type __premarshalJobJobTypeCommand struct {
 	AgentQueryRules []string `json:"agentQueryRules"`
 
 	Command string `json:"command"`
+
+	ScheduledAt time.Time `json:"scheduledAt"`
+
+	Pipeline CommandJobPipeline `json:"pipeline"`
 }
 
 func (v *JobJobTypeCommand) MarshalJSON() ([]byte, error) {
@@ -1418,6 +1768,8 @@ func (v *JobJobTypeCommand) __premarshalJSON() (*__premarshalJobJobTypeCommand,
 	retval.Priority = v.CommandJob.Priority
 	retval.AgentQueryRules = v.CommandJob.AgentQueryRules
 	retval.Command = v.CommandJob.Command
+	retval.ScheduledAt = v.CommandJob.ScheduledAt
+	retval.Pipeline = v.CommandJob.Pipeline
 	return &retval, nil
 }
 
@@ -1623,6 +1975,14 @@ func (v *SearchPipelinesResponse) GetOrganization() SearchPipelinesOrganization
 	return v.Organization
 }
 
+// __BuildAnnotateInput is used internally by genqlient
+type __BuildAnnotateInput struct {
+	Input BuildAnnotateInput `json:"input"`
+}
+
+// GetInput returns __BuildAnnotateInput.Input, and is useful for accessing the field via an interface.
+func (v *__BuildAnnotateInput) GetInput() BuildAnnotateInput { return v.Input }
+
 // __BuildCancelInput is used internally by genqlient
 type __BuildCancelInput struct {
 	Input BuildCancelInput `json:"input"`
@@ -1687,6 +2047,14 @@ func (v *__GetClusterQueuesInput) GetCluster() string { return v.Cluster }
 // GetFirst returns __GetClusterQueuesInput.First, and is useful for accessing the field via an interface.
 func (v *__GetClusterQueuesInput) GetFirst() int { return v.First }
 
+// __GetCommandJobBuildInput is used internally by genqlient
+type __GetCommandJobBuildInput struct {
+	Uuid string `json:"uuid"`
+}
+
+// GetUuid returns __GetCommandJobBuildInput.Uuid, and is useful for accessing the field via an interface.
+func (v *__GetCommandJobBuildInput) GetUuid() string { return v.Uuid }
+
 // __GetCommandJobInput is used internally by genqlient
 type __GetCommandJobInput struct {
 	Uuid string `json:"uuid"`
@@ -1763,6 +2131,40 @@ func (v *__SearchPipelinesInput) GetSearch() string { return v.Search }
 // GetFirst returns __SearchPipelinesInput.First, and is useful for accessing the field via an interface.
 func (v *__SearchPipelinesInput) GetFirst() int { return v.First }
 
+// The mutation executed by BuildAnnotate.
+const BuildAnnotate_Operation = `
+mutation BuildAnnotate ($input: BuildAnnotateInput!) {
+	buildAnnotate(input: $input) {
+		clientMutationId
+	}
+}
+`
+
+func BuildAnnotate(
+	ctx_ context.Context,
+	client_ graphql.Client,
+	input BuildAnnotateInput,
+) (data_ *BuildAnnotateResponse, err_ error) {
+	req_ := &graphql.Request{
+		OpName: "BuildAnnotate",
+		Query:  BuildAnnotate_Operation,
+		Variables: &__BuildAnnotateInput{
+			Input: input,
+		},
+	}
+
+	data_ = &BuildAnnotateResponse{}
+	resp_ := &graphql.Response{Data: data_}
+
+	err_ = client_.MakeRequest(
+		ctx_,
+		req_,
+		resp_,
+	)
+
+	return data_, err_
+}
+
 // The mutation executed by BuildCancel.
 const BuildCancel_Operation = `
 mutation BuildCancel ($input: BuildCancelInput!) {
@@ -1833,6 +2235,10 @@ fragment CommandJob on JobTypeCommand {
 	}
 	agentQueryRules
 	command
+	scheduledAt
+	pipeline {
+		slug
+	}
 }
 `
 
@@ -1929,6 +2335,10 @@ fragment CommandJob on JobTypeCommand {
 	}
 	agentQueryRules
 	command
+	scheduledAt
+	pipeline {
+		slug
+	}
 }
 `
 
@@ -1997,6 +2407,10 @@ fragment CommandJob on JobTypeCommand {
 	}
 	agentQueryRules
 	command
+	scheduledAt
+	pipeline {
+		slug
+	}
 }
 `
 
@@ -2118,6 +2532,45 @@ func GetCommandJob(
 	return data_, err_
 }
 
+// The query executed by GetCommandJobBuild.
+const GetCommandJobBuild_Operation = `
+query GetCommandJobBuild ($uuid: ID!) {
+	job(uuid: $uuid) {
+		__typename
+		... on JobTypeCommand {
+			build {
+				id
+			}
+		}
+	}
+}
+`
+
+func GetCommandJobBuild(
+	ctx_ context.Context,
+	client_ graphql.Client,
+	uuid string,
+) (data_ *GetCommandJobBuildResponse, err_ error) {
+	req_ := &graphql.Request{
+		OpName: "GetCommandJobBuild",
+		Query:  GetCommandJobBuild_Operation,
+		Variables: &__GetCommandJobBuildInput{
+			Uuid: uuid,
+		},
+	}
+
+	data_ = &GetCommandJobBuildResponse{}
+	resp_ := &graphql.Response{Data: data_}
+
+	err_ = client_.MakeRequest(
+		ctx_,
+		req_,
+		resp_,
+	)
+
+	return data_, err_
+}
+
 // The query executed by GetOrganization.
 const GetOrganization_Operation = `
 query GetOrganization ($slug: ID!) {
@@ -2181,6 +2634,10 @@ fragment CommandJob on JobTypeCommand {
 	}
 	agentQueryRules
 	command
+	scheduledAt
+	pipeline {
+		slug
+	}
 }
 `
 
@@ -2242,6 +2699,10 @@ fragment CommandJob on JobTypeCommand {
 	}
 	agentQueryRules
 	command
+	scheduledAt
+	pipeline {
+		slug
+	}
 }
 `
 
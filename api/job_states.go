@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// MaxJobStatesBatch is the most jobs GetCommandJobStates queries in one
+// request. Larger batches are split.
+const MaxJobStatesBatch = 100
+
+// Terminal reports whether the job state is one the job can't leave.
+func (s JobStates) Terminal() bool {
+	switch s {
+	case JobStatesBroken, JobStatesCanceled, JobStatesExpired, JobStatesFinished, JobStatesSkipped, JobStatesTimedOut:
+		return true
+	}
+	return false
+}
+
+// GetCommandJobStates queries the states of many command jobs at once, using
+// one aliased `job` field per job (the API has no field for fetching jobs by a
+// list of UUIDs). It returns the states by job UUID. Jobs that aren't found or
+// aren't command jobs are omitted.
+//
+// This is written by hand, because genqlient only supports static queries.
+// If some jobs couldn't be queried, the states of the others are returned along
+// with the error.
+func GetCommandJobStates(ctx context.Context, client graphql.Client, uuids []string) (map[string]JobStates, error) {
+	states := make(map[string]JobStates, len(uuids))
+	var errs []error
+	for start := 0; start < len(uuids); start += MaxJobStatesBatch {
+		batch := uuids[start:min(start+MaxJobStatesBatch, len(uuids))]
+		if err := getCommandJobStatesBatch(ctx, client, batch, states); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return states, errors.Join(errs...)
+}
+
+func getCommandJobStatesBatch(ctx context.Context, client graphql.Client, uuids []string, states map[string]JobStates) error {
+	var query strings.Builder
+	query.WriteString("query GetCommandJobStates(")
+	variables := make(map[string]string, len(uuids))
+	for i, uuid := range uuids {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		fmt.Fprintf(&query, "$u%d: ID!", i)
+		variables[fmt.Sprintf("u%d", i)] = uuid
+	}
+	query.WriteString(") {\n")
+	for i := range uuids {
+		fmt.Fprintf(&query, "  j%d: job(uuid: $u%d) { ... on JobTypeCommand { uuid state } }\n", i, i)
+	}
+	query.WriteString("}\n")
+
+	var data map[string]*struct {
+		UUID  string    `json:"uuid"`
+		State JobStates `json:"state"`
+	}
+	resp := &graphql.Response{Data: &data}
+	err := client.MakeRequest(ctx, &graphql.Request{
+		OpName:    "GetCommandJobStates",
+		Query:     query.String(),
+		Variables: variables,
+	}, resp)
+
+	// With errors for some jobs (e.g. not found), data for the others may
+	// still be present.
+	for _, job := range data {
+		if job == nil || job.UUID == "" {
+			continue
+		}
+		states[job.UUID] = job.State
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGetCommandJobStates(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req struct {
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if got, want := len(req.Variables), 3; got != want {
+			t.Errorf("len(req.Variables) = %d, want %d", got, want)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		// j1 wasn't found.
+		w.Write([]byte(`{"data": {
+			"j0": {"uuid": "a", "state": "SCHEDULED"},
+			"j1": null,
+			"j2": {"uuid": "c", "state": "CANCELED"}
+		}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := graphql.NewClient(srv.URL, http.DefaultClient)
+	got, err := GetCommandJobStates(context.Background(), client, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("GetCommandJobStates() error = %v", err)
+	}
+	want := map[string]JobStates{
+		"a": JobStatesScheduled,
+		"c": JobStatesCanceled,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("GetCommandJobStates() diff (-got +want):\n%s", diff)
+	}
+	if requests != 1 {
+		t.Errorf("server got %d requests, want 1", requests)
+	}
+}
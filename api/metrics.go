@@ -0,0 +1,26 @@
+package api
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	promNamespace = "buildkite"
+	promSubsystem = "graphql"
+)
+
+var (
+	requestRetriesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "request_retries_total",
+		Help:      "Count of GraphQL requests that were retried, by operation",
+	}, []string{"operation"})
+	requestErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "request_errors_total",
+		Help:      "Count of GraphQL request attempts that failed with a transport error or a retryable status, by operation",
+	}, []string{"operation", "reason"})
+)
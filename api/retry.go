@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildkite/roko"
+)
+
+const (
+	// retryMaxAttempts is the number of attempts made at a retryable request.
+	retryMaxAttempts = 4
+
+	// retryMaxRetryAfter caps how long a Retry-After header can delay a
+	// retry.
+	retryMaxRetryAfter = 30 * time.Second
+)
+
+// idempotentMutations are mutations that have the same effect if repeated, so
+// can be retried like queries.
+var idempotentMutations = map[string]bool{
+	"BuildAnnotate":    true, // replaces the annotation with the same context
+	"BuildCancel":      true,
+	"CancelCommandJob": true,
+}
+
+// errRetryableStatus is returned to the retrier for responses that should be
+// retried.
+var errRetryableStatus = errors.New("retryable response status")
+
+// retryTransport retries GraphQL requests that fail with a transport error, a
+// 5xx status, or 429 Too Many Requests, with jittered exponential backoff.
+// Only queries and idempotent mutations are retried.
+type retryTransport struct {
+	wrapped http.RoundTripper
+
+	// sleep, if set, replaces waiting between attempts (for tests).
+	sleep func(time.Duration)
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	op, ok := requestOperation(req)
+	if !ok || (op.mutation && !idempotentMutations[op.name]) {
+		return t.wrapped.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	retrier := roko.NewRetrier(
+		roko.WithMaxAttempts(retryMaxAttempts),
+		roko.WithStrategy(roko.Exponential(2*time.Second, 0)),
+		roko.WithJitter(),
+		roko.WithSleepFunc(t.sleep), // nil uses a timer
+	)
+	return roko.DoFunc(ctx, retrier, func(r *roko.Retrier) (*http.Response, error) {
+		attempt := req
+		if r.AttemptCount() > 0 {
+			requestRetriesCounter.WithLabelValues(op.name).Inc()
+			body, err := req.GetBody()
+			if err != nil {
+				r.Break()
+				return nil, err
+			}
+			attempt = req.Clone(ctx)
+			attempt.Body = body
+		}
+		lastAttempt := r.AttemptCount()+1 >= retryMaxAttempts
+
+		resp, err := t.wrapped.RoundTrip(attempt)
+		if err != nil {
+			requestErrorsCounter.WithLabelValues(op.name, "transport").Inc()
+			if ctx.Err() != nil {
+				r.Break()
+			}
+			return nil, err
+		}
+		if !retryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+		requestErrorsCounter.WithLabelValues(op.name, strconv.Itoa(resp.StatusCode)).Inc()
+		if lastAttempt {
+			// Let the caller see the real response.
+			return resp, nil
+		}
+		if d, ok := retryAfter(resp); ok {
+			r.SetNextInterval(d)
+		}
+		// Drain the body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, errRetryableStatus
+	})
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// retryAfter parses a Retry-After header given in seconds.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return min(time.Duration(secs)*time.Second, retryMaxRetryAfter), true
+}
+
+// operation identifies a GraphQL operation.
+type operation struct {
+	name     string
+	mutation bool
+}
+
+// requestOperation reads the GraphQL operation from the request body, without
+// consuming it. It reports false if the request can't be replayed, or isn't a
+// GraphQL request.
+func requestOperation(req *http.Request) (operation, bool) {
+	if req.GetBody == nil {
+		return operation{}, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return operation{}, false
+	}
+	defer body.Close()
+
+	var gqlReq struct {
+		Query         string `json:"query"`
+		OperationName string `json:"operationName"`
+	}
+	if err := json.NewDecoder(body).Decode(&gqlReq); err != nil || gqlReq.Query == "" {
+		return operation{}, false
+	}
+	return operation{
+		name:     gqlReq.OperationName,
+		mutation: strings.HasPrefix(strings.TrimSpace(gqlReq.Query), "mutation"),
+	}, true
+}
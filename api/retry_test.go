@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		opName       string
+		query        string
+		failures     int32
+		wantRequests int32
+		wantErr      bool
+	}{
+		{
+			name:         "query succeeds after transient failures",
+			opName:       "GetOrganization",
+			query:        "query GetOrganization { organization { id } }",
+			failures:     2,
+			wantRequests: 3,
+		},
+		{
+			name:         "query gives up",
+			opName:       "GetOrganization",
+			query:        "query GetOrganization { organization { id } }",
+			failures:     10,
+			wantRequests: retryMaxAttempts,
+			wantErr:      true,
+		},
+		{
+			name:         "mutation is not retried",
+			opName:       "BuildCreate",
+			query:        "mutation BuildCreate { buildCreate { build { id } } }",
+			failures:     1,
+			wantRequests: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "idempotent mutation is retried",
+			opName:       "CancelCommandJob",
+			query:        "mutation CancelCommandJob { jobTypeCommandCancel { clientMutationId } }",
+			failures:     1,
+			wantRequests: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= test.failures {
+					http.Error(w, "try again", http.StatusBadGateway)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"data": {}}`))
+			}))
+			t.Cleanup(srv.Close)
+
+			client := graphql.NewClient(srv.URL, &http.Client{
+				Transport: &retryTransport{
+					wrapped: http.DefaultTransport,
+					sleep:   func(time.Duration) {},
+				},
+			})
+			var resp graphql.Response
+			err := client.MakeRequest(context.Background(), &graphql.Request{
+				Query:  test.query,
+				OpName: test.opName,
+			}, &resp)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("client.MakeRequest() error = %v, want error: %t", err, test.wantErr)
+			}
+			if got := requests.Load(); got != test.wantRequests {
+				t.Errorf("server got %d requests, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
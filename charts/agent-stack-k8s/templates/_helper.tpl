@@ -43,3 +43,24 @@ namespace: {{ .Release.Namespace }}
 {{- define "agent-stack-k8s.serviceAccountMetadata" }}
 {{- toYaml (mustMerge (fromYaml (include "agent-stack-k8s.mandatoryServiceAccountMetadata" .)) .Values.serviceAccountMetadata) }}
 {{- end }}
+
+{{/* The name of the secret containing the default agent token */}}
+{{- define "agent-stack-k8s.agentTokenSecret" -}}
+  {{- if .Values.agentStackSecret -}}
+    {{- .Values.agentStackSecret -}}
+  {{- else -}}
+    {{- include "agent-stack-k8s.fullname" . }}-secrets
+  {{- end -}}
+{{- end -}}
+
+{{/* The names of all the agent token secrets the controller reads, as a YAML list */}}
+{{- define "agent-stack-k8s.agentTokenSecrets" -}}
+  {{- $names := list (include "agent-stack-k8s.agentTokenSecret" .) -}}
+  {{- with index .Values.config "agent-token-secret" -}}
+    {{- $names = append $names . -}}
+  {{- end -}}
+  {{- range index .Values.config "agent-token-secrets" | default list -}}
+    {{- $names = append $names .secret -}}
+  {{- end -}}
+  {{- $names | uniq | toYaml -}}
+{{- end -}}
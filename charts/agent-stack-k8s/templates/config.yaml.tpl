@@ -5,6 +5,6 @@ metadata:
   namespace: {{ .Release.Namespace }}
 data:
   config.yaml: |
-    agent-token-secret: {{ if .Values.agentStackSecret }}{{ .Values.agentStackSecret }}{{ else }}{{ include "agent-stack-k8s.fullname" . }}-secrets{{ end }}
+    agent-token-secret: {{ include "agent-stack-k8s.agentTokenSecret" . }}
     namespace: {{ .Release.Namespace }}
     {{- .Values.config | toYaml | nindent 4 }}
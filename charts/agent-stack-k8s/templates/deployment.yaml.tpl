@@ -29,17 +29,36 @@ spec:
           value: /etc/config.yaml
         envFrom:
           - secretRef:
-              name: {{ if .Values.agentStackSecret }}{{ .Values.agentStackSecret }}{{ else }}{{ include "agent-stack-k8s.fullname" . }}-secrets{{ end }}
+              name: {{ include "agent-stack-k8s.agentTokenSecret" . }}
         volumeMounts:
           - name: config
             mountPath: /etc/config.yaml
             subPath: config.yaml
+          {{- with .Values.volumeMounts }}
+          {{- toYaml . | nindent 10 }}
+          {{- end }}
         resources:
           {{- toYaml .Values.resources | nindent 10 }}
-        {{ with index .Values.config "prometheus-port" -}}
+        {{ if or (index .Values.config "prometheus-port") (index .Values.config "scaler-port") -}}
         ports:
+          {{- with index .Values.config "prometheus-port" }}
           - name: metrics
             containerPort: {{.}}
+          {{- end }}
+          {{- with index .Values.config "scaler-port" }}
+          - name: scaler
+            containerPort: {{.}}
+          {{- end }}
+        {{ end -}}
+        {{ with index .Values.config "health-port" -}}
+        livenessProbe:
+          httpGet:
+            path: /healthz
+            port: {{.}}
+        readinessProbe:
+          httpGet:
+            path: /readyz
+            port: {{.}}
         {{ end -}}
         securityContext:
           allowPrivilegeEscalation: false
@@ -54,3 +73,6 @@ spec:
         - name: config
           configMap:
             name: {{ include "agent-stack-k8s.fullname" . }}-config
+        {{- with .Values.volumes }}
+        {{- toYaml . | nindent 8 }}
+        {{- end }}
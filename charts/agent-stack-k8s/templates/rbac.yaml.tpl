@@ -13,6 +13,7 @@ rules:
       - watch
       - create
       - update
+      - delete
   - apiGroups:
       - ""
     resources:
@@ -25,20 +26,41 @@ rules:
       - ""
     resources:
       - secrets
+    # Only the agent token secrets. The controller watches each one by name.
+    resourceNames:
+      {{- include "agent-stack-k8s.agentTokenSecrets" . | nindent 6 }}
     verbs:
       - get
+      - list
+      - watch
   - apiGroups:
       - ""
     resources:
       - pods/eviction
     verbs:
       - create
+  - apiGroups:
+      - ""
+    resources:
+      - pods/log
+    verbs:
+      - get
+  - apiGroups:
+      - ""
+    resources:
+      - configmaps
+    verbs:
+      - get
+      - create
+      - update
   - apiGroups:
       - ""
     resources:
       - events
     verbs:
       - list
+      - create
+      - patch
 ---
 apiVersion: rbac.authorization.k8s.io/v1
 kind: RoleBinding
@@ -58,3 +80,30 @@ apiVersion: v1
 kind: ServiceAccount
 metadata:
   {{- include "agent-stack-k8s.serviceAccountMetadata" . | nindent 2 }}
+{{- if index .Values.config "priority-classes" }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: {{ include "agent-stack-k8s.fullname" . }}-controller-{{ .Release.Namespace }}
+rules:
+  - apiGroups:
+      - scheduling.k8s.io
+    resources:
+      - priorityclasses
+    verbs:
+      - get
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: {{ include "agent-stack-k8s.fullname" . }}-controller-{{ .Release.Namespace }}
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: {{ include "agent-stack-k8s.fullname" . }}-controller-{{ .Release.Namespace }}
+subjects:
+  - kind: ServiceAccount
+    name: {{ include "agent-stack-k8s.fullname" . }}-controller
+    namespace: {{ .Release.Namespace }}
+{{- end }}
@@ -0,0 +1,18 @@
+{{- with index .Values.config "scaler-port" -}}
+# Exposes the KEDA external scaler, for use as a ScaledObject's scalerAddress
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ include "agent-stack-k8s.fullname" $ }}-scaler
+  namespace: {{ $.Release.Namespace }}
+  labels:
+    {{- include "agent-stack-k8s.labels" $ | nindent 4 }}
+spec:
+  selector:
+    {{- include "agent-stack-k8s.mandatoryLabels" $ | nindent 4 }}
+  ports:
+    - name: scaler
+      port: {{ . }}
+      targetPort: scaler
+      appProtocol: grpc
+{{- end -}}
@@ -0,0 +1,318 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/version"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/go-playground/validator/v10"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+	restconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// Reasons a resource is deleted.
+const (
+	reasonOrphaned = "orphaned"
+	reasonFinished = "finished"
+	reasonVersion  = "version"
+)
+
+// keptJobStates are Buildkite job states in which the Kubernetes job is still
+// needed: an agent may be running the job, or (while it is scheduled) the job
+// is waiting for its pod to start. Jobs in these states are never deleted for
+// having been created by a different controller version.
+var keptJobStates = map[api.JobStates]bool{
+	api.JobStatesAccepted:  true,
+	api.JobStatesAssigned:  true,
+	api.JobStatesCanceling: true,
+	api.JobStatesRunning:   true,
+	api.JobStatesScheduled: true,
+	api.JobStatesTimingOut: true,
+}
+
+type Options struct {
+	Namespace       string        `validate:"required"`
+	OlderThan       time.Duration `validate:"min=0"`
+	DryRun          bool
+	BuildkiteToken  string `validate:"required"`
+	GraphQLEndpoint string
+}
+
+func (o *Options) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.Namespace, "namespace", config.DefaultNamespace, "kubernetes namespace to clean up")
+	cmd.Flags().DurationVar(&o.OlderThan, "older-than", 2*time.Hour, "only delete resources created (and for finished resources, finished) longer ago than this")
+	cmd.Flags().BoolVar(&o.DryRun, "dry-run", false, "print the resources that would be deleted, without deleting them")
+	cmd.Flags().StringVar(&o.BuildkiteToken, "buildkite-token", os.Getenv("BUILDKITE_TOKEN"), "Buildkite API token with GraphQL scopes (defaults to $BUILDKITE_TOKEN)")
+	cmd.Flags().StringVar(&o.GraphQLEndpoint, "graphql-endpoint", "", "Buildkite GraphQL endpoint URL")
+}
+
+func (o *Options) Validate() error {
+	return validator.New().Struct(o)
+}
+
+func New() *cobra.Command {
+	o := &Options{}
+
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Deletes stale Kubernetes resources left behind by the stack",
+		Long: `Finds the jobs, pods, and other resources labelled with a Buildkite job UUID,
+checks each job's state in Buildkite, and deletes the resources that are:
+
+  orphaned  the Buildkite job is over, but the resource hasn't finished
+  finished  the resource finished longer ago than --older-than
+  version   a Kubernetes job created by a different controller version, and
+            the Buildkite job is neither scheduled nor running
+
+Only resources created longer ago than --older-than are considered.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return fmt.Errorf("failed to validate options: %w", err)
+			}
+			clientConfig, err := restconfig.GetConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load kubernetes config: %w", err)
+			}
+			k8s, err := kubernetes.NewForConfig(clientConfig)
+			if err != nil {
+				return fmt.Errorf("failed to create clientset: %w", err)
+			}
+			gql := api.NewClient(o.BuildkiteToken, o.GraphQLEndpoint)
+			return Cleanup(cmd.Context(), k8s, gql, o, os.Stdout)
+		},
+	}
+	o.AddFlags(cmd)
+
+	return cmd
+}
+
+// resource is a Kubernetes object belonging to a Buildkite job.
+type resource struct {
+	kind     string
+	name     string
+	uid      types.UID
+	jobUUID  string
+	created  time.Time
+	finished time.Time // zero if the resource hasn't finished
+	version  string    // the controller version that created the resource, if known
+	delete   func(ctx context.Context, opts metav1.DeleteOptions) error
+}
+
+// Cleanup deletes (or with DryRun, lists) the stale resources in the
+// namespace, and prints a table of them to out.
+func Cleanup(ctx context.Context, k8s kubernetes.Interface, gql graphql.Client, o *Options, out io.Writer) error {
+	resources, err := listResources(ctx, k8s, o.Namespace)
+	if err != nil {
+		return err
+	}
+
+	uuids := make([]string, 0, len(resources))
+	for _, r := range resources {
+		uuids = append(uuids, r.jobUUID)
+	}
+	slices.Sort(uuids)
+	uuids = slices.Compact(uuids)
+
+	// Resources whose Buildkite job couldn't be queried (e.g. it no longer
+	// exists) are only deleted if they have finished.
+	states, queryErr := api.GetCommandJobStates(ctx, gql, uuids)
+	if queryErr != nil {
+		fmt.Fprintf(out, "Warning: couldn't query the state of every Buildkite job: %v\n", queryErr)
+	}
+
+	now := time.Now()
+	tw := table.NewWriter()
+	tw.SetStyle(table.StyleColoredDark)
+	tw.AppendHeader(table.Row{"KIND", "NAME", "JOB UUID", "AGE", "BUILDKITE STATE", "REASON", "RESULT"})
+
+	var errs []error
+	deleted := 0
+	for _, r := range resources {
+		state, known := states[r.jobUUID]
+		reason := staleReason(r, state, known, o.OlderThan, now)
+		if reason == "" {
+			continue
+		}
+		if !known {
+			state = "(unknown)"
+		}
+
+		result := "deleted"
+		switch {
+		case o.DryRun:
+			result = "would delete"
+		default:
+			err := r.delete(ctx, metav1.DeleteOptions{
+				PropagationPolicy: ptr.To(metav1.DeletePropagationBackground),
+				Preconditions:     &metav1.Preconditions{UID: ptr.To(r.uid)},
+			})
+			switch {
+			case kerrors.IsNotFound(err):
+				result = "already deleted"
+			case err != nil:
+				result = "error: " + string(kerrors.ReasonForError(err))
+				errs = append(errs, fmt.Errorf("deleting %s %s: %w", r.kind, r.name, err))
+			default:
+				deleted++
+			}
+		}
+		tw.AppendRow(table.Row{r.kind, r.name, r.jobUUID, duration.HumanDuration(now.Sub(r.created)), state, reason, result})
+	}
+
+	if tw.Length() == 0 {
+		fmt.Fprintf(out, "No stale resources found in namespace %q.\n", o.Namespace)
+		return errors.Join(errs...)
+	}
+	fmt.Fprintln(out, tw.Render())
+	if !o.DryRun {
+		fmt.Fprintf(out, "Deleted %d of %d stale resources.\n", deleted, tw.Length())
+	}
+	return errors.Join(errs...)
+}
+
+// staleReason returns why the resource should be deleted, or "" if it should
+// be kept.
+func staleReason(r resource, state api.JobStates, known bool, olderThan time.Duration, now time.Time) string {
+	switch {
+	case now.Sub(r.created) < olderThan:
+		return ""
+	case !r.finished.IsZero():
+		if now.Sub(r.finished) < olderThan {
+			return ""
+		}
+		return reasonFinished
+	case !known:
+		return ""
+	case state.Terminal():
+		return reasonOrphaned
+	case r.kind == "Job" && r.version != "" && r.version != version.Version() && !keptJobStates[state]:
+		// Resources without the annotation may have been created by
+		// anything, so only jobs known to be from another version are
+		// deleted. Deleting a job also deletes its pod.
+		return reasonVersion
+	}
+	return ""
+}
+
+// listResources lists the jobs, pods, config maps, and secrets in the
+// namespace that carry a Buildkite job UUID label. Pods owned by one of the
+// jobs are omitted, since deleting the job deletes them.
+func listResources(ctx context.Context, k8s kubernetes.Interface, namespace string) ([]resource, error) {
+	opts := metav1.ListOptions{LabelSelector: config.UUIDLabel}
+	var resources []resource
+
+	jobs, err := k8s.BatchV1().Jobs(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing jobs: %w", err)
+	}
+	jobUIDs := make(map[types.UID]bool, len(jobs.Items))
+	for _, job := range jobs.Items {
+		jobUIDs[job.UID] = true
+		r := newResource("Job", job.ObjectMeta, k8s.BatchV1().Jobs(namespace).Delete)
+		r.finished = jobFinishedAt(&job)
+		resources = append(resources, r)
+	}
+
+	pods, err := k8s.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+	for _, pod := range pods.Items {
+		if owner := metav1.GetControllerOf(&pod); owner != nil && jobUIDs[owner.UID] {
+			continue
+		}
+		r := newResource("Pod", pod.ObjectMeta, k8s.CoreV1().Pods(namespace).Delete)
+		r.finished = podFinishedAt(&pod)
+		resources = append(resources, r)
+	}
+
+	configMaps, err := k8s.CoreV1().ConfigMaps(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing config maps: %w", err)
+	}
+	for _, cm := range configMaps.Items {
+		resources = append(resources, newResource("ConfigMap", cm.ObjectMeta, k8s.CoreV1().ConfigMaps(namespace).Delete))
+	}
+
+	secrets, err := k8s.CoreV1().Secrets(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing secrets: %w", err)
+	}
+	for _, secret := range secrets.Items {
+		resources = append(resources, newResource("Secret", secret.ObjectMeta, k8s.CoreV1().Secrets(namespace).Delete))
+	}
+
+	slices.SortFunc(resources, func(a, b resource) int {
+		if c := strings.Compare(a.kind, b.kind); c != 0 {
+			return c
+		}
+		return strings.Compare(a.name, b.name)
+	})
+	return resources, nil
+}
+
+func newResource(kind string, meta metav1.ObjectMeta, del func(context.Context, string, metav1.DeleteOptions) error) resource {
+	return resource{
+		kind:    kind,
+		name:    meta.Name,
+		uid:     meta.UID,
+		jobUUID: meta.Labels[config.UUIDLabel],
+		created: meta.CreationTimestamp.Time,
+		version: meta.Annotations[config.ControllerVersionAnnotation],
+		delete: func(ctx context.Context, opts metav1.DeleteOptions) error {
+			return del(ctx, meta.Name, opts)
+		},
+	}
+}
+
+// jobFinishedAt returns when the job finished, or the zero time if it hasn't.
+func jobFinishedAt(job *batchv1.Job) time.Time {
+	if !model.JobFinished(job) {
+		return time.Time{}
+	}
+	for _, cond := range job.Status.Conditions {
+		switch cond.Type {
+		case batchv1.JobComplete, batchv1.JobFailed:
+			if cond.Status == corev1.ConditionTrue {
+				return cond.LastTransitionTime.Time
+			}
+		}
+	}
+	return job.CreationTimestamp.Time
+}
+
+// podFinishedAt returns when the pod's last container finished, or the zero
+// time if the pod hasn't finished.
+func podFinishedAt(pod *corev1.Pod) time.Time {
+	if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		return time.Time{}
+	}
+	finished := pod.CreationTimestamp.Time
+	for _, status := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		if term := status.State.Terminated; term != nil && term.FinishedAt.After(finished) {
+			finished = term.FinishedAt.Time
+		}
+	}
+	return finished
+}
@@ -0,0 +1,156 @@
+package cleanup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/google/go-cmp/cmp"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	states := map[string]api.JobStates{
+		"orphaned":    api.JobStatesFinished,
+		"finished":    api.JobStatesFinished,
+		"recent":      api.JobStatesCanceled,
+		"running":     api.JobStatesRunning,
+		"old-version": api.JobStatesWaitingFailed,
+		// Not deleted for their version.
+		"unannotated":           api.JobStatesWaitingFailed,
+		"scheduled-old-version": api.JobStatesScheduled,
+		"old-version-configmap": api.JobStatesWaitingFailed,
+	}
+	newJob := func(name string, age time.Duration, annotations map[string]string) *batchv1.Job {
+		if annotations == nil {
+			annotations = map[string]string{config.ControllerVersionAnnotation: "devel"}
+		}
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "buildkite",
+				UID:               types.UID(name),
+				Labels:            map[string]string{config.UUIDLabel: name},
+				Annotations:       annotations,
+				CreationTimestamp: metav1.NewTime(now.Add(-age)),
+			},
+		}
+	}
+	finished := newJob("finished", 3*time.Hour, nil)
+	finished.Status.Conditions = []batchv1.JobCondition{{
+		Type:               batchv1.JobComplete,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(now.Add(-150 * time.Minute)),
+	}}
+	oldVersion := map[string]string{config.ControllerVersionAnnotation: "v0.1.0"}
+	running := newJob("running", 3*time.Hour, oldVersion)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "old-version-configmap",
+			Namespace:         "buildkite",
+			Labels:            map[string]string{config.UUIDLabel: "old-version-configmap"},
+			Annotations:       oldVersion,
+			CreationTimestamp: metav1.NewTime(now.Add(-3 * time.Hour)),
+		},
+	}
+	ownedPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "orphaned-pod",
+			Namespace:         "buildkite",
+			Labels:            map[string]string{config.UUIDLabel: "orphaned"},
+			CreationTimestamp: metav1.NewTime(now.Add(-3 * time.Hour)),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Name:       "orphaned",
+				UID:        "orphaned",
+				Controller: ptr.To(true),
+			}},
+		},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data := make(map[string]any)
+		for name, jobUUID := range req.Variables {
+			data["j"+strings.TrimPrefix(name, "u")] = map[string]any{"uuid": jobUUID, "state": states[jobUUID]}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+	t.Cleanup(srv.Close)
+	gql := api.NewClient("token", srv.URL)
+
+	k8s := fake.NewClientset(
+		newJob("orphaned", 3*time.Hour, nil),
+		finished,
+		newJob("recent", 10*time.Minute, nil),
+		running,
+		newJob("old-version", 3*time.Hour, oldVersion),
+		newJob("unannotated", 3*time.Hour, map[string]string{}),
+		newJob("scheduled-old-version", 3*time.Hour, oldVersion),
+		configMap,
+		ownedPod,
+	)
+	remaining := func() []string {
+		t.Helper()
+		jobs, err := k8s.BatchV1().Jobs("buildkite").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("listing jobs: %v", err)
+		}
+		var names []string
+		for _, job := range jobs.Items {
+			names = append(names, job.Name)
+		}
+		slices.Sort(names)
+		return names
+	}
+	all := []string{"finished", "old-version", "orphaned", "recent", "running", "scheduled-old-version", "unannotated"}
+
+	opts := &Options{Namespace: "buildkite", OlderThan: 2 * time.Hour, DryRun: true}
+	var out bytes.Buffer
+	if err := Cleanup(ctx, k8s, gql, opts, &out); err != nil {
+		t.Fatalf("Cleanup(dry run) error = %v", err)
+	}
+	if diff := cmp.Diff(remaining(), all); diff != "" {
+		t.Errorf("jobs after dry run diff (-got +want):\n%s", diff)
+	}
+	if strings.Contains(out.String(), "orphaned-pod") {
+		t.Errorf("Cleanup(dry run) output includes a pod owned by a job:\n%s", out.String())
+	}
+
+	opts.DryRun = false
+	out.Reset()
+	if err := Cleanup(ctx, k8s, gql, opts, &out); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	if diff := cmp.Diff(remaining(), []string{"recent", "running", "scheduled-old-version", "unannotated"}); diff != "" {
+		t.Errorf("jobs after cleanup diff (-got +want):\n%s\noutput:\n%s", diff, out.String())
+	}
+	if _, err := k8s.CoreV1().ConfigMaps("buildkite").Get(ctx, configMap.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("Get(ConfigMap from a different controller version) error = %v, want it kept", err)
+	}
+}
@@ -10,7 +10,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/buildkite/agent-stack-k8s/v2/cmd/cleanup"
+	"github.com/buildkite/agent-stack-k8s/v2/cmd/explain"
 	"github.com/buildkite/agent-stack-k8s/v2/cmd/linter"
+	"github.com/buildkite/agent-stack-k8s/v2/cmd/status"
 	"github.com/buildkite/agent-stack-k8s/v2/cmd/version"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
@@ -35,8 +38,7 @@ import (
 var configFile string
 
 func AddConfigFlags(cmd *cobra.Command) {
-	// the config file flag
-	cmd.Flags().StringVarP(&configFile, "config", "f", "", "config file path")
+	addQueueFlags(cmd)
 
 	// not in the config file
 	cmd.Flags().String(
@@ -45,6 +47,11 @@ func AddConfigFlags(cmd *cobra.Command) {
 		"name of the Buildkite agent token secret",
 	)
 	cmd.Flags().String("buildkite-token", "", "Buildkite API token with GraphQL scopes")
+	cmd.Flags().String(
+		"buildkite-token-file",
+		"",
+		"Path to a file containing the Buildkite API token, which is reloaded when it changes (instead of buildkite-token)",
+	)
 
 	// in the config file
 	cmd.Flags().String("org", "", "Buildkite organization name to watch")
@@ -53,18 +60,7 @@ func AddConfigFlags(cmd *cobra.Command) {
 		config.DefaultAgentImage,
 		"The image to use for the Buildkite agent",
 	)
-	cmd.Flags().StringSlice(
-		"tags",
-		[]string{"queue=kubernetes"},
-		`A comma-separated list of agent tags. The "queue" tag must be unique (e.g. "queue=kubernetes,os=linux")`,
-	)
-	cmd.Flags().String(
-		"namespace",
-		config.DefaultNamespace,
-		"kubernetes namespace to create resources in",
-	)
 	cmd.Flags().Bool("debug", false, "debug logs")
-	cmd.Flags().Int("max-in-flight", 25, "max jobs in flight, 0 means no max")
 	cmd.Flags().Duration(
 		"job-ttl",
 		10*time.Minute,
@@ -95,6 +91,16 @@ func AddConfigFlags(cmd *cobra.Command) {
 		0,
 		"Bind port to expose Prometheus /metrics; 0 disables it",
 	)
+	cmd.Flags().Uint16(
+		"health-port",
+		0,
+		"Bind port to expose /healthz and /readyz; 0 disables it",
+	)
+	cmd.Flags().Uint16(
+		"scaler-port",
+		0,
+		"Bind port to serve the queue's backlog as a KEDA external scaler over gRPC; 0 disables it",
+	)
 	cmd.Flags().String("graphql-endpoint", "", "Buildkite GraphQL endpoint URL")
 
 	cmd.Flags().Duration(
@@ -132,6 +138,31 @@ func AddConfigFlags(cmd *cobra.Command) {
 		config.DefaultEmptyJobGracePeriod,
 		"Duration after starting a Kubernetes job that the controller will wait before considering failing the job due to a missing pod (e.g. when the podSpec specifies a missing service account)",
 	)
+	cmd.Flags().Int(
+		"sidecar-log-tail-lines",
+		config.DefaultSidecarLogTailLines,
+		"Number of lines from the end of a sidecar container's log to make available to the job in /workspace/sidecar-logs when the sidecar exits, restarts or stops being ready; 0 disables it",
+	)
+	cmd.Flags().Duration(
+		"unschedulable-grace-period",
+		0,
+		"Duration a pod may remain unschedulable before the controller fails the job and evicts the pod; 0 disables it, leaving the pod pending until the job's active deadline",
+	)
+	cmd.Flags().Duration(
+		"orphaned-job-grace-period",
+		0,
+		"Duration an unfinished Kubernetes job may remain after its Buildkite job is cancelled, finished, or expired before the controller deletes it; 0 disables it",
+	)
+	cmd.Flags().Int(
+		"oom-killed-exit-status",
+		config.DefaultOOMKilledExitStatus,
+		"Exit status for jobs that fail because an init container was OOMKilled",
+	)
+	cmd.Flags().Int(
+		"preemption-exit-status",
+		config.DefaultPreemptionExitStatus,
+		"Exit status for jobs that fail because their pod was preempted, evicted, or lost with its node before an agent acquired the job (e.g. for use with automatic_retry); jobs an agent already acquired finish with the agent's exit status, and the build is annotated instead",
+	)
 	cmd.Flags().String(
 		"default-image-pull-policy",
 		"",
@@ -152,11 +183,36 @@ func AddConfigFlags(cmd *cobra.Command) {
 		config.DefaultGraphQLResultsLimit,
 		"Sets the amount of results returned by GraphQL queries when retreiving Jobs to be Scheduled",
 	)
+	cmd.Flags().String(
+		"admission-mode",
+		"",
+		`Create Kubernetes jobs suspended and leave admitting them to Kueue ("kueue") or the built-in admission loop ("builtin", limited by max-in-flight) instead of the limiter`,
+	)
+	cmd.Flags().String(
+		"kueue-queue-name",
+		"",
+		`Kueue LocalQueue name to label jobs with when admission-mode is "kueue"`,
+	)
+	cmd.Flags().Bool(
+		"enable-limiter-preemption",
+		false,
+		"When max-in-flight is reached, allow a waiting job to delete a lower-priority Kubernetes job whose pod has not started yet; the preempted Buildkite job is scheduled again later",
+	)
 	cmd.Flags().Bool(
 		"enable-queue-pause",
 		false,
 		"Allow controller to pause processing the jobs when queue is paused on Buildkite",
 	)
+	cmd.Flags().String(
+		"tracing-endpoint",
+		"",
+		"URL of an OTLP gRPC endpoint to export traces of each job's path through the controller to (e.g. http://otel-collector:4317); empty disables tracing",
+	)
+	cmd.Flags().Bool(
+		"startup-metrics-pipeline-label",
+		false,
+		"Label the pod startup latency histograms with the pipeline slug, in addition to the queue; this multiplies the number of series by the number of pipelines",
+	)
 	cmd.Flags().Bool(
 		"allow-pod-spec-patch-unsafe-command-modification",
 		false,
@@ -164,6 +220,36 @@ func AddConfigFlags(cmd *cobra.Command) {
 	)
 }
 
+// addQueueFlags adds the config file flag, and the flags that select the
+// controller's jobs. Subcommands that look at a queue's jobs share them.
+func addQueueFlags(cmd *cobra.Command) {
+	// the config file flag
+	cmd.Flags().StringVarP(&configFile, "config", "f", "", "config file path")
+
+	// in the config file
+	cmd.Flags().StringSlice(
+		"tags",
+		[]string{"queue=kubernetes"},
+		`A comma-separated list of agent tags. The "queue" tag must be unique (e.g. "queue=kubernetes,os=linux")`,
+	)
+	cmd.Flags().String(
+		"namespace",
+		config.DefaultNamespace,
+		"kubernetes namespace to create resources in",
+	)
+	cmd.Flags().Int("max-in-flight", 25, "max jobs in flight, 0 means no max")
+}
+
+// readConfig reads and parses the config without validating it, for
+// subcommands that don't need all of it (e.g. the Buildkite API token).
+func readConfig(cmd *cobra.Command, args []string) (*config.Config, error) {
+	v, err := ReadConfigFromFileArgsAndEnv(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(v)
+}
+
 // ReadConfigFromFileArgsAndEnv reads the config from the file, env and args in that order.
 // an excaption is the path to the config file which is read from the args and env only.
 func ReadConfigFromFileArgsAndEnv(cmd *cobra.Command, args []string) (*viper.Viper, error) {
@@ -258,8 +344,8 @@ func useJSONTagForDecoder(c *mapstructure.DecoderConfig) {
 	c.TagName = "json"
 }
 
-// ParseAndValidateConfig parses the config into a struct and validates the values.
-func ParseAndValidateConfig(v *viper.Viper) (*config.Config, error) {
+// ParseConfig parses the config into a struct, without validating the values.
+func ParseConfig(v *viper.Viper) (*config.Config, error) {
 	// We want to let the user know if they have any extra fields, so use UnmarshalExact.
 	// The user likely expects every part of their config to be meaningful, so if some of it is
 	// ignored in parsing, they almost certainly want to know about it.
@@ -275,11 +361,32 @@ func ParseAndValidateConfig(v *viper.Viper) (*config.Config, error) {
 	if err := v.UnmarshalExact(cfg, useJSONTagForDecoder, decodeHook); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	return cfg, nil
+}
+
+// ParseAndValidateConfig parses the config into a struct and validates the values.
+func ParseAndValidateConfig(v *viper.Viper) (*config.Config, error) {
+	cfg, err := ParseConfig(v)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := validate.Struct(cfg); err != nil {
 		return nil, fmt.Errorf("failed to validate config: %w", err)
 	}
 
+	if err := cfg.JobEnv.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	if err := cfg.PipelineSecrets.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
+	if err := cfg.FailureExitStatuses.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate config: %w", err)
+	}
+
 	if cfg.PodSpecPatch != nil {
 		for _, c := range cfg.PodSpecPatch.Containers {
 			if len(c.Command) != 0 || len(c.Args) != 0 {
@@ -351,7 +458,10 @@ func New() *cobra.Command {
 	}
 
 	AddConfigFlags(cmd)
+	cmd.AddCommand(cleanup.New())
+	cmd.AddCommand(explain.New())
 	cmd.AddCommand(linter.New())
+	cmd.AddCommand(status.New(addQueueFlags, readConfig))
 	cmd.AddCommand(version.New())
 	if err := en_translations.RegisterDefaultTranslations(validate, trans); err != nil {
 		log.Fatalf("failed to register translations: %v", err)
@@ -28,6 +28,8 @@ func TestReadAndParseConfig(t *testing.T) {
 		ImagePullBackOffGracePeriod:  60 * time.Second,
 		JobCancelCheckerPollInterval: 10 * time.Second,
 		EmptyJobGracePeriod:          50 * time.Second,
+		PreemptionExitStatus:         75,
+		OOMKilledExitStatus:          137,
 		PollInterval:                 5 * time.Second,
 		StaleJobDataTimeout:          10 * time.Second,
 		JobCreationConcurrency:       5,
@@ -167,3 +169,18 @@ func TestReadAndParseConfig(t *testing.T) {
 		t.Errorf("parsed config diff (-got +want):\n%s", diff)
 	}
 }
+
+func TestStatusCommandSharesQueueFlags(t *testing.T) {
+	root := controller.New()
+	status, _, err := root.Find([]string{"status"})
+	require.NoError(t, err)
+
+	for _, name := range []string{"config", "namespace", "tags", "max-in-flight"} {
+		want := root.Flags().Lookup(name)
+		got := status.Flags().Lookup(name)
+		require.NotNil(t, got, "status flag %q", name)
+		if got.DefValue != want.DefValue || got.Usage != want.Usage {
+			t.Errorf("status flag %q = (%q, %q), want (%q, %q)", name, got.DefValue, got.Usage, want.DefValue, want.Usage)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package explain
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/scheduler"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Waiting reasons that mean an image can't be pulled.
+var imagePullReasons = []string{"ErrImagePull", "ImagePullBackOff", "InvalidImageName", "ErrImageNeverPull"}
+
+// diagnose returns the likely causes of the job's problems, most fundamental
+// first.
+func diagnose(f *facts) []string {
+	var findings []string
+	add := func(format string, args ...any) {
+		findings = append(findings, fmt.Sprintf(format, args...))
+	}
+
+	if len(f.kjobs) == 0 && len(f.pods) == 0 {
+		add("There is no Kubernetes job for this Buildkite job. Either the controller hasn't created one (check that the job's agent tags match the controller's, and whether max-in-flight has been reached), or it was deleted after job-ttl.")
+	}
+
+	for _, kjob := range f.kjobs {
+		if kjob.Spec.Suspend != nil && *kjob.Spec.Suspend && !model.JobFinished(&kjob) {
+			add("Kubernetes job %s is suspended, waiting to be admitted (see admission-mode).", kjob.Name)
+		}
+		for _, cond := range kjob.Status.Conditions {
+			if cond.Type != batchv1.JobFailed || cond.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch cond.Reason {
+			case batchv1.JobReasonDeadlineExceeded:
+				add("Kubernetes job %s ran for longer than its active deadline (job-active-deadline-seconds, or jobActiveDeadlineSeconds in the kubernetes plugin).", kjob.Name)
+			case batchv1.JobReasonBackoffLimitExceeded:
+				// The pod failing is explained below.
+			default:
+				add("Kubernetes job %s failed: %s: %s", kjob.Name, cond.Reason, cond.Message)
+			}
+		}
+	}
+
+	for _, pod := range f.pods {
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse {
+				add("Pod %s couldn't be scheduled: %s", pod.Name, cond.Message)
+			}
+		}
+		if pod.Status.Reason != "" {
+			add("Pod %s was %s: %s", pod.Name, strings.ToLower(pod.Status.Reason), pod.Status.Message)
+		}
+		memoryLimits := make(map[string]string)
+		for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+			if mem, ok := c.Resources.Limits[corev1.ResourceMemory]; ok {
+				memoryLimits[c.Name] = mem.String()
+			}
+		}
+		for _, s := range containerStatuses(&pod) {
+			diagnoseContainer(s, memoryLimits[s.Name], add)
+		}
+	}
+
+	if f.stateKnown && f.state.Terminal() {
+		for _, kjob := range f.kjobs {
+			if !model.JobFinished(&kjob) && !model.JobReplaced(&kjob) && kjob.DeletionTimestamp == nil {
+				add("The Buildkite job is %s, but Kubernetes job %s hasn't finished, so it is orphaned (see orphaned-job-grace-period, or the cleanup command).", f.state, kjob.Name)
+			}
+		}
+	}
+
+	if len(findings) == 0 {
+		add("No problems found.")
+	}
+	return findings
+}
+
+func diagnoseContainer(s status, memoryLimit string, add func(string, ...any)) {
+	if waiting := s.State.Waiting; waiting != nil {
+		switch {
+		case slices.Contains(imagePullReasons, waiting.Reason):
+			add("Container %s can't pull image %s: %s", s.Name, s.image, waiting.Message)
+		case waiting.Reason == "CreateContainerConfigError", waiting.Reason == "CreateContainerError":
+			add("Container %s couldn't be created: %s", s.Name, waiting.Message)
+		}
+	}
+
+	for _, term := range []*corev1.ContainerStateTerminated{s.LastTerminationState.Terminated, s.State.Terminated} {
+		if term == nil {
+			continue
+		}
+		if term.Reason == "OOMKilled" {
+			if memoryLimit == "" {
+				memoryLimit = "(none)"
+			}
+			add("Container %s was OOMKilled: it exceeded its memory limit of %s.", s.Name, memoryLimit)
+			continue
+		}
+		if term.ExitCode == 0 {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(s.Name, scheduler.ImageCheckContainerNamePrefix):
+			add("Image %s couldn't be pulled or run: container %s exited with status %d.", s.image, s.Name, term.ExitCode)
+		case s.Name == scheduler.CopyAgentContainerName:
+			add("Copying the agent binary into the pod failed with exit status %d.", term.ExitCode)
+		case s.Name == scheduler.CheckoutContainerName:
+			add("The checkout failed with exit status %d; see the job log in Buildkite.", term.ExitCode)
+		case s.Name == scheduler.AgentContainerName:
+			add("The agent exited with status %d; see the job log in Buildkite.", term.ExitCode)
+		default:
+			add("Container %s (%s) exited with status %d.", s.Name, s.description, term.ExitCode)
+		}
+	}
+}
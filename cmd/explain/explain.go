@@ -0,0 +1,380 @@
+package explain
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/scheduler"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	restconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+type Options struct {
+	Namespace       string `validate:"required"`
+	BuildkiteToken  string
+	GraphQLEndpoint string
+}
+
+func (o *Options) AddFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&o.Namespace, "namespace", config.DefaultNamespace, "kubernetes namespace the controller creates resources in")
+	cmd.Flags().StringVar(&o.BuildkiteToken, "buildkite-token", os.Getenv("BUILDKITE_TOKEN"), "Buildkite API token with GraphQL scopes, for looking up the job's state (defaults to $BUILDKITE_TOKEN)")
+	cmd.Flags().StringVar(&o.GraphQLEndpoint, "graphql-endpoint", "", "Buildkite GraphQL endpoint URL")
+}
+
+func (o *Options) Validate() error {
+	return validator.New().Struct(o)
+}
+
+func New() *cobra.Command {
+	o := &Options{}
+
+	cmd := &cobra.Command{
+		Use:   "explain <job-uuid|job-url>",
+		Short: "Prints a timeline of a Buildkite job's Kubernetes resources, and what probably went wrong",
+		Long: `Gathers the Kubernetes job and pods for a Buildkite job, their container
+statuses and events, and the job's state in Buildkite, and prints them as a
+timeline followed by a diagnosis of the likely cause of any failure.`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.Validate(); err != nil {
+				return fmt.Errorf("failed to validate options: %w", err)
+			}
+			jobUUID, err := parseJobRef(args[0])
+			if err != nil {
+				return err
+			}
+			clientConfig, err := restconfig.GetConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load kubernetes config: %w", err)
+			}
+			k8s, err := kubernetes.NewForConfig(clientConfig)
+			if err != nil {
+				return fmt.Errorf("failed to create clientset: %w", err)
+			}
+			var gql graphql.Client
+			if o.BuildkiteToken != "" {
+				gql = api.NewClient(o.BuildkiteToken, o.GraphQLEndpoint)
+			}
+			return Explain(cmd.Context(), k8s, gql, o.Namespace, jobUUID, os.Stdout)
+		},
+	}
+	o.AddFlags(cmd)
+
+	return cmd
+}
+
+// parseJobRef returns the job UUID from a job UUID or a Buildkite job URL.
+// Job URLs have the UUID in the fragment (…/builds/1#<uuid>), in the jid
+// query parameter, or as the last path segment (…/jobs/<uuid>).
+func parseJobRef(ref string) (string, error) {
+	if id, err := uuid.Parse(ref); err == nil {
+		return id.String(), nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("%q is neither a job UUID nor a job URL", ref)
+	}
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	for _, candidate := range []string{u.Fragment, u.Query().Get("jid"), segments[len(segments)-1]} {
+		if id, err := uuid.Parse(candidate); err == nil {
+			return id.String(), nil
+		}
+	}
+	return "", fmt.Errorf("couldn't find a job UUID in URL %q", ref)
+}
+
+// facts is everything known about a Buildkite job.
+type facts struct {
+	jobUUID string
+
+	// The job's state in Buildkite, if it could be queried.
+	state      api.JobStates
+	stateKnown bool
+	stateErr   error
+
+	kjobs  []batchv1.Job
+	pods   []corev1.Pod
+	events []corev1.Event
+}
+
+// Explain gathers what is known about the job, and prints a timeline and a
+// diagnosis to out. gql may be nil, in which case the job's Buildkite state is
+// not queried.
+func Explain(ctx context.Context, k8s kubernetes.Interface, gql graphql.Client, namespace, jobUUID string, out io.Writer) error {
+	f, err := gather(ctx, k8s, gql, namespace, jobUUID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Buildkite job:   %s\n", jobUUID)
+	for _, kjob := range f.kjobs {
+		if u := kjob.Annotations[config.JobURLAnnotation]; u != "" {
+			fmt.Fprintf(out, "URL:             %s\n", u)
+			break
+		}
+	}
+	switch {
+	case f.stateKnown:
+		fmt.Fprintf(out, "Buildkite state: %s\n", f.state)
+	case f.stateErr != nil:
+		fmt.Fprintf(out, "Buildkite state: unknown (%v)\n", f.stateErr)
+	default:
+		fmt.Fprintln(out, "Buildkite state: unknown (no API token)")
+	}
+	fmt.Fprintln(out)
+
+	entries := timeline(f)
+	if len(entries) > 0 {
+		fmt.Fprintln(out, renderTimeline(entries))
+		fmt.Fprintln(out)
+	}
+
+	fmt.Fprintln(out, "Diagnosis:")
+	for _, finding := range diagnose(f) {
+		fmt.Fprintf(out, "  - %s\n", finding)
+	}
+	return nil
+}
+
+func gather(ctx context.Context, k8s kubernetes.Interface, gql graphql.Client, namespace, jobUUID string) (*facts, error) {
+	f := &facts{jobUUID: jobUUID}
+
+	if gql != nil {
+		resp, err := api.GetCommandJob(ctx, gql, jobUUID)
+		if err != nil {
+			f.stateErr = err
+		} else if job, ok := resp.Job.(*api.GetCommandJobJobJobTypeCommand); ok {
+			f.state, f.stateKnown = job.State, true
+		} else {
+			f.stateErr = errors.New("not found, or not a command job")
+		}
+	}
+
+	// A Buildkite job can have several Kubernetes jobs, if it was replaced.
+	opts := metav1.ListOptions{LabelSelector: config.UUIDLabel + "=" + jobUUID}
+	kjobs, err := k8s.BatchV1().Jobs(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing jobs: %w", err)
+	}
+	f.kjobs = kjobs.Items
+	pods, err := k8s.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+	f.pods = pods.Items
+
+	for _, kjob := range f.kjobs {
+		evlist, err := scheduler.ListEvents(ctx, k8s, namespace, "Job", kjob.Name, "")
+		if err != nil {
+			return nil, fmt.Errorf("listing events for job %s: %w", kjob.Name, err)
+		}
+		f.events = append(f.events, evlist.Items...)
+	}
+	for _, pod := range f.pods {
+		evlist, err := scheduler.ListEvents(ctx, k8s, namespace, "Pod", pod.Name, "")
+		if err != nil {
+			return nil, fmt.Errorf("listing events for pod %s: %w", pod.Name, err)
+		}
+		f.events = append(f.events, evlist.Items...)
+	}
+	return f, nil
+}
+
+// entry is a point on the timeline. Entries with a zero time describe the
+// current state of something, and are listed last.
+type entry struct {
+	time    time.Time
+	object  string
+	message string
+}
+
+func timeline(f *facts) []entry {
+	var entries []entry
+	add := func(t metav1.Time, object, format string, args ...any) {
+		entries = append(entries, entry{time: t.Time, object: object, message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, kjob := range f.kjobs {
+		object := "Job " + kjob.Name
+		add(kjob.CreationTimestamp, object, "Created")
+		for _, cond := range kjob.Status.Conditions {
+			if cond.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch cond.Type {
+			case batchv1.JobSuspended:
+				add(cond.LastTransitionTime, object, "Suspended, waiting to be admitted")
+			case batchv1.JobComplete:
+				add(cond.LastTransitionTime, object, "Completed")
+			case batchv1.JobFailed:
+				add(cond.LastTransitionTime, object, "Failed: %s: %s", cond.Reason, cond.Message)
+			}
+		}
+		if replacement := kjob.Annotations[config.ReplacedByAnnotation]; replacement != "" {
+			add(metav1.Time{}, object, "Replaced by job %s", replacement)
+		}
+		if kjob.DeletionTimestamp != nil {
+			add(*kjob.DeletionTimestamp, object, "Deleting")
+		}
+	}
+
+	for _, pod := range f.pods {
+		object := "Pod " + pod.Name
+		add(pod.CreationTimestamp, object, "Created")
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type != corev1.PodScheduled {
+				continue
+			}
+			if cond.Status == corev1.ConditionTrue {
+				add(cond.LastTransitionTime, object, "Scheduled on node %s", pod.Spec.NodeName)
+			} else {
+				add(cond.LastTransitionTime, object, "Not scheduled: %s: %s", cond.Reason, cond.Message)
+			}
+		}
+		for _, s := range containerStatuses(&pod) {
+			object := "Container " + s.Name
+			for _, term := range []*corev1.ContainerStateTerminated{s.LastTerminationState.Terminated, s.State.Terminated} {
+				if term == nil {
+					continue
+				}
+				add(term.StartedAt, object, "Started (%s)", s.description)
+				add(term.FinishedAt, object, "Finished with exit status %d%s", term.ExitCode, reasonSuffix(term.Reason))
+			}
+			if running := s.State.Running; running != nil {
+				add(running.StartedAt, object, "Started (%s), still running", s.description)
+			}
+			if waiting := s.State.Waiting; waiting != nil {
+				add(metav1.Time{}, object, "Waiting (%s)%s", s.description, reasonSuffix(waiting.Reason))
+			}
+		}
+		if pod.Status.Reason != "" {
+			add(metav1.Time{}, object, "%s: %s", pod.Status.Reason, pod.Status.Message)
+		}
+	}
+
+	for _, ev := range f.events {
+		message := fmt.Sprintf("Event %s %s: %s", ev.Type, ev.Reason, ev.Message)
+		if count := eventCount(&ev); count > 1 {
+			message += fmt.Sprintf(" (x%d)", count)
+		}
+		entries = append(entries, entry{
+			time:    eventTime(&ev),
+			object:  ev.InvolvedObject.Kind + " " + ev.InvolvedObject.Name,
+			message: message,
+		})
+	}
+
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		switch {
+		case a.time.IsZero() && b.time.IsZero():
+			return 0
+		case a.time.IsZero():
+			return 1
+		case b.time.IsZero():
+			return -1
+		}
+		return a.time.Compare(b.time)
+	})
+	return entries
+}
+
+func renderTimeline(entries []entry) string {
+	tw := table.NewWriter()
+	tw.SetStyle(table.StyleColoredDark)
+	tw.AppendHeader(table.Row{"TIME", "+", "OBJECT", "WHAT HAPPENED"})
+	start := entries[0].time
+	for _, e := range entries {
+		when, offset := "now", "-"
+		if !e.time.IsZero() {
+			when = e.time.UTC().Format(time.RFC3339)
+			offset = e.time.Sub(start).String()
+		}
+		tw.AppendRow(table.Row{when, offset, e.object, e.message})
+	}
+	return tw.Render()
+}
+
+// status is a container status, along with a description of what the
+// container is for.
+type status struct {
+	corev1.ContainerStatus
+	init        bool
+	image       string
+	description string
+}
+
+// containerStatuses returns the statuses of the pod's init containers, then
+// its other containers.
+func containerStatuses(pod *corev1.Pod) []status {
+	images := make(map[string]string)
+	for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		images[c.Name] = c.Image
+	}
+	var statuses []status
+	for _, s := range pod.Status.InitContainerStatuses {
+		statuses = append(statuses, status{ContainerStatus: s, init: true, image: images[s.Name], description: describeContainer(s.Name, true, images[s.Name])})
+	}
+	for _, s := range pod.Status.ContainerStatuses {
+		statuses = append(statuses, status{ContainerStatus: s, image: images[s.Name], description: describeContainer(s.Name, false, images[s.Name])})
+	}
+	return statuses
+}
+
+func describeContainer(name string, init bool, image string) string {
+	switch {
+	case name == scheduler.CopyAgentContainerName:
+		return "copies the agent binary"
+	case strings.HasPrefix(name, scheduler.ImageCheckContainerNamePrefix):
+		return "checks image " + image + " can be pulled"
+	case name == scheduler.CheckoutContainerName:
+		return "checkout"
+	case name == scheduler.AgentContainerName:
+		return "agent"
+	case init:
+		return "init container"
+	case strings.HasPrefix(name, "sidecar-"):
+		return "sidecar"
+	default:
+		return "command"
+	}
+}
+
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return ": " + reason
+}
+
+// eventTime returns when the event first happened. Events from different
+// sources set different timestamp fields.
+func eventTime(ev *corev1.Event) time.Time {
+	return cmp.Or(ev.FirstTimestamp.Time, ev.EventTime.Time, ev.LastTimestamp.Time, ev.CreationTimestamp.Time)
+}
+
+func eventCount(ev *corev1.Event) int32 {
+	if ev.Series != nil {
+		return ev.Series.Count
+	}
+	return ev.Count
+}
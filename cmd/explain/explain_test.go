@@ -0,0 +1,150 @@
+package explain
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+const testJobUUID = "01938f2a-4d7e-4a8b-9c1d-2e3f4a5b6c7d"
+
+func TestParseJobRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		wantErr bool
+	}{
+		{ref: testJobUUID},
+		{ref: "https://buildkite.com/acme/app/builds/42#" + testJobUUID},
+		{ref: "https://buildkite.com/acme/app/builds/42/steps/canvas?jid=" + testJobUUID},
+		{ref: "https://buildkite.com/organizations/acme/pipelines/app/builds/42/jobs/" + testJobUUID},
+		{ref: "https://buildkite.com/acme/app/builds/42", wantErr: true},
+		{ref: "not-a-job", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			got, err := parseJobRef(test.ref)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("parseJobRef(%q) = %q, want error", test.ref, got)
+				}
+				return
+			}
+			if err != nil || got != testJobUUID {
+				t.Errorf("parseJobRef(%q) = %q, %v, want %q, nil", test.ref, got, err, testJobUUID)
+			}
+		})
+	}
+}
+
+func TestExplain(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) metav1.Time { return metav1.NewTime(start.Add(d)) }
+	labels := map[string]string{config.UUIDLabel: testJobUUID}
+
+	kjob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "buildkite-" + testJobUUID,
+			Namespace:         "buildkite",
+			UID:               "job-uid",
+			Labels:            labels,
+			CreationTimestamp: at(0),
+		},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "buildkite-" + testJobUUID + "-abcde",
+			Namespace:         "buildkite",
+			Labels:            labels,
+			CreationTimestamp: at(time.Second),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Name:       kjob.Name,
+				UID:        kjob.UID,
+				Controller: ptr.To(true),
+			}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "node-1",
+			InitContainers: []corev1.Container{
+				{Name: "copy-agent", Image: "buildkite/agent"},
+				{Name: "imagecheck-0", Image: "ruby:nope"},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			Conditions: []corev1.PodCondition{{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: at(2 * time.Second),
+			}},
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: "copy-agent",
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+						StartedAt:  at(3 * time.Second),
+						FinishedAt: at(4 * time.Second),
+						Reason:     "Completed",
+					}},
+				},
+				{
+					Name: "imagecheck-0",
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+						StartedAt:  at(5 * time.Second),
+						FinishedAt: at(6 * time.Second),
+						ExitCode:   1,
+						Reason:     "Error",
+					}},
+				},
+			},
+		},
+	}
+	event := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "pod-event", Namespace: "buildkite"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod.Name},
+		Type:           corev1.EventTypeWarning,
+		Reason:         "Failed",
+		Message:        `Failed to pull image "ruby:nope"`,
+		FirstTimestamp: at(5500 * time.Millisecond),
+	}
+
+	k8s := fake.NewClientset(kjob, pod, event)
+	var out bytes.Buffer
+	if err := Explain(context.Background(), k8s, nil, "buildkite", testJobUUID, &out); err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+	got := out.String()
+
+	// The timeline is in chronological order.
+	wantOrder := []string{
+		"Created",
+		"Scheduled on node node-1",
+		"Started (copies the agent binary)",
+		"Started (checks image ruby:nope can be pulled)",
+		`Failed to pull image "ruby:nope"`,
+		"Finished with exit status 1: Error",
+		"Diagnosis:",
+		"Image ruby:nope couldn't be pulled or run: container imagecheck-0 exited with status 1.",
+	}
+	rest := got
+	for _, want := range wantOrder {
+		i := strings.Index(rest, want)
+		if i < 0 {
+			t.Fatalf("Explain() output doesn't contain %q after the previous lines; output:\n%s", want, got)
+		}
+		rest = rest[i+len(want):]
+	}
+	if !strings.Contains(got, "Buildkite state: unknown (no API token)") {
+		t.Errorf("Explain() output doesn't mention the unknown Buildkite state; output:\n%s", got)
+	}
+}
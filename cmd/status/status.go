@@ -0,0 +1,238 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+	restconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// Output formats.
+const (
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// Phases reported for jobs that don't have a pod.
+const (
+	phaseSuspended = "Suspended"
+	phaseNoPod     = "NoPod"
+)
+
+// Report is the live view of the jobs in flight for a queue.
+type Report struct {
+	Namespace string `json:"namespace"`
+	Selector  string `json:"selector"`
+
+	// Limiter occupancy. MaxInFlight is 0 if there is no limit.
+	MaxInFlight int `json:"maxInFlight"`
+	InFlight    int `json:"inFlight"`
+
+	Jobs []Job `json:"jobs"`
+}
+
+// Job is a Buildkite job in flight, and the Kubernetes resources running it.
+type Job struct {
+	UUID      string    `json:"uuid"`
+	K8sJob    string    `json:"k8sJob"`
+	Pod       string    `json:"pod,omitempty"`
+	Phase     string    `json:"phase"`
+	Node      string    `json:"node,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Priority  string    `json:"priority,omitempty"`
+	URL       string    `json:"url,omitempty"`
+}
+
+// New returns the status command. It reads the same config as the controller,
+// but only needs the settings that select its jobs, so the config doesn't have
+// to include a Buildkite API token. The controller command passes in the
+// flags for those settings and how to read the config, so they are defined
+// once.
+func New(addQueueFlags func(*cobra.Command), readConfig func(*cobra.Command, []string) (*config.Config, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "status",
+		Short:        "Prints the jobs in flight for the configured queue",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := readConfig(configCommand(cmd), args)
+			if err != nil {
+				return err
+			}
+			format, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+
+			clientConfig, err := restconfig.GetConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load kubernetes config: %w", err)
+			}
+			k8s, err := kubernetes.NewForConfig(clientConfig)
+			if err != nil {
+				return fmt.Errorf("failed to create clientset: %w", err)
+			}
+
+			report, err := Gather(cmd.Context(), k8s, cfg)
+			if err != nil {
+				return err
+			}
+			return Print(os.Stdout, report, format, time.Now())
+		},
+	}
+	addQueueFlags(cmd)
+	cmd.Flags().StringP("output", "o", FormatTable, `output format: "table" or "json"`)
+
+	return cmd
+}
+
+// configCommand returns a command with all the flags of cmd except those that
+// only the status command has, for readConfig. The controller binds every
+// flag it's given to a config key, and these aren't config.
+func configCommand(cmd *cobra.Command) *cobra.Command {
+	cfgCmd := &cobra.Command{Use: cmd.Use}
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Name != "output" {
+			cfgCmd.Flags().AddFlag(f)
+		}
+	})
+	return cfgCmd
+}
+
+// Gather lists the jobs and pods matching the controller's label selector,
+// and reports the jobs that are in flight. A job is in flight if it counts
+// towards max-in-flight: it hasn't finished, and hasn't been replaced.
+func Gather(ctx context.Context, k8s kubernetes.Interface, cfg *config.Config) (*Report, error) {
+	selector, err := controller.JobSelector(cfg.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("building label selector: %w", err)
+	}
+	opts := metav1.ListOptions{LabelSelector: selector.String()}
+
+	jobs, err := k8s.BatchV1().Jobs(cfg.Namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing jobs: %w", err)
+	}
+	pods, err := k8s.CoreV1().Pods(cfg.Namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("listing pods: %w", err)
+	}
+
+	// The newest pod of each job.
+	podsByJob := make(map[types.UID]*corev1.Pod)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil {
+			continue
+		}
+		if prev := podsByJob[owner.UID]; prev == nil || prev.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			podsByJob[owner.UID] = pod
+		}
+	}
+
+	report := &Report{
+		Namespace:   cfg.Namespace,
+		Selector:    selector.String(),
+		MaxInFlight: cfg.MaxInFlight,
+		Jobs:        []Job{},
+	}
+	for i := range jobs.Items {
+		kjob := &jobs.Items[i]
+		if model.JobFinished(kjob) || model.JobReplaced(kjob) {
+			continue
+		}
+		report.Jobs = append(report.Jobs, newJob(kjob, podsByJob[kjob.UID]))
+	}
+	report.InFlight = len(report.Jobs)
+
+	slices.SortFunc(report.Jobs, func(a, b Job) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.UUID, b.UUID)
+	})
+	return report, nil
+}
+
+func newJob(kjob *batchv1.Job, pod *corev1.Pod) Job {
+	job := Job{
+		UUID:      kjob.Labels[config.UUIDLabel],
+		K8sJob:    kjob.Name,
+		Phase:     phaseNoPod,
+		CreatedAt: kjob.CreationTimestamp.Time,
+		Priority:  kjob.Annotations[config.PriorityAnnotation],
+		URL:       kjob.Annotations[config.JobURLAnnotation],
+	}
+	if kjob.Spec.Suspend != nil && *kjob.Spec.Suspend {
+		job.Phase = phaseSuspended
+	}
+	if pod != nil {
+		job.Pod = pod.Name
+		job.Phase = string(pod.Status.Phase)
+		job.Node = pod.Spec.NodeName
+	}
+	return job
+}
+
+// Print writes the report to out in the given format.
+func Print(out io.Writer, report *Report, format string, now time.Time) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+
+	case FormatTable:
+		limit := "unlimited"
+		if report.MaxInFlight > 0 {
+			limit = fmt.Sprintf("%d (%d available)", report.MaxInFlight, max(report.MaxInFlight-report.InFlight, 0))
+		}
+		fmt.Fprintf(out, "Namespace:     %s\n", report.Namespace)
+		fmt.Fprintf(out, "Selector:      %s\n", report.Selector)
+		fmt.Fprintf(out, "In flight:     %d\n", report.InFlight)
+		fmt.Fprintf(out, "Max in flight: %s\n", limit)
+		if len(report.Jobs) == 0 {
+			return nil
+		}
+
+		tw := table.NewWriter()
+		tw.SetStyle(table.StyleColoredDark)
+		tw.AppendHeader(table.Row{"JOB UUID", "KUBERNETES JOB", "POD", "PHASE", "NODE", "AGE", "PRIORITY", "URL"})
+		for _, job := range report.Jobs {
+			tw.AppendRow(table.Row{
+				job.UUID,
+				job.K8sJob,
+				job.Pod,
+				job.Phase,
+				job.Node,
+				duration.HumanDuration(now.Sub(job.CreatedAt)),
+				job.Priority,
+				job.URL,
+			})
+		}
+		fmt.Fprintln(out, tw.Render())
+		return nil
+
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+}
@@ -0,0 +1,148 @@
+package status
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/agenttags"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/cobra"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestGather(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tags := []string{"queue=kubernetes"}
+	tagLabels, errs := agenttags.LabelsFromTags(tags)
+	if len(errs) > 0 {
+		t.Fatalf("agenttags.LabelsFromTags(%v) errors = %v", tags, errs)
+	}
+
+	newJob := func(name string, labels map[string]string) *batchv1.Job {
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "buildkite",
+				UID:               types.UID(name),
+				Labels:            map[string]string{config.UUIDLabel: name + "-uuid"},
+				CreationTimestamp: metav1.NewTime(created),
+				Annotations: map[string]string{
+					config.PriorityAnnotation: "2",
+					config.JobURLAnnotation:   "https://buildkite.com/org/pipeline/builds/1#" + name,
+				},
+			},
+		}
+		maps.Copy(job.Labels, labels)
+		return job
+	}
+	running := newJob("running", tagLabels)
+	suspended := newJob("suspended", tagLabels)
+	suspended.Spec.Suspend = ptr.To(true)
+	finished := newJob("finished", tagLabels)
+	finished.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	otherQueue := newJob("other-queue", map[string]string{"tag.buildkite.com/queue": "other"})
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "running-abcde",
+			Namespace:         "buildkite",
+			Labels:            maps.Clone(running.Labels),
+			CreationTimestamp: metav1.NewTime(created),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Name:       "running",
+				UID:        "running",
+				Controller: ptr.To(true),
+			}},
+		},
+		Spec:   corev1.PodSpec{NodeName: "node-1"},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+
+	k8s := fake.NewClientset(running, suspended, finished, otherQueue, pod)
+	cfg := &config.Config{Namespace: "buildkite", Tags: tags, MaxInFlight: 10}
+	report, err := Gather(context.Background(), k8s, cfg)
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+
+	want := []Job{
+		{
+			UUID:      "running-uuid",
+			K8sJob:    "running",
+			Pod:       "running-abcde",
+			Phase:     "Running",
+			Node:      "node-1",
+			CreatedAt: created,
+			Priority:  "2",
+			URL:       "https://buildkite.com/org/pipeline/builds/1#running",
+		},
+		{
+			UUID:      "suspended-uuid",
+			K8sJob:    "suspended",
+			Phase:     phaseSuspended,
+			CreatedAt: created,
+			Priority:  "2",
+			URL:       "https://buildkite.com/org/pipeline/builds/1#suspended",
+		},
+	}
+	if diff := cmp.Diff(report.Jobs, want); diff != "" {
+		t.Errorf("Gather() jobs diff (-got +want):\n%s", diff)
+	}
+	if report.InFlight != 2 || report.MaxInFlight != 10 {
+		t.Errorf("Gather() occupancy = %d/%d, want 2/10", report.InFlight, report.MaxInFlight)
+	}
+
+	var out bytes.Buffer
+	if err := Print(&out, report, FormatJSON, created); err != nil {
+		t.Fatalf("Print(json) error = %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("json.Unmarshal(Print(json) output) error = %v", err)
+	}
+	if diff := cmp.Diff(&decoded, report); diff != "" {
+		t.Errorf("Print(json) round trip diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestNewReadsConfigWithoutStatusFlags(t *testing.T) {
+	addQueueFlags := func(cmd *cobra.Command) {
+		cmd.Flags().String("namespace", "default", "kubernetes namespace")
+	}
+	errStop := errors.New("stop")
+	var cfgCmd *cobra.Command
+	readConfig := func(cmd *cobra.Command, args []string) (*config.Config, error) {
+		cfgCmd = cmd
+		return nil, errStop
+	}
+
+	cmd := New(addQueueFlags, readConfig)
+	cmd.SetArgs([]string{"--namespace", "buildkite", "--output", "json"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	if err := cmd.Execute(); !errors.Is(err, errStop) {
+		t.Fatalf("cmd.Execute() = %v, want %v", err, errStop)
+	}
+
+	if f := cfgCmd.Flags().Lookup("output"); f != nil {
+		t.Errorf("readConfig was given the output flag")
+	}
+	if got, err := cfgCmd.Flags().GetString("namespace"); err != nil || got != "buildkite" {
+		t.Errorf(`cfgCmd.Flags().GetString("namespace") = %q, %v, want "buildkite", nil`, got, err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package admission implements a simple admission loop for suspended
+// Kubernetes jobs, for use when the controller creates jobs suspended but Kueue
+// isn't available.
+package admission
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// How often to re-check for admissible jobs even if no job events arrive.
+const resyncInterval = 10 * time.Second
+
+// Admitter unsuspends suspended jobs, highest Buildkite priority first, while
+// the number of admitted unfinished jobs is below a limit.
+type Admitter struct {
+	logger *zap.Logger
+	k8s    kubernetes.Interface
+
+	// Upper limit on admitted, unfinished jobs. 0 means no limit.
+	maxInFlight int
+
+	lister batchlisters.JobLister
+
+	// Signals the admission loop that something changed.
+	wakeCh chan struct{}
+}
+
+// New creates an Admitter.
+func New(logger *zap.Logger, k8s kubernetes.Interface, maxInFlight int) *Admitter {
+	return &Admitter{
+		logger:      logger,
+		k8s:         k8s,
+		maxInFlight: maxInFlight,
+		wakeCh:      make(chan struct{}, 1),
+	}
+}
+
+// RegisterInformer registers the admitter to listen for Kubernetes job events,
+// waits for cache sync, and starts the admission loop.
+func (a *Admitter) RegisterInformer(ctx context.Context, factory informers.SharedInformerFactory) error {
+	informer := factory.Batch().V1().Jobs()
+	reg, err := informer.Informer().AddEventHandler(a)
+	if err != nil {
+		return err
+	}
+	a.lister = informer.Lister()
+	go factory.Start(ctx.Done())
+
+	if !cache.WaitForCacheSync(ctx.Done(), reg.HasSynced) {
+		return fmt.Errorf("failed to sync informer cache")
+	}
+
+	go a.run(ctx)
+	return nil
+}
+
+// OnAdd is called by k8s to inform us a resource is added.
+func (a *Admitter) OnAdd(any, bool) { a.wake() }
+
+// OnUpdate is called by k8s to inform us a resource is updated.
+func (a *Admitter) OnUpdate(any, any) { a.wake() }
+
+// OnDelete is called by k8s to inform us a resource is deleted.
+func (a *Admitter) OnDelete(any) { a.wake() }
+
+func (a *Admitter) wake() {
+	select {
+	case a.wakeCh <- struct{}{}:
+	default:
+		// Already awake.
+	}
+}
+
+func (a *Admitter) run(ctx context.Context) {
+	ticker := time.NewTicker(resyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.wakeCh:
+		case <-ticker.C:
+		}
+		if err := a.admit(ctx); err != nil {
+			a.logger.Error("admission failed", zap.Error(err))
+		}
+	}
+}
+
+// admit unsuspends as many suspended jobs as the limit allows.
+func (a *Admitter) admit(ctx context.Context) error {
+	jobs, err := a.lister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("listing jobs: %w", err)
+	}
+
+	admitted := 0
+	var waiting []*batchv1.Job
+	for _, kjob := range jobs {
+		if model.JobFinished(kjob) || model.JobReplaced(kjob) || kjob.DeletionTimestamp != nil {
+			continue
+		}
+		if kjob.Spec.Suspend != nil && *kjob.Spec.Suspend {
+			waiting = append(waiting, kjob)
+			continue
+		}
+		admitted++
+	}
+	jobsAdmittedGauge.Set(float64(admitted))
+	jobsWaitingGauge.Set(float64(len(waiting)))
+
+	// Highest priority first, then oldest first.
+	slices.SortFunc(waiting, func(x, y *batchv1.Job) int {
+		if c := cmp.Compare(jobPriority(y), jobPriority(x)); c != 0 {
+			return c
+		}
+		return x.CreationTimestamp.Compare(y.CreationTimestamp.Time)
+	})
+
+	for _, kjob := range waiting {
+		if a.maxInFlight > 0 && admitted >= a.maxInFlight {
+			return nil
+		}
+		if err := a.unsuspend(ctx, kjob); err != nil {
+			admissionErrorsCounter.WithLabelValues(string(kerrors.ReasonForError(err))).Inc()
+			a.logger.Warn("couldn't unsuspend job", zap.String("job", kjob.Name), zap.Error(err))
+			continue
+		}
+		jobsAdmittedCounter.Inc()
+		admitted++
+		a.logger.Debug("admitted job",
+			zap.String("job-uuid", kjob.Labels[config.UUIDLabel]),
+			zap.Int("admitted", admitted),
+		)
+	}
+	return nil
+}
+
+func (a *Admitter) unsuspend(ctx context.Context, kjob *batchv1.Job) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		job, err := a.k8s.BatchV1().Jobs(kjob.Namespace).Get(ctx, kjob.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if job.Spec.Suspend == nil || !*job.Spec.Suspend {
+			return nil
+		}
+		job.Spec.Suspend = nil
+		_, err = a.k8s.BatchV1().Jobs(kjob.Namespace).Update(ctx, job, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// jobPriority returns the Buildkite job priority recorded on the job.
+func jobPriority(kjob *batchv1.Job) int {
+	p, _ := strconv.Atoi(kjob.Annotations[config.PriorityAnnotation])
+	return p
+}
@@ -0,0 +1,71 @@
+package admission
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func suspendedJob(name string, priority int, created time.Time) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "buildkite",
+			CreationTimestamp: metav1.NewTime(created),
+			Labels:            map[string]string{config.UUIDLabel: uuid.New().String()},
+			Annotations:       map[string]string{config.PriorityAnnotation: strconv.Itoa(priority)},
+		},
+		Spec: batchv1.JobSpec{Suspend: ptr.To(true)},
+	}
+}
+
+func TestAdmitter_AdmitsHighestPriorityFirst(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	objs := []runtime.Object{
+		suspendedJob("low", 0, now.Add(-3*time.Minute)),
+		suspendedJob("high", 10, now),
+		suspendedJob("mid-old", 5, now.Add(-2*time.Minute)),
+		suspendedJob("mid-new", 5, now.Add(-1*time.Minute)),
+	}
+	k8s := fake.NewClientset(objs...)
+
+	a := New(zaptest.NewLogger(t), k8s, 2)
+	factory := informers.NewSharedInformerFactory(k8s, 0)
+	informer := factory.Batch().V1().Jobs()
+	a.lister = informer.Lister()
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	if err := a.admit(ctx); err != nil {
+		t.Fatalf("a.admit(ctx) = %v", err)
+	}
+
+	want := map[string]bool{"high": true, "mid-old": true, "mid-new": false, "low": false}
+	for name, wantAdmitted := range want {
+		job, err := k8s.BatchV1().Jobs("buildkite").Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", name, err)
+		}
+		admitted := job.Spec.Suspend == nil || !*job.Spec.Suspend
+		if admitted != wantAdmitted {
+			t.Errorf("job %q admitted = %t, want %t", name, admitted, wantAdmitted)
+		}
+	}
+}
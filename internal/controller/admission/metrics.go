@@ -0,0 +1,38 @@
+package admission
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	promNamespace = "buildkite"
+	promSubsystem = "admission"
+)
+
+var (
+	jobsAdmittedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "admitted_jobs",
+		Help:      "Number of admitted jobs that have not finished, as of the last admission pass",
+	})
+	jobsWaitingGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "waiting_jobs",
+		Help:      "Number of suspended jobs waiting for admission, as of the last admission pass",
+	})
+	jobsAdmittedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "jobs_admitted_total",
+		Help:      "Count of suspended jobs that were unsuspended",
+	})
+	admissionErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "errors_total",
+		Help:      "Count of failures to unsuspend jobs",
+	}, []string{"reason"})
+)
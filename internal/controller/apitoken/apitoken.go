@@ -0,0 +1,148 @@
+// Package apitoken loads the Buildkite API token from a file, and reloads it
+// when the file changes or the API rejects the token. This allows the token to
+// be rotated (e.g. by updating a mounted Kubernetes secret) without restarting
+// the controller.
+package apitoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultReloadInterval is how often the token file is checked for changes.
+const DefaultReloadInterval = 30 * time.Second
+
+// Static is an api.TokenSource for a token that can't be reloaded. It only
+// reports rejections.
+type Static struct {
+	logger       *zap.Logger
+	token        string
+	unauthorized atomic.Bool
+}
+
+// NewStatic returns a Static token source.
+func NewStatic(logger *zap.Logger, token string) *Static {
+	return &Static{logger: logger, token: token}
+}
+
+// Token returns the token.
+func (s *Static) Token() string { return s.token }
+
+// Rejected records that the API rejected the token.
+func (s *Static) Rejected(string) {
+	rejectionsCounter.Inc()
+	if !s.unauthorized.Swap(true) {
+		unauthorizedGauge.Set(1)
+		s.logger.Error("Buildkite API rejected the API token; requests will fail until the controller is restarted with a valid token")
+	}
+}
+
+// File is an api.TokenSource backed by a file containing the token.
+type File struct {
+	logger *zap.Logger
+	path   string
+
+	token        atomic.Pointer[string]
+	unauthorized atomic.Bool
+
+	// Serialises reloads, which can be triggered by the watcher and by any
+	// number of rejected requests at once.
+	reloadMu sync.Mutex
+}
+
+// NewFile loads the token from the file at path.
+func NewFile(logger *zap.Logger, path string) (*File, error) {
+	f := &File{
+		logger: logger,
+		path:   path,
+	}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Token returns the current token.
+func (f *File) Token() string {
+	return *f.token.Load()
+}
+
+// Rejected records that the API rejected the token, and tries reloading it.
+// The unauthorized state is cleared once a different token is loaded.
+func (f *File) Rejected(token string) {
+	rejectionsCounter.Inc()
+	if token != f.Token() {
+		// Already replaced.
+		return
+	}
+	if !f.unauthorized.Swap(true) {
+		unauthorizedGauge.Set(1)
+		f.logger.Error("Buildkite API rejected the API token; requests will fail until the token file is updated",
+			zap.String("path", f.path),
+		)
+	}
+	if err := f.reload(); err != nil {
+		f.logger.Warn("Couldn't reload API token", zap.Error(err))
+	}
+}
+
+// Watch checks the token file for changes every interval, until the context
+// is done.
+func (f *File) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.reload(); err != nil {
+				f.logger.Warn("Couldn't reload API token", zap.Error(err))
+			}
+		}
+	}
+}
+
+// reload reads the token file, and swaps in the token if it has changed.
+func (f *File) reload() error {
+	f.reloadMu.Lock()
+	defer f.reloadMu.Unlock()
+
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		reloadErrorsCounter.Inc()
+		return fmt.Errorf("reading API token file: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		reloadErrorsCounter.Inc()
+		return errors.New("API token file is empty")
+	}
+
+	old := f.token.Swap(&token)
+	if old == nil {
+		// Initial load.
+		return nil
+	}
+	if *old == token {
+		return nil
+	}
+
+	reloadsCounter.Inc()
+	f.logger.Info("Loaded changed API token", zap.String("path", f.path))
+	if f.unauthorized.Swap(false) {
+		unauthorizedGauge.Set(0)
+	}
+	return nil
+}
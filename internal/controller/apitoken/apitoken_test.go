@@ -0,0 +1,41 @@
+package apitoken
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap/zaptest"
+)
+
+func TestFile_ReloadsWhenRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("old-token\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v", path, err)
+	}
+
+	f, err := NewFile(zaptest.NewLogger(t), path)
+	if err != nil {
+		t.Fatalf("NewFile(%q) error = %v", path, err)
+	}
+	if got, want := f.Token(), "old-token"; got != want {
+		t.Errorf("f.Token() = %q, want %q", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("new-token\n"), 0o600); err != nil {
+		t.Fatalf("os.WriteFile(%q) = %v", path, err)
+	}
+	f.Rejected("old-token")
+	if got, want := f.Token(), "new-token"; got != want {
+		t.Errorf("after Rejected, f.Token() = %q, want %q", got, want)
+	}
+	if f.unauthorized.Load() {
+		t.Error("after reloading a new token, f.unauthorized = true, want false")
+	}
+
+	// A rejected token that can't be replaced stays rejected.
+	f.Rejected("new-token")
+	if !f.unauthorized.Load() {
+		t.Error("after Rejected with an unchanged file, f.unauthorized = false, want true")
+	}
+}
@@ -0,0 +1,38 @@
+package apitoken
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	promNamespace = "buildkite"
+	promSubsystem = "api_token"
+)
+
+var (
+	unauthorizedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "unauthorized",
+		Help:      "Whether the Buildkite API rejected the current API token (1) or not (0)",
+	})
+	rejectionsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "rejections_total",
+		Help:      "Count of Buildkite API requests that failed with 401 Unauthorized",
+	})
+	reloadsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "reloads_total",
+		Help:      "Count of times a changed API token was loaded from the token file",
+	})
+	reloadErrorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "reload_errors_total",
+		Help:      "Count of errors reading the API token file",
+	})
+)
@@ -0,0 +1,19 @@
+package config
+
+// AdmissionMode selects what unsuspends the Kubernetes jobs created by the
+// controller.
+type AdmissionMode string
+
+const (
+	// AdmissionModeNone creates jobs unsuspended. The number of jobs in flight
+	// is limited by the controller's limiter.
+	AdmissionModeNone AdmissionMode = ""
+
+	// AdmissionModeKueue creates jobs suspended and labelled with a Kueue
+	// queue name, for Kueue to admit.
+	AdmissionModeKueue AdmissionMode = "kueue"
+
+	// AdmissionModeBuiltin creates jobs suspended, for the controller's own
+	// admission loop to admit in priority order.
+	AdmissionModeBuiltin AdmissionMode = "builtin"
+)
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"maps"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/agenttags"
+)
+
+// AgentTokenSecretMapping selects the agent token secret for jobs with
+// matching agent tags.
+type AgentTokenSecretMapping struct {
+	// Tags must all be present on the job for the mapping to match. A value of
+	// "*" matches any value.
+	Tags map[string]string `json:"tags" validate:"required"`
+
+	// Secret is the name of the Kubernetes secret containing the agent token,
+	// in the BUILDKITE_AGENT_TOKEN key.
+	Secret string `json:"secret" validate:"required"`
+}
+
+// AgentTokenSecretFor returns the secret from the first mapping matching the
+// job's agent tags, or defaultSecret if none match.
+func AgentTokenSecretFor(mappings []AgentTokenSecretMapping, defaultSecret string, jobTags map[string]string) string {
+	for _, m := range mappings {
+		if agenttags.JobTagsMatchAgentTags(maps.All(m.Tags), jobTags) {
+			return m.Secret
+		}
+	}
+	return defaultSecret
+}
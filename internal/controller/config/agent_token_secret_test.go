@@ -0,0 +1,28 @@
+package config
+
+import "testing"
+
+func TestAgentTokenSecretFor(t *testing.T) {
+	mappings := []AgentTokenSecretMapping{
+		{Tags: map[string]string{"queue": "deploy", "cluster": "prod"}, Secret: "prod-deploy-token"},
+		{Tags: map[string]string{"queue": "deploy"}, Secret: "deploy-token"},
+		{Tags: map[string]string{"cluster": "*"}, Secret: "cluster-token"},
+	}
+
+	tests := []struct {
+		tags map[string]string
+		want string
+	}{
+		{tags: map[string]string{"queue": "deploy", "cluster": "prod"}, want: "prod-deploy-token"},
+		{tags: map[string]string{"queue": "deploy", "cluster": "staging"}, want: "deploy-token"},
+		{tags: map[string]string{"queue": "test", "cluster": "staging"}, want: "cluster-token"},
+		{tags: map[string]string{"queue": "test"}, want: "default-token"},
+		{tags: nil, want: "default-token"},
+	}
+
+	for _, test := range tests {
+		if got := AgentTokenSecretFor(mappings, "default-token", test.tags); got != test.want {
+			t.Errorf("AgentTokenSecretFor(mappings, %q, %v) = %q, want %q", "default-token", test.tags, got, test.want)
+		}
+	}
+}
@@ -15,6 +15,13 @@ const (
 	BuildURLAnnotation                  = "buildkite.com/build-url"
 	JobURLAnnotation                    = "buildkite.com/job-url"
 	PriorityAnnotation                  = "buildkite.com/job-priority"
+	OOMKilledAnnotation                 = "buildkite.com/oom-killed-containers"
+	FallbackAttemptAnnotation           = "buildkite.com/fallback-attempt"
+	ReplacedByAnnotation                = "buildkite.com/replaced-by"
+	ControllerVersionAnnotation         = "buildkite.com/controller-version"
+	TraceParentAnnotation               = "buildkite.com/traceparent"
+	PipelineSlugAnnotation              = "buildkite.com/pipeline-slug"
+	KueueQueueNameLabel                 = "kueue.x-k8s.io/queue-name"
 	DefaultNamespace                    = "default"
 	DefaultStaleJobDataTimeout          = 10 * time.Second
 	DefaultImagePullBackOffGracePeriod  = 30 * time.Second
@@ -24,6 +31,9 @@ const (
 	DefaultK8sClientRateLimiterQPS      = 10
 	DefaultK8sClientRateLimiterBurst    = 20
 	DefaultGraphQLResultsLimit          = 100
+	DefaultSidecarLogTailLines          = 0
+	DefaultPreemptionExitStatus         = 75  // EX_TEMPFAIL
+	DefaultOOMKilledExitStatus          = 137 // 128 + SIGKILL
 )
 
 var DefaultAgentImage = "ghcr.io/buildkite/agent:" + version.Version()
@@ -39,17 +49,26 @@ type Config struct {
 	StaleJobDataTimeout      time.Duration `json:"stale-job-data-timeout"   validate:"omitempty"`
 	JobCreationConcurrency   int           `json:"job-creation-concurrency" validate:"omitempty"`
 	AgentTokenSecret         string        `json:"agent-token-secret"       validate:"required"`
-	BuildkiteToken           string        `json:"buildkite-token"          validate:"required"`
+	BuildkiteToken           string        `json:"buildkite-token"          validate:"required_without=BuildkiteTokenFile"`
+	BuildkiteTokenFile       string        `json:"buildkite-token-file"     validate:"omitempty"`
 	Image                    string        `json:"image"                    validate:"required"`
 	MaxInFlight              int           `json:"max-in-flight"            validate:"min=0"`
 	Namespace                string        `json:"namespace"                validate:"required"`
 	Org                      string        `json:"org"                      validate:"required"`
 	Tags                     stringSlice   `json:"tags"                     validate:"min=1"`
 	PrometheusPort           uint16        `json:"prometheus-port"          validate:"omitempty"`
+	HealthPort               uint16        `json:"health-port"              validate:"omitempty"`
+	ScalerPort               uint16        `json:"scaler-port"              validate:"omitempty"`
 	ProfilerAddress          string        `json:"profiler-address"         validate:"omitempty,hostname_port"`
 	GraphQLEndpoint          string        `json:"graphql-endpoint"         validate:"omitempty"`
 	GraphQLResultsLimit      int           `json:"graphql-results-limit"    validate:"min=1,max=500"`
 	EnableQueuePause         bool          `json:"enable-queue-pause"       validate:"omitempty"`
+	TracingEndpoint          string        `json:"tracing-endpoint"         validate:"omitempty,url"`
+
+	// StartupMetricsPipelineLabel labels the pod startup histograms with the
+	// pipeline, which multiplies the number of series by the number of
+	// pipelines.
+	StartupMetricsPipelineLabel bool `json:"startup-metrics-pipeline-label" validate:"omitempty"`
 	// Agent endpoint is set in agent-config.
 
 	K8sClientRateLimiterQPS   int `json:"k8s-client-rate-limiter-qps" validate:"omitempty"`
@@ -64,6 +83,74 @@ type Config struct {
 	JobCancelCheckerPollInterval time.Duration   `json:"job-cancel-checker-poll-interval" validate:"omitempty"`
 	EmptyJobGracePeriod          time.Duration   `json:"empty-job-grace-period"           validate:"omitempty"`
 
+	// SidecarLogTailLines is the number of lines from the end of a sidecar
+	// container's log that are made available to the job's command containers
+	// when the sidecar exits, restarts or stops being ready. 0 disables
+	// capturing sidecar logs.
+	SidecarLogTailLines int `json:"sidecar-log-tail-lines" validate:"min=0"`
+
+	// PreemptionExitStatus is the exit status used to fail jobs whose pod was
+	// preempted, evicted, or lost with its node before an agent acquired the
+	// job. This allows pipelines to automatically retry only infrastructure
+	// failures. Jobs an agent has acquired finish with the agent's exit status.
+	PreemptionExitStatus int `json:"preemption-exit-status" validate:"min=0"`
+
+	// OOMKilledExitStatus is the exit status used to fail jobs whose init
+	// containers were OOMKilled. (Once the agent has started, it reports the
+	// exit status of OOMKilled command containers itself.)
+	OOMKilledExitStatus int `json:"oom-killed-exit-status" validate:"min=0"`
+
+	// FailureExitStatuses sets the exit status used to fail jobs for each
+	// kind of failure originating in the controller (the default is 1).
+	FailureExitStatuses FailureExitStatuses `json:"failure-exit-statuses" validate:"omitempty"`
+
+	// UnschedulableGracePeriod is how long a pod may remain unschedulable
+	// before the controller fails the job and evicts the pod. Zero disables
+	// this, leaving the pod to wait until the job's active deadline.
+	UnschedulableGracePeriod time.Duration `json:"unschedulable-grace-period" validate:"omitempty"`
+
+	// OrphanedJobGracePeriod is how long an unfinished Kubernetes job may
+	// remain after its Buildkite job reaches a terminal state (cancelled,
+	// finished, expired, etc) before the controller deletes it. Zero disables
+	// the orphan reconciler.
+	OrphanedJobGracePeriod time.Duration `json:"orphaned-job-grace-period" validate:"omitempty"`
+
+	// UnschedulableFallbacks are tried in order for jobs whose pods remain
+	// unschedulable. Each replaces the Kubernetes job with one using the
+	// fallback's pod spec patch. Since the agent hasn't started, this is safe.
+	UnschedulableFallbacks []UnschedulableFallback `json:"unschedulable-fallbacks" validate:"omitempty,dive"`
+
+	// PriorityClasses maps ranges of Buildkite job priority to Kubernetes
+	// PriorityClass names, which are set on pods so that the kube-scheduler can
+	// preempt lower-priority pods. The first matching entry is used.
+	PriorityClasses []PriorityClassMapping `json:"priority-classes" validate:"omitempty,dive"`
+
+	// EnableLimiterPreemption allows a job waiting for max-in-flight capacity
+	// to delete a lower-priority Kubernetes job whose pod hasn't started yet.
+	// The preempted Buildkite job is scheduled again on a later poll.
+	EnableLimiterPreemption bool `json:"enable-limiter-preemption" validate:"omitempty"`
+
+	// AdmissionMode, if set, makes the controller create Kubernetes jobs
+	// suspended, and leaves admitting them to Kueue ("kueue") or the built-in
+	// admission loop ("builtin"), instead of holding them in the limiter.
+	AdmissionMode AdmissionMode `json:"admission-mode" validate:"omitempty,oneof=kueue builtin"`
+
+	// KueueQueueName is the Kueue LocalQueue for jobs, when AdmissionMode is
+	// "kueue".
+	KueueQueueName string `json:"kueue-queue-name" validate:"required_if=AdmissionMode kueue"`
+
+	// JobEnv controls which job env vars are propagated into containers.
+	JobEnv *JobEnvParams `json:"job-env" validate:"omitempty"`
+
+	// AgentTokenSecrets selects agent token secrets other than
+	// AgentTokenSecret for jobs by their agent tags, e.g. to use a different
+	// token for each queue. The first matching entry is used.
+	AgentTokenSecrets []AgentTokenSecretMapping `json:"agent-token-secrets" validate:"omitempty,dive"`
+
+	// PipelineSecrets lists the Kubernetes secrets that each pipeline may
+	// reference with the kubernetes plugin's `secrets` mapping.
+	PipelineSecrets PipelineSecrets `json:"pipeline-secrets" validate:"omitempty"`
+
 	// WorkspaceVolume allows supplying a volume for /workspace. By default
 	// an EmptyDir volume is created for it.
 	WorkspaceVolume *corev1.Volume `json:"workspace-volume" validate:"omitempty"`
@@ -115,6 +202,9 @@ func (c Config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	}
 	enc.AddString("profiler-address", c.ProfilerAddress)
 	enc.AddUint16("prometheus-port", c.PrometheusPort)
+	enc.AddUint16("health-port", c.HealthPort)
+	enc.AddUint16("scaler-port", c.ScalerPort)
+	enc.AddString("buildkite-token-file", c.BuildkiteTokenFile)
 	enc.AddString("cluster-uuid", c.ClusterUUID)
 	enc.AddBool("prohibit-kubernetes-plugin", c.ProhibitKubernetesPlugin)
 	enc.AddBool("allow-pod-spec-patch-unsafe-command-modification", c.AllowPodSpecPatchUnsafeCmdMod)
@@ -126,6 +216,17 @@ func (c Config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	}
 	enc.AddDuration("image-pull-backoff-grace-period", c.ImagePullBackOffGracePeriod)
 	enc.AddDuration("job-cancel-checker-poll-interval", c.JobCancelCheckerPollInterval)
+	enc.AddInt("sidecar-log-tail-lines", c.SidecarLogTailLines)
+	enc.AddInt("preemption-exit-status", c.PreemptionExitStatus)
+	enc.AddInt("oom-killed-exit-status", c.OOMKilledExitStatus)
+	enc.AddDuration("unschedulable-grace-period", c.UnschedulableGracePeriod)
+	enc.AddDuration("orphaned-job-grace-period", c.OrphanedJobGracePeriod)
+	if err := enc.AddReflected("unschedulable-fallbacks", c.UnschedulableFallbacks); err != nil {
+		return err
+	}
+	if err := enc.AddReflected("priority-classes", c.PriorityClasses); err != nil {
+		return err
+	}
 	if err := enc.AddReflected("agent-config", c.AgentConfig); err != nil {
 		return err
 	}
@@ -144,6 +245,23 @@ func (c Config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("default-image-pull-policy", string(c.DefaultImagePullPolicy))
 	enc.AddString("default-image-check-pull-policy", string(c.DefaultImageCheckPullPolicy))
 	enc.AddBool("enable-queue-pause", c.EnableQueuePause)
+	enc.AddString("tracing-endpoint", c.TracingEndpoint)
+	enc.AddBool("startup-metrics-pipeline-label", c.StartupMetricsPipelineLabel)
+	enc.AddBool("enable-limiter-preemption", c.EnableLimiterPreemption)
+	enc.AddString("admission-mode", string(c.AdmissionMode))
+	enc.AddString("kueue-queue-name", c.KueueQueueName)
+	if err := enc.AddReflected("job-env", c.JobEnv); err != nil {
+		return err
+	}
+	if err := enc.AddReflected("failure-exit-statuses", c.FailureExitStatuses); err != nil {
+		return err
+	}
+	if err := enc.AddReflected("pipeline-secrets", c.PipelineSecrets); err != nil {
+		return err
+	}
+	if err := enc.AddReflected("agent-token-secrets", c.AgentTokenSecrets); err != nil {
+		return err
+	}
 	return nil
 }
 
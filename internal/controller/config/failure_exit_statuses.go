@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// DefaultFailureExitStatus is the exit status used when the controller fails a
+// job, unless a more specific status is configured.
+const DefaultFailureExitStatus = 1
+
+// Kinds of failure originating in the controller, for FailureExitStatuses.
+const (
+	// The job couldn't be parsed (e.g. an invalid kubernetes plugin config).
+	FailureParseError = "parse-error"
+	// A podSpec couldn't be built for the job (e.g. an invalid podSpecPatch).
+	FailureSpecBuildError = "spec-build-error"
+	// Kubernetes rejected the job built for the Buildkite job as invalid.
+	FailureKubernetesInvalid = "kubernetes-invalid"
+	// An init container (e.g. checkout) failed.
+	FailureInitContainer = "init-container-failure"
+	// An image couldn't be pulled.
+	FailureImagePull = "image-pull-failure"
+	// The pod stayed unschedulable for longer than unschedulable-grace-period.
+	FailureUnschedulable = "unschedulable"
+	// The Kubernetes job didn't start a pod within empty-job-grace-period.
+	FailureStalledWithoutPod = "stalled-without-pod"
+	// The Kubernetes job finished without ever starting a pod.
+	FailureFinishedWithoutPod = "finished-without-pod"
+)
+
+var failureKinds = []string{
+	FailureParseError,
+	FailureSpecBuildError,
+	FailureKubernetesInvalid,
+	FailureInitContainer,
+	FailureImagePull,
+	FailureUnschedulable,
+	FailureStalledWithoutPod,
+	FailureFinishedWithoutPod,
+}
+
+// FailureExitStatuses maps kinds of failure originating in the controller to
+// the exit status used when failing the job, so that pipelines can tell them
+// apart from failing commands (e.g. to automatically retry them).
+// Preemptions and OOMKilled init containers aren't kinds of failure here: they
+// are set only by Config.PreemptionExitStatus and Config.OOMKilledExitStatus,
+// which default to statuses other than 1.
+type FailureExitStatuses map[string]int
+
+// For returns the exit status for the kind of failure.
+func (f FailureExitStatuses) For(kind string) int {
+	if status, ok := f[kind]; ok {
+		return status
+	}
+	return DefaultFailureExitStatus
+}
+
+// Validate checks that each kind of failure is known, and that each status is
+// positive: 0 would mark the job as passed, and Buildkite uses negative exit
+// statuses for jobs whose agent was lost or never ran them.
+func (f FailureExitStatuses) Validate() error {
+	for kind, status := range f {
+		if !slices.Contains(failureKinds, kind) {
+			return fmt.Errorf("unknown failure-exit-statuses kind %q (valid kinds: %v)", kind, failureKinds)
+		}
+		if status <= 0 {
+			return fmt.Errorf("failure-exit-statuses for %q must be positive, not %d", kind, status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import "testing"
+
+func TestFailureExitStatuses(t *testing.T) {
+	statuses := FailureExitStatuses{
+		FailureImagePull:     78,
+		FailureUnschedulable: 75,
+	}
+	if err := statuses.Validate(); err != nil {
+		t.Errorf("statuses.Validate() = %v", err)
+	}
+
+	tests := []struct {
+		kind string
+		want int
+	}{
+		{kind: FailureImagePull, want: 78},
+		{kind: FailureUnschedulable, want: 75},
+		{kind: FailureParseError, want: DefaultFailureExitStatus},
+	}
+	for _, test := range tests {
+		if got := statuses.For(test.kind); got != test.want {
+			t.Errorf("statuses.For(%q) = %d, want %d", test.kind, got, test.want)
+		}
+	}
+
+	for _, invalid := range []FailureExitStatuses{
+		{"image-pull": 78},
+		{FailureInitContainer: 0},
+		{FailureImagePull: -1},
+		// Set with preemption-exit-status instead.
+		{"preemption": 75},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("FailureExitStatuses(%v).Validate() = nil, want an error", invalid)
+		}
+	}
+}
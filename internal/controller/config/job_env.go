@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// JobEnvParams controls which variables from the Buildkite job's env are set on
+// the containers in the pod. Each pattern is a shell-style glob (see
+// [path.Match]) matched against the variable name, e.g. "BUILDKITE_*".
+type JobEnvParams struct {
+	// Allow, if not empty, limits the job env vars propagated into the agent,
+	// checkout, and command containers to those matching one of the patterns.
+	Allow []string `json:"allow,omitempty"`
+
+	// Deny prevents job env vars matching any of the patterns from being
+	// propagated. Deny takes precedence over Allow.
+	Deny []string `json:"deny,omitempty"`
+
+	// Sidecars lists patterns of job env vars that are also propagated into
+	// sidecar containers, which otherwise receive none of the job env.
+	Sidecars []string `json:"sidecars,omitempty"`
+}
+
+// Propagate reports whether the job env var should be set on the agent,
+// checkout, and command containers.
+func (p *JobEnvParams) Propagate(name string) bool {
+	if p == nil {
+		return true
+	}
+	if matchAny(p.Deny, name) {
+		return false
+	}
+	return len(p.Allow) == 0 || matchAny(p.Allow, name)
+}
+
+// PropagateToSidecars reports whether the job env var should also be set on
+// sidecar containers.
+func (p *JobEnvParams) PropagateToSidecars(name string) bool {
+	if p == nil {
+		return false
+	}
+	return p.Propagate(name) && matchAny(p.Sidecars, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		// Malformed patterns are rejected when the config is loaded.
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that each pattern is well-formed.
+func (p *JobEnvParams) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, patterns := range [][]string{p.Allow, p.Deny, p.Sidecars} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid job-env pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// PipelineSecrets maps pipeline slugs to the names of Kubernetes secrets that
+// jobs in those pipelines may reference with the kubernetes plugin's `secrets`
+// mapping. Both slugs and secret names may be shell-style glob patterns (see
+// [path.Match]). Pipelines that match no entry may not reference any secrets.
+type PipelineSecrets map[string][]string
+
+// Allows reports whether jobs in the pipeline may reference the secret.
+func (p PipelineSecrets) Allows(pipelineSlug, secretName string) bool {
+	for slugPattern, secretPatterns := range p {
+		if ok, _ := path.Match(slugPattern, pipelineSlug); !ok {
+			continue
+		}
+		if matchAny(secretPatterns, secretName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that each pattern is well-formed.
+func (p PipelineSecrets) Validate() error {
+	for slugPattern, secretPatterns := range p {
+		if _, err := path.Match(slugPattern, ""); err != nil {
+			return fmt.Errorf("invalid pipeline-secrets pipeline pattern %q: %w", slugPattern, err)
+		}
+		for _, pattern := range secretPatterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pipeline-secrets secret pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package config
+
+// PriorityClassMapping maps a range of Buildkite job priorities to a
+// Kubernetes PriorityClass.
+type PriorityClassMapping struct {
+	// MinPriority is the lowest job priority matched (inclusive). If nil,
+	// there is no lower bound.
+	MinPriority *int `json:"min-priority" validate:"omitempty"`
+
+	// MaxPriority is the highest job priority matched (inclusive). If nil,
+	// there is no upper bound.
+	MaxPriority *int `json:"max-priority" validate:"omitempty"`
+
+	// PriorityClassName is the name of the PriorityClass for matching pods.
+	PriorityClassName string `json:"priority-class-name" validate:"required"`
+}
+
+// Matches reports whether the job priority is within the mapping's range.
+func (m PriorityClassMapping) Matches(priority int) bool {
+	if m.MinPriority != nil && priority < *m.MinPriority {
+		return false
+	}
+	if m.MaxPriority != nil && priority > *m.MaxPriority {
+		return false
+	}
+	return true
+}
+
+// PriorityClassFor returns the PriorityClass name from the first mapping
+// matching the job priority, or the empty string if none match.
+func PriorityClassFor(mappings []PriorityClassMapping, priority int) string {
+	for _, m := range mappings {
+		if m.Matches(priority) {
+			return m.PriorityClassName
+		}
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+
+	"k8s.io/utils/ptr"
+)
+
+func TestPriorityClassFor(t *testing.T) {
+	mappings := []PriorityClassMapping{
+		{MinPriority: ptr.To(10), PriorityClassName: "deploy"},
+		{MinPriority: ptr.To(1), MaxPriority: ptr.To(9), PriorityClassName: "normal"},
+		{MaxPriority: ptr.To(-1), PriorityClassName: "low"},
+	}
+
+	tests := []struct {
+		priority int
+		want     string
+	}{
+		{priority: 100, want: "deploy"},
+		{priority: 10, want: "deploy"},
+		{priority: 9, want: "normal"},
+		{priority: 1, want: "normal"},
+		{priority: 0, want: ""},
+		{priority: -1, want: "low"},
+	}
+
+	for _, test := range tests {
+		if got := PriorityClassFor(mappings, test.priority); got != test.want {
+			t.Errorf("PriorityClassFor(mappings, %d) = %q, want %q", test.priority, got, test.want)
+		}
+	}
+}
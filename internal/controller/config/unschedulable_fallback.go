@@ -0,0 +1,23 @@
+package config
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// UnschedulableFallback describes an alternative placement for a job whose pod
+// remains unschedulable, for example on-demand nodes instead of spot nodes.
+type UnschedulableFallback struct {
+	// Name identifies the fallback in logs and metrics.
+	Name string `json:"name" validate:"required"`
+
+	// After is how long the pod must be unschedulable before the fallback is
+	// used.
+	After time.Duration `json:"after" validate:"required"`
+
+	// PodSpecPatch is applied to the pod spec of the replacement job. The
+	// replacement is made from the job it replaces, so the patches of earlier
+	// fallbacks still apply.
+	PodSpecPatch *corev1.PodSpec `json:"pod-spec-patch" validate:"required"`
+}
@@ -9,13 +9,18 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/admission"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/agenttags"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/apitoken"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/deduper"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/limiter"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/monitor"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/scaler"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/scheduler"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/tracing"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
@@ -55,6 +60,49 @@ func Run(
 		httpMuxes[addr] = mux
 	}
 
+	// Tracing follows each job from the monitor's query through to the agent
+	// starting in its pod.
+	if cfg.TracingEndpoint != "" {
+		shutdown, err := tracing.Start(ctx, cfg.TracingEndpoint)
+		if err != nil {
+			logger.Fatal("failed to start tracing", zap.Error(err))
+		}
+		defer func() {
+			if err := shutdown(context.Background()); err != nil {
+				logger.Warn("failed to flush traces", zap.Error(err))
+			}
+		}()
+	}
+
+	// The agent token cache is needed for the readiness check, so start it
+	// before serving. Missing secrets are reported, not fatal, because they may
+	// be created after the controller.
+	agentTokens := scheduler.NewAgentTokenCache(logger.Named("agentTokens"), k8sClient, cfg)
+	if err := agentTokens.Start(ctx); err != nil {
+		logger.Fatal("failed to watch agent token secrets", zap.Error(err))
+	}
+
+	if cfg.HealthPort > 0 {
+		logger.Info("health check handlers listening for requests")
+		addr := ":" + strconv.Itoa(int(cfg.HealthPort))
+		// As above, the health checks may share a mux with other handlers.
+		mux := httpMuxes[addr]
+		if mux == nil {
+			mux = http.NewServeMux()
+		}
+		mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintln(w, "ok")
+		})
+		mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, _ *http.Request) {
+			if err := agentTokens.Ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintln(w, "ok")
+		})
+		httpMuxes[addr] = mux
+	}
+
 	for addr, mux := range httpMuxes {
 		go func() {
 			svr := &http.Server{
@@ -66,6 +114,19 @@ func Run(
 		}()
 	}
 
+	// The Buildkite API token may be reloaded from a file, and is shared by
+	// all the GraphQL clients.
+	var apiTokens api.TokenSource = apitoken.NewStatic(logger.Named("apitoken"), cfg.BuildkiteToken)
+	if cfg.BuildkiteTokenFile != "" {
+		tokenFile, err := apitoken.NewFile(logger.Named("apitoken"), cfg.BuildkiteTokenFile)
+		if err != nil {
+			logger.Fatal("failed to load Buildkite API token", zap.Error(err))
+		}
+		go tokenFile.Watch(ctx, apitoken.DefaultReloadInterval)
+		apiTokens = tokenFile
+	}
+	gql := api.NewClientWithTokenSource(apiTokens, cfg.GraphQLEndpoint)
+
 	// Monitor polls Buildkite GraphQL for jobs. It passes them to Deduper.
 	// Job flow: monitor -> deduper -> limiter -> scheduler.
 	m, err := monitor.New(logger.Named("monitor"), k8sClient, monitor.Config{
@@ -78,7 +139,7 @@ func Run(
 		StaleJobDataTimeout:    cfg.StaleJobDataTimeout,
 		JobCreationConcurrency: cfg.JobCreationConcurrency,
 		Tags:                   cfg.Tags,
-		Token:                  cfg.BuildkiteToken,
+		Tokens:                 apiTokens,
 		GraphQLResultsLimit:    cfg.GraphQLResultsLimit,
 		EnableQueuePause:       cfg.EnableQueuePause,
 	})
@@ -86,6 +147,28 @@ func Run(
 		logger.Fatal("failed to create monitor", zap.Error(err))
 	}
 
+	// The external scaler serves the monitor's view of the queue's backlog to
+	// KEDA, so capacity can be scaled before pods are pending.
+	if cfg.ScalerPort > 0 {
+		addr := ":" + strconv.Itoa(int(cfg.ScalerPort))
+		s := scaler.New(logger.Named("scaler"), m, cfg.PollInterval)
+		go func() {
+			if err := s.Serve(ctx, addr); err != nil {
+				logger.Error("external scaler exited", zap.Error(err))
+			}
+		}()
+	}
+
+	// Check the PriorityClasses exist now, rather than failing every job.
+	if err := validatePriorityClasses(ctx, k8sClient, cfg.PriorityClasses); err != nil {
+		logger.Fatal("invalid priority-classes config", zap.Error(err))
+	}
+
+	// The recorder records the controller's decisions (creating, failing,
+	// evicting, cleaning up) as events on Kubernetes jobs and pods, so they
+	// show up in `kubectl describe`.
+	recorder := scheduler.NewEventRecorder(ctx, k8sClient)
+
 	// Scheduler does the complicated work of converting a Buildkite job into
 	// a pod to run that job. It talks to the k8s API to create pods.
 	sched := scheduler.New(logger.Named("scheduler"), k8sClient, scheduler.Config{
@@ -106,6 +189,16 @@ func Run(
 		PodSpecPatch:                  cfg.PodSpecPatch,
 		ProhibitK8sPlugin:             cfg.ProhibitKubernetesPlugin,
 		AllowPodSpecPatchUnsafeCmdMod: cfg.AllowPodSpecPatchUnsafeCmdMod,
+		PriorityClasses:               cfg.PriorityClasses,
+		AdmissionMode:                 cfg.AdmissionMode,
+		KueueQueueName:                cfg.KueueQueueName,
+		JobEnv:                        cfg.JobEnv,
+		PipelineSecrets:               cfg.PipelineSecrets,
+		FailureExitStatuses:           cfg.FailureExitStatuses,
+		SidecarLogTailLines:           cfg.SidecarLogTailLines,
+		AgentTokenSecrets:             cfg.AgentTokenSecrets,
+		AgentTokens:                   agentTokens,
+		Recorder:                      recorder,
 	})
 
 	informerFactory, err := NewInformerFactory(k8sClient, cfg.Namespace, cfg.Tags)
@@ -114,11 +207,26 @@ func Run(
 	}
 
 	nextHandler := model.JobHandler(sched)
-	if cfg.MaxInFlight > 0 {
+	switch {
+	case cfg.AdmissionMode == config.AdmissionModeBuiltin:
+		// With built-in admission, jobs are created suspended, and the
+		// admitter unsuspends up to cfg.MaxInFlight at once (if configured).
+		admitter := admission.New(logger.Named("admission"), k8sClient, cfg.MaxInFlight)
+		if err := admitter.RegisterInformer(ctx, informerFactory); err != nil {
+			logger.Fatal("failed to register admission informer", zap.Error(err))
+		}
+
+	case cfg.AdmissionMode == config.AdmissionModeKueue:
+		// Kueue is responsible for quotas, so no limiter is needed.
+
+	case cfg.MaxInFlight > 0:
 		// Limiter prevents scheduling more than cfg.MaxInFlight jobs at once
 		//    (if configured)
 		// Once it figures out a job can be scheduled, it passes to the scheduler.
 		limiter := limiter.New(logger.Named("limiter"), sched, cfg.MaxInFlight)
+		if cfg.EnableLimiterPreemption {
+			limiter.EnablePreemption(k8sClient)
+		}
 		if err := limiter.RegisterInformer(ctx, informerFactory); err != nil {
 			logger.Fatal("failed to register limiter informer", zap.Error(err))
 		}
@@ -137,7 +245,8 @@ func Run(
 	// in order to clean up the pod. This is necessary because "sidecars" are
 	// not internally managed by buildkite-agent, and would continue running
 	// forever, preventing the pod being cleaned up.
-	completions := scheduler.NewPodCompletionWatcher(logger.Named("completions"), k8sClient)
+	// If the agent failed, it also captures the tail of each sidecar's logs.
+	completions := scheduler.NewPodCompletionWatcher(logger.Named("completions"), k8sClient, recorder, cfg)
 	if err := completions.RegisterInformer(ctx, informerFactory); err != nil {
 		logger.Fatal("failed to register completions informer", zap.Error(err))
 	}
@@ -148,12 +257,23 @@ func Run(
 	jobWatcher := scheduler.NewJobWatcher(
 		logger.Named("jobWatcher"),
 		k8sClient,
+		agentTokens,
+		recorder,
 		cfg,
 	)
 	if err := jobWatcher.RegisterInformer(ctx, informerFactory); err != nil {
 		logger.Fatal("failed to register jobWatcher informer", zap.Error(err))
 	}
 
+	// OrphanReconciler deletes Kubernetes jobs whose Buildkite job is over,
+	// but which would otherwise linger until their active deadline.
+	if cfg.OrphanedJobGracePeriod > 0 {
+		orphans := scheduler.NewOrphanReconciler(logger.Named("orphans"), k8sClient, gql, cfg)
+		if err := orphans.RegisterInformer(ctx, informerFactory); err != nil {
+			logger.Fatal("failed to register orphan reconciler informer", zap.Error(err))
+		}
+	}
+
 	// PodWatcher watches for other conditions to clean up pods:
 	// * Pods where an init container failed for any reason
 	// * Pods where a container is in ImagePullBackOff for too long
@@ -161,12 +281,30 @@ func Run(
 	podWatcher := scheduler.NewPodWatcher(
 		logger.Named("podWatcher"),
 		k8sClient,
+		gql,
+		agentTokens,
+		recorder,
 		cfg,
 	)
 	if err := podWatcher.RegisterInformer(ctx, informerFactory); err != nil {
 		logger.Fatal("failed to register podWatcher informer", zap.Error(err))
 	}
 
+	// PodSpanRecorder records when each pod was scheduled and its agent
+	// started, as part of the job's trace.
+	if cfg.TracingEndpoint != "" {
+		podSpans := scheduler.NewPodSpanRecorder(logger.Named("podSpans"))
+		if err := podSpans.RegisterInformer(ctx, informerFactory); err != nil {
+			logger.Fatal("failed to register podSpans informer", zap.Error(err))
+		}
+	}
+
+	// PodStartupWatcher records how long each phase of pod startup took.
+	podStartup := scheduler.NewPodStartupWatcher(logger.Named("podStartup"), cfg)
+	if err := podStartup.RegisterInformer(ctx, informerFactory); err != nil {
+		logger.Fatal("failed to register podStartup informer", zap.Error(err))
+	}
+
 	select {
 	case <-ctx.Done():
 		logger.Info("controller exiting", zap.Error(ctx.Err()))
@@ -183,6 +321,23 @@ func NewInformerFactory(
 	namespace string,
 	tags []string,
 ) (informers.SharedInformerFactory, error) {
+	selector, err := JobSelector(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return informers.NewSharedInformerFactoryWithOptions(
+		k8s,
+		0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opt *metav1.ListOptions) {
+			opt.LabelSelector = selector.String()
+		}),
+	), nil
+}
+
+// JobSelector returns the label selector used by NewInformerFactory.
+func JobSelector(tags []string) (labels.Selector, error) {
 	labelsFromTags, errs := agenttags.LabelsFromTags(tags)
 	if len(errs) != 0 {
 		return nil, errors.Join(errs...)
@@ -203,12 +358,20 @@ func NewInformerFactory(
 		requirements = append(requirements, *hasLabel)
 	}
 
-	return informers.NewSharedInformerFactoryWithOptions(
-		k8s,
-		0,
-		informers.WithNamespace(namespace),
-		informers.WithTweakListOptions(func(opt *metav1.ListOptions) {
-			opt.LabelSelector = labels.NewSelector().Add(requirements...).String()
-		}),
-	), nil
+	return labels.NewSelector().Add(requirements...), nil
+}
+
+// validatePriorityClasses checks that each priority range is well-formed and
+// each PriorityClass exists.
+func validatePriorityClasses(ctx context.Context, k8s kubernetes.Interface, mappings []config.PriorityClassMapping) error {
+	var errs []error
+	for _, m := range mappings {
+		if m.MinPriority != nil && m.MaxPriority != nil && *m.MinPriority > *m.MaxPriority {
+			errs = append(errs, fmt.Errorf("priority class %q: min-priority %d is greater than max-priority %d", m.PriorityClassName, *m.MinPriority, *m.MaxPriority))
+		}
+		if _, err := k8s.SchedulingV1().PriorityClasses().Get(ctx, m.PriorityClassName, metav1.GetOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("priority class %q: %w", m.PriorityClassName, err))
+		}
+	}
+	return errors.Join(errs...)
 }
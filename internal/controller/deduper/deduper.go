@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/tracing"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/client-go/informers"
@@ -67,7 +70,8 @@ func (d *Deduper) RegisterInformer(ctx context.Context, factory informers.Shared
 
 // Handle passes the job to the next handler if the job is not already
 // scheduled. Otherwise, it returns [model.ErrDuplicateJob].
-func (d *Deduper) Handle(ctx context.Context, job model.Job) error {
+func (d *Deduper) Handle(ctx context.Context, job model.Job) (err error) {
+	start := time.Now()
 	uuid, err := uuid.Parse(job.Uuid)
 	if err != nil {
 		d.logger.Error("invalid UUID in CommandJob", zap.Error(err))
@@ -83,6 +87,12 @@ func (d *Deduper) Handle(ctx context.Context, job model.Job) error {
 	}
 	jobsMarkedRunningCounter.WithLabelValues("Handle").Inc()
 
+	// The job isn't a duplicate, so it is worth tracing from here on.
+	ctx, span := tracing.StartJob(ctx, job.Uuid, job.QueriedAt, job.PolledAt)
+	defer func() { tracing.End(span, err) }()
+	_, dedupe := tracing.Tracer().Start(ctx, "deduper", trace.WithTimestamp(start))
+	dedupe.End()
+
 	// Not a duplicate: pass to the next handler, which could be either the
 	// limiter or the scheudler.
 	d.logger.Debug("passing job to next handler",
@@ -174,6 +184,10 @@ func (d *Deduper) OnDelete(prev any) {
 	if prevState == nil {
 		return
 	}
+	// A replaced job is deleted, but its replacement is still in flight.
+	if model.JobReplaced(prevState) {
+		return
+	}
 	// Whether or not the job had become terminal, it's now deleted.
 	id, err := uuid.Parse(prevState.Labels[config.UUIDLabel])
 	if err != nil {
@@ -5,10 +5,13 @@ import (
 	"testing"
 
 	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/deduper"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
 	"github.com/google/uuid"
 	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestDeduper_SkipsDuplicateJobs(t *testing.T) {
@@ -46,3 +49,35 @@ func TestDeduper_SkipsDuplicateJobs(t *testing.T) {
 		t.Errorf("handler.Errors = %d, want %d", got, want)
 	}
 }
+
+func TestDeduper_ReplacedJobStaysInFlight(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := &model.FakeScheduler{}
+	dd := deduper.New(zaptest.NewLogger(t), handler)
+
+	uuid := uuid.New().String()
+	if err := dd.Handle(ctx, model.Job{CommandJob: &api.CommandJob{Uuid: uuid}}); err != nil {
+		t.Fatalf("dd.Handle(ctx, &job) = %v", err)
+	}
+
+	// Deleting a job that was replaced by a fallback shouldn't allow the
+	// Buildkite job to be scheduled again.
+	dd.OnDelete(&batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      map[string]string{config.UUIDLabel: uuid},
+			Annotations: map[string]string{config.ReplacedByAnnotation: "buildkite-" + uuid + "-fallback-1"},
+		},
+	})
+	if err := dd.Handle(ctx, model.Job{CommandJob: &api.CommandJob{Uuid: uuid}}); err != model.ErrDuplicateJob {
+		t.Errorf("dd.Handle(ctx, &job) = %v, want %v", err, model.ErrDuplicateJob)
+	}
+
+	handler.Wait()
+	if got, want := len(handler.Running), 1; got != want {
+		t.Errorf("len(handler.Running) = %d, want %d", got, want)
+	}
+}
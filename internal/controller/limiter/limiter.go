@@ -4,14 +4,20 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/tracing"
 
 	"go.uber.org/zap"
 	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 )
 
@@ -32,8 +38,20 @@ type MaxInFlight struct {
 	// When a job starts, it takes a token from the bucket.
 	// When a job ends, it puts a token back in the bucket.
 	tokenBucket chan struct{}
+
+	// Used for preemption, if enabled.
+	k8s       kubernetes.Interface
+	jobLister batchlisters.JobLister
+	podLister corelisters.PodLister
+
+	// Jobs deleted by preemption, whose tokens were handed to other jobs.
+	preemptedMu sync.Mutex
+	preempted   map[types.UID]struct{}
 }
 
+// How often a waiting job looks for a lower-priority job to preempt.
+const preemptionInterval = 5 * time.Second
+
 // New creates a MaxInFlight limiter. maxInFlight must be at least 1.
 func New(logger *zap.Logger, scheduler model.JobHandler, maxInFlight int) *MaxInFlight {
 	if maxInFlight <= 0 {
@@ -67,9 +85,16 @@ func (l *MaxInFlight) RegisterInformer(ctx context.Context, factory informers.Sh
 	if err != nil {
 		return err
 	}
+	hasSynced := []cache.InformerSynced{reg.HasSynced}
+	if l.k8s != nil {
+		podInformer := factory.Core().V1().Pods()
+		hasSynced = append(hasSynced, podInformer.Informer().HasSynced)
+		l.jobLister = informer.Lister()
+		l.podLister = podInformer.Lister()
+	}
 	go factory.Start(ctx.Done())
 
-	if !cache.WaitForCacheSync(ctx.Done(), reg.HasSynced) {
+	if !cache.WaitForCacheSync(ctx.Done(), hasSynced...) {
 		return fmt.Errorf("failed to sync informer cache")
 	}
 
@@ -81,18 +106,44 @@ func (l *MaxInFlight) RegisterInformer(ctx context.Context, factory informers.Sh
 // becomes too stale while waiting for capacity.
 func (l *MaxInFlight) Handle(ctx context.Context, job model.Job) error {
 	// Block until there's a token in the bucket, or cancel if the job
-	// information becomes too stale.
+	// information becomes too stale. If preemption is enabled, periodically
+	// try to take the token from a lower-priority job instead.
 	start := time.Now()
-	select {
-	case <-ctx.Done():
-		return context.Cause(ctx)
+	_, span := tracing.Tracer().Start(ctx, "limiter.wait")
+	var preemptCh <-chan time.Time
+	if l.k8s != nil {
+		ticker := time.NewTicker(preemptionInterval)
+		defer ticker.Stop()
+		preemptCh = ticker.C
+	}
+	acquired := false
+	if preemptCh != nil {
+		// Try preempting straight away if there are no tokens.
+		select {
+		case <-l.tokenBucket:
+			acquired = true
+		default:
+			acquired = l.tryPreempt(ctx, job)
+		}
+	}
+	for !acquired {
+		select {
+		case <-ctx.Done():
+			tracing.End(span, context.Cause(ctx))
+			return context.Cause(ctx)
 
-	case <-job.StaleCh:
-		return model.ErrStaleJob
+		case <-job.StaleCh:
+			tracing.End(span, model.ErrStaleJob)
+			return model.ErrStaleJob
 
-	case <-l.tokenBucket:
-		// Continue below.
+		case <-l.tokenBucket:
+			acquired = true
+
+		case <-preemptCh:
+			acquired = l.tryPreempt(ctx, job)
+		}
 	}
+	span.End()
 	tokenWaitDurationHistogram.Observe(time.Since(start).Seconds())
 	l.logger.Debug("token acquired",
 		zap.String("job-uuid", job.Uuid),
@@ -137,7 +188,9 @@ func (l *MaxInFlight) OnAdd(obj any, inInitialList bool) {
 	// If it's added as already finished, no need to take a token for it.
 	// Otherwise, try to take one, but don't block (in case the stack was
 	// restarted with a different limit).
-	if !model.JobFinished(job) {
+	// Replaced jobs are about to be deleted, and their replacements have
+	// tokens of their own.
+	if !model.JobFinished(job) && !model.JobReplaced(job) {
 		l.tryTakeToken("OnAdd")
 		l.logger.Debug("existing not-finished job discovered",
 			zap.String("job-uuid", job.Labels[config.UUIDLabel]),
@@ -175,8 +228,12 @@ func (l *MaxInFlight) OnDelete(obj any) {
 
 	// OnDelete gives us the last-known state prior to deletion.
 	// If that state was finished, we've already returned a token.
-	// If that state was not-finished, we need to return a token now.
-	if !model.JobFinished(prevState) {
+	// If that state was not-finished, we need to return a token now, unless
+	// the token was handed on to a replacement job or a preempting job.
+	if l.wasPreempted(prevState) {
+		return
+	}
+	if !model.JobFinished(prevState) && !model.JobReplaced(prevState) {
 		l.tryReturnToken("OnDelete")
 		l.logger.Debug("not-finished job was deleted",
 			zap.String("job-uuid", prevState.Labels[config.UUIDLabel]),
@@ -5,13 +5,21 @@ import (
 	"errors"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/limiter"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestLimiter(t *testing.T) {
@@ -83,3 +91,123 @@ func TestLimiter_SkipsCreateErrors(t *testing.T) {
 		t.Errorf("handler.errors = %d, want %d", got, want)
 	}
 }
+
+func TestLimiter_PreemptsLowerPriorityPendingJob(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lowUUID := uuid.New().String()
+	k8s := fake.NewClientset(
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "buildkite-" + lowUUID,
+				Namespace:   "buildkite",
+				UID:         "low",
+				Labels:      map[string]string{config.UUIDLabel: lowUUID},
+				Annotations: map[string]string{config.PriorityAnnotation: "0"},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "buildkite-" + lowUUID + "-abcde",
+				Namespace: "buildkite",
+				Labels:    map[string]string{config.UUIDLabel: lowUUID},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodPending},
+		},
+	)
+
+	handler := &model.FakeScheduler{}
+	limiter := limiter.New(zaptest.NewLogger(t), handler, 1)
+	limiter.EnablePreemption(k8s)
+	factory := informers.NewSharedInformerFactory(k8s, 0)
+	if err := limiter.RegisterInformer(ctx, factory); err != nil {
+		t.Fatalf("limiter.RegisterInformer(ctx, factory) = %v", err)
+	}
+
+	// The low-priority job has the only token, so the high-priority job can
+	// only be handled by preempting it.
+	job := model.Job{CommandJob: &api.CommandJob{Uuid: uuid.New().String(), Priority: api.CommandJobPriority{Number: 10}}}
+	if err := limiter.Handle(ctx, job); err != nil {
+		t.Fatalf("limiter.Handle(ctx, &job) = %v", err)
+	}
+
+	if _, err := k8s.BatchV1().Jobs("buildkite").Get(ctx, "buildkite-"+lowUUID, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+		t.Errorf("Get(low-priority job) error = %v, want NotFound", err)
+	}
+	handler.Wait()
+	if got, want := len(handler.Running), 1; got != want {
+		t.Errorf("len(handler.Running) = %d, want %d", got, want)
+	}
+}
+
+func TestLimiter_ConcurrentPreemptionsClaimVictimOnce(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lowUUID := uuid.New().String()
+	k8s := fake.NewClientset(
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "buildkite-" + lowUUID,
+				Namespace:   "buildkite",
+				UID:         "low",
+				Labels:      map[string]string{config.UUIDLabel: lowUUID},
+				Annotations: map[string]string{config.PriorityAnnotation: "0"},
+			},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "buildkite-" + lowUUID + "-abcde",
+				Namespace: "buildkite",
+				Labels:    map[string]string{config.UUIDLabel: lowUUID},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodPending},
+		},
+	)
+
+	handler := &model.FakeScheduler{}
+	limiter := limiter.New(zaptest.NewLogger(t), handler, 1)
+	limiter.EnablePreemption(k8s)
+	factory := informers.NewSharedInformerFactory(k8s, 0)
+	if err := limiter.RegisterInformer(ctx, factory); err != nil {
+		t.Fatalf("limiter.RegisterInformer(ctx, factory) = %v", err)
+	}
+
+	// Two high-priority jobs wait for the only token, held by the one
+	// low-priority job. Only one of them can preempt it, and deleting it must
+	// not return its token for the other to take.
+	stale := make(chan struct{})
+	time.AfterFunc(time.Second, func() { close(stale) })
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			errs <- limiter.Handle(ctx, model.Job{
+				CommandJob: &api.CommandJob{Uuid: uuid.New().String(), Priority: api.CommandJobPriority{Number: 10}},
+				StaleCh:    stale,
+			})
+		}()
+	}
+	var handled, staled int
+	for range 2 {
+		switch err := <-errs; {
+		case err == nil:
+			handled++
+		case errors.Is(err, model.ErrStaleJob):
+			staled++
+		default:
+			t.Errorf("limiter.Handle(ctx, &job) = %v", err)
+		}
+	}
+	if handled != 1 || staled != 1 {
+		t.Errorf("handled, stale jobs = %d, %d, want 1, 1", handled, staled)
+	}
+	handler.Wait()
+	if got, want := len(handler.Running), 1; got != want {
+		t.Errorf("len(handler.Running) = %d, want %d", got, want)
+	}
+}
@@ -67,6 +67,19 @@ var (
 		Help:      "Count of OnDelete informer events",
 	})
 
+	preemptionsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "preemptions_total",
+		Help:      "Count of lower-priority jobs deleted to make room for higher-priority jobs",
+	})
+	preemptionErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "preemption_errors_total",
+		Help:      "Count of failures to delete lower-priority jobs for preemption",
+	}, []string{"reason"})
+
 	tokenUnderflowCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: promNamespace,
 		Subsystem: promSubsystem,
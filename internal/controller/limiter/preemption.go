@@ -0,0 +1,168 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// EnablePreemption allows the limiter to make room for a waiting job by
+// deleting a lower-priority Kubernetes job whose pod hasn't started yet (so the
+// agent hasn't acquired the Buildkite job). The Buildkite job remains
+// scheduled, and will be picked up again on a later poll. It must be called
+// before RegisterInformer.
+func (l *MaxInFlight) EnablePreemption(k8s kubernetes.Interface) {
+	l.k8s = k8s
+	l.preempted = make(map[types.UID]struct{})
+}
+
+// tryPreempt attempts to delete a job with lower priority than the given job,
+// whose token can then be used by the given job. It reports whether it did so.
+func (l *MaxInFlight) tryPreempt(ctx context.Context, job model.Job) bool {
+	if l.k8s == nil || l.jobLister == nil || l.podLister == nil {
+		return false
+	}
+	log := l.logger.With(
+		zap.String("job-uuid", job.Uuid),
+		zap.Int("priority", job.Priority.Number),
+	)
+
+	victim, err := l.claimPreemptionVictim(job.Priority.Number)
+	if err != nil {
+		log.Warn("couldn't find a job to preempt", zap.Error(err))
+		return false
+	}
+	if victim == nil {
+		return false
+	}
+	log = log.With(
+		zap.String("preempted-job-uuid", victim.Labels[config.UUIDLabel]),
+		zap.String("preempted-priority", victim.Annotations[config.PriorityAnnotation]),
+	)
+
+	// The lock isn't held while deleting, so that OnDelete (which checks
+	// wasPreempted) isn't held up by a slow API call.
+	err = l.k8s.BatchV1().Jobs(victim.Namespace).Delete(ctx, victim.Name, metav1.DeleteOptions{
+		PropagationPolicy: ptr.To(metav1.DeletePropagationBackground),
+		Preconditions:     &metav1.Preconditions{UID: ptr.To(victim.UID)},
+	})
+	if err != nil {
+		preemptionErrorsCounter.WithLabelValues(string(kerrors.ReasonForError(err))).Inc()
+		if !l.unclaim(victim.UID) {
+			// The job was deleted anyway (e.g. by someone else), and OnDelete
+			// already saw the claim, so its token wasn't returned to the
+			// bucket. It's this job's to use.
+			log.Info("job to preempt was deleted by someone else", zap.Error(err))
+			return true
+		}
+		log.Warn("couldn't delete job to preempt it", zap.Error(err))
+		return false
+	}
+	preemptionsCounter.Inc()
+	log.Info("preempted lower-priority job that had not started")
+	return true
+}
+
+// claimPreemptionVictim chooses a job to preempt (see preemptionVictim), and
+// claims its token before it is deleted, so that OnDelete doesn't return the
+// token to the bucket for some other job to take. Choosing and claiming happen
+// under one lock, so that two waiting jobs can't choose the same victim.
+func (l *MaxInFlight) claimPreemptionVictim(priority int) (*batchv1.Job, error) {
+	l.preemptedMu.Lock()
+	defer l.preemptedMu.Unlock()
+	victim, err := l.preemptionVictim(priority)
+	if victim != nil {
+		l.preempted[victim.UID] = struct{}{}
+	}
+	return victim, err
+}
+
+// unclaim forgets the claim on a job that couldn't be deleted. It reports
+// whether the claim was still there: if not, OnDelete has already seen it.
+func (l *MaxInFlight) unclaim(uid types.UID) bool {
+	l.preemptedMu.Lock()
+	defer l.preemptedMu.Unlock()
+	_, claimed := l.preempted[uid]
+	delete(l.preempted, uid)
+	return claimed
+}
+
+// preemptionVictim returns the lowest-priority job with priority below the
+// given priority whose pods are all still pending, or nil if there are none.
+// Among jobs with equal priority, the most recently created is chosen. Jobs
+// already preempted are skipped. It must be called with preemptedMu held.
+func (l *MaxInFlight) preemptionVictim(priority int) (*batchv1.Job, error) {
+	jobs, err := l.jobLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("listing jobs: %w", err)
+	}
+
+	var victim *batchv1.Job
+	victimPriority := 0
+	for _, kjob := range jobs {
+		if kjob.DeletionTimestamp != nil || model.JobFinished(kjob) || model.JobReplaced(kjob) {
+			continue
+		}
+		if _, claimed := l.preempted[kjob.UID]; claimed {
+			continue
+		}
+		p, err := strconv.Atoi(kjob.Annotations[config.PriorityAnnotation])
+		if err != nil || p >= priority {
+			continue
+		}
+		if victim != nil && (p > victimPriority || (p == victimPriority && kjob.CreationTimestamp.Before(&victim.CreationTimestamp))) {
+			continue
+		}
+		started, err := l.jobStarted(kjob)
+		if err != nil {
+			return nil, err
+		}
+		if started {
+			continue
+		}
+		victim, victimPriority = kjob, p
+	}
+	return victim, nil
+}
+
+// jobStarted reports whether any pod of the job has left the Pending phase,
+// in which case the agent may have acquired the Buildkite job.
+func (l *MaxInFlight) jobStarted(kjob *batchv1.Job) (bool, error) {
+	selector := labels.SelectorFromSet(labels.Set{config.UUIDLabel: kjob.Labels[config.UUIDLabel]})
+	pods, err := l.podLister.Pods(kjob.Namespace).List(selector)
+	if err != nil {
+		return false, fmt.Errorf("listing pods: %w", err)
+	}
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodPending {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// wasPreempted reports whether the job was deleted by tryPreempt, in which
+// case its token has already been handed on. It forgets about the job.
+func (l *MaxInFlight) wasPreempted(kjob *batchv1.Job) bool {
+	if l.preempted == nil {
+		return false
+	}
+	l.preemptedMu.Lock()
+	defer l.preemptedMu.Unlock()
+	_, preempted := l.preempted[kjob.UID]
+	delete(l.preempted, kjob.UID)
+	return preempted
+}
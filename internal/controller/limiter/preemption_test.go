@@ -0,0 +1,100 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTryPreempt_DoesntBlockOnDeleteWhileDeleting(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lowUUID := uuid.New().String()
+	victim := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "buildkite-" + lowUUID,
+			Namespace:   "buildkite",
+			UID:         "low",
+			Labels:      map[string]string{config.UUIDLabel: lowUUID},
+			Annotations: map[string]string{config.PriorityAnnotation: "0"},
+		},
+	}
+	k8s := fake.NewClientset(
+		victim,
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "buildkite-" + lowUUID + "-abcde",
+				Namespace: "buildkite",
+				Labels:    map[string]string{config.UUIDLabel: lowUUID},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodPending},
+		},
+	)
+
+	l := New(zaptest.NewLogger(t), &model.FakeScheduler{}, 1)
+	l.EnablePreemption(k8s)
+	factory := informers.NewSharedInformerFactory(k8s, 0)
+	if err := l.RegisterInformer(ctx, factory); err != nil {
+		t.Fatalf("l.RegisterInformer(ctx, factory) = %v", err)
+	}
+
+	// While the victim is being deleted, other jobs can be deleted too, and
+	// OnDelete must be able to check whether they were preempted.
+	other := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{UID: "other"}}
+	k8s.PrependReactor("delete", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+		done := make(chan struct{})
+		go func() {
+			l.wasPreempted(other)
+			close(done)
+		}()
+		select {
+		case <-done:
+			return false, nil, nil
+		case <-time.After(5 * time.Second):
+			return true, nil, errors.New("wasPreempted blocked during the delete")
+		}
+	})
+
+	job := model.Job{CommandJob: &api.CommandJob{Uuid: uuid.New().String(), Priority: api.CommandJobPriority{Number: 10}}}
+	if !l.tryPreempt(ctx, job) {
+		t.Errorf("l.tryPreempt(ctx, job) = false, want true")
+	}
+
+	// Once OnDelete has seen the claim, the victim's token must not have
+	// been returned to the bucket.
+	deadline := time.Now().Add(5 * time.Second)
+	for claimed(l, victim) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if claimed(l, victim) {
+		t.Fatal("OnDelete didn't see the preempted job")
+	}
+	if got := len(l.tokenBucket); got != 0 {
+		t.Errorf("len(l.tokenBucket) = %d, want 0", got)
+	}
+}
+
+func claimed(l *MaxInFlight, kjob *batchv1.Job) bool {
+	l.preemptedMu.Lock()
+	defer l.preemptedMu.Unlock()
+	_, ok := l.preempted[kjob.UID]
+	return ok
+}
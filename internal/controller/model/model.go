@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
 
 	batchv1 "k8s.io/api/batch/v1"
 )
@@ -34,6 +35,9 @@ type Job struct {
 
 	// When we began the Buildkite GraphQL query that returned this job.
 	QueriedAt time.Time
+
+	// When the Buildkite GraphQL query that returned this job finished.
+	PolledAt time.Time
 }
 
 // JobFinished reports if the job has a Complete or Failed status condition.
@@ -47,3 +51,11 @@ func JobFinished(job *batchv1.Job) bool {
 	}
 	return false
 }
+
+// JobReplaced reports if the job has been replaced by another Kubernetes job
+// for the same Buildkite job (e.g. a fallback for an unschedulable pod). The
+// Buildkite job remains in flight when a replaced job is deleted.
+func JobReplaced(job *batchv1.Job) bool {
+	_, replaced := job.Annotations[config.ReplacedByAnnotation]
+	return replaced
+}
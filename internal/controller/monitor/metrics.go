@@ -74,4 +74,23 @@ var (
 		Name:      "stale_jobs_total",
 		Help:      "Count of jobs that weren't scheduled because their information was queried too long ago",
 	})
+
+	scheduledJobsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "scheduled_jobs",
+		Help:      "Number of scheduled jobs in Buildkite matching any of the controller's tags, as of the most recent query",
+	}, []string{"queue"})
+	filteredOutJobsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "scheduled_jobs_filtered_out",
+		Help:      "Number of scheduled jobs returned by the most recent query that didn't match all the controller's tags",
+	}, []string{"queue"})
+	oldestScheduledJobAgeGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: promSubsystem,
+		Name:      "oldest_scheduled_job_age_seconds",
+		Help:      "How long the longest-waiting scheduled job that matches the controller's tags had been scheduled for, as of the most recent query",
+	}, []string{"queue"})
 )
@@ -27,12 +27,55 @@ type Monitor struct {
 	gql    graphql.Client
 	logger *zap.Logger
 	cfg    Config
+
+	backlogMu sync.RWMutex
+	backlog   Backlog
+}
+
+// Backlog describes the jobs waiting in the controller's queue, as of the
+// monitor's most recent query to Buildkite.
+type Backlog struct {
+	// Queue is the queue the monitor is polling.
+	Queue string
+
+	// ScheduledJobs is the number of scheduled jobs in Buildkite whose agent
+	// query rules match any of the controller's tags. This may exceed the
+	// number of jobs returned by the query (see GraphQLResultsLimit).
+	ScheduledJobs int
+
+	// FilteredOutJobs is the number of jobs returned by the query that the
+	// controller won't run, because they don't match all of its tags.
+	FilteredOutJobs int
+
+	// OldestScheduledAt is when the longest-waiting job returned by the query
+	// that the controller would run was scheduled. It is zero if there are no
+	// such jobs.
+	OldestScheduledAt time.Time
+
+	// UpdatedAt is when the query returned. It is zero until the first query
+	// succeeds.
+	UpdatedAt time.Time
+}
+
+// RunnableJobs estimates how many scheduled jobs the controller would run.
+func (b Backlog) RunnableJobs() int {
+	return max(b.ScheduledJobs-b.FilteredOutJobs, 0)
+}
+
+// OldestScheduledJobAge returns how long the longest-waiting job the
+// controller would run has been scheduled for, or 0 if there are none.
+func (b Backlog) OldestScheduledJobAge(now time.Time) time.Duration {
+	if b.OldestScheduledAt.IsZero() {
+		return 0
+	}
+	return max(now.Sub(b.OldestScheduledAt), 0)
 }
 
 type Config struct {
 	GraphQLEndpoint        string
 	Namespace              string
 	Token                  string
+	Tokens                 api.TokenSource // if set, used instead of Token
 	ClusterUUID            string
 	MaxInFlight            int
 	JobCreationConcurrency int
@@ -45,7 +88,11 @@ type Config struct {
 }
 
 func New(logger *zap.Logger, k8s kubernetes.Interface, cfg Config) (*Monitor, error) {
-	graphqlClient := api.NewClient(cfg.Token, cfg.GraphQLEndpoint)
+	var tokens api.TokenSource = api.StaticToken(cfg.Token)
+	if cfg.Tokens != nil {
+		tokens = cfg.Tokens
+	}
+	graphqlClient := api.NewClientWithTokenSource(tokens, cfg.GraphQLEndpoint)
 
 	// Poll no more frequently than every 1s (please don't DoS us).
 	cfg.PollInterval = min(cfg.PollInterval, time.Second)
@@ -75,6 +122,7 @@ func New(logger *zap.Logger, k8s kubernetes.Interface, cfg Config) (*Monitor, er
 type jobResp interface {
 	OrganizationExists() bool
 	CommandJobs() []*api.JobJobTypeCommand
+	ScheduledCount() int
 }
 
 type unclusteredJobResp api.GetScheduledJobsResponse
@@ -91,6 +139,10 @@ func (r unclusteredJobResp) CommandJobs() []*api.JobJobTypeCommand {
 	return jobs
 }
 
+func (r unclusteredJobResp) ScheduledCount() int {
+	return r.Organization.Jobs.Count
+}
+
 type clusteredJobResp api.GetScheduledJobsClusteredResponse
 
 func (r clusteredJobResp) OrganizationExists() bool {
@@ -105,6 +157,10 @@ func (r clusteredJobResp) CommandJobs() []*api.JobJobTypeCommand {
 	return jobs
 }
 
+func (r clusteredJobResp) ScheduledCount() int {
+	return r.Organization.Jobs.Count
+}
+
 // getScheduledCommandJobs calls either the clustered or unclustered GraphQL API
 // methods, depending on if a cluster uuid was provided in the config
 func (m *Monitor) getScheduledCommandJobs(ctx context.Context, queue string) (jobResp jobResp, err error) {
@@ -201,6 +257,7 @@ func (m *Monitor) Start(ctx context.Context, handler model.JobHandler) <-chan er
 				logger.Warn("failed to get scheduled command jobs", zap.Error(err))
 				continue
 			}
+			polledAt := time.Now()
 
 			if !resp.OrganizationExists() {
 				errs <- fmt.Errorf("invalid organization: %q", m.cfg.Org)
@@ -208,6 +265,7 @@ func (m *Monitor) Start(ctx context.Context, handler model.JobHandler) <-chan er
 			}
 
 			jobs := resp.CommandJobs()
+			m.setBacklog(backlogOf(queue, agentTags, resp.ScheduledCount(), jobs, polledAt))
 			if len(jobs) == 0 {
 				continue
 			}
@@ -216,20 +274,63 @@ func (m *Monitor) Start(ctx context.Context, handler model.JobHandler) <-chan er
 
 			// The next handler should be the Limiter (except in some tests).
 			// Limiter handles deduplicating jobs before passing to the scheduler.
-			m.passJobsToNextHandler(ctx, logger, handler, agentTags, jobs, queriedAt)
+			m.passJobsToNextHandler(ctx, logger, handler, agentTags, jobs, queriedAt, polledAt)
 		}
 	}()
 
 	return errs
 }
 
+// Backlog returns the backlog of the controller's queue, as of the most recent
+// query.
+func (m *Monitor) Backlog() Backlog {
+	m.backlogMu.RLock()
+	defer m.backlogMu.RUnlock()
+	return m.backlog
+}
+
+func (m *Monitor) setBacklog(backlog Backlog) {
+	m.backlogMu.Lock()
+	m.backlog = backlog
+	m.backlogMu.Unlock()
+
+	scheduledJobsGauge.WithLabelValues(backlog.Queue).Set(float64(backlog.ScheduledJobs))
+	filteredOutJobsGauge.WithLabelValues(backlog.Queue).Set(float64(backlog.FilteredOutJobs))
+	oldestScheduledJobAgeGauge.WithLabelValues(backlog.Queue).Set(backlog.OldestScheduledJobAge(backlog.UpdatedAt).Seconds())
+}
+
+// backlogOf summarises the response to a query for scheduled jobs. count is
+// the total reported by Buildkite, and jobs are those returned.
+func backlogOf(queue string, agentTags map[string]string, count int, jobs []*api.JobJobTypeCommand, polledAt time.Time) Backlog {
+	backlog := Backlog{
+		Queue:         queue,
+		ScheduledJobs: count,
+		UpdatedAt:     polledAt,
+	}
+	for _, j := range jobs {
+		// Tag errors are logged by jobHandlerWorker.
+		jobTags, _ := agenttags.TagMapFromTags(j.AgentQueryRules)
+		if !agenttags.JobTagsMatchAgentTags(maps.All(jobTags), agentTags) {
+			backlog.FilteredOutJobs++
+			continue
+		}
+		if j.ScheduledAt.IsZero() {
+			continue
+		}
+		if backlog.OldestScheduledAt.IsZero() || j.ScheduledAt.Before(backlog.OldestScheduledAt) {
+			backlog.OldestScheduledAt = j.ScheduledAt
+		}
+	}
+	return backlog
+}
+
 func (m *Monitor) passJobsToNextHandler(
 	ctx context.Context,
 	logger *zap.Logger,
 	handler model.JobHandler,
 	agentTags map[string]string,
 	jobs []*api.JobJobTypeCommand,
-	queriedAt time.Time,
+	queriedAt, polledAt time.Time,
 ) {
 	// A sneaky way to create a channel that is closed after a duration.
 	// Why not pass directly to handler.Handle? Because that might
@@ -268,7 +369,7 @@ func (m *Monitor) passJobsToNextHandler(
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			jobHandlerWorker(ctx, staleCtx, logger, handler, agentTags, queriedAt, jobsCh)
+			jobHandlerWorker(ctx, staleCtx, logger, handler, agentTags, queriedAt, polledAt, jobsCh)
 		}()
 	}
 	defer wg.Wait()
@@ -291,7 +392,7 @@ func jobHandlerWorker(
 	logger *zap.Logger,
 	handler model.JobHandler,
 	agentTags map[string]string,
-	queriedAt time.Time,
+	queriedAt, polledAt time.Time,
 	jobsCh <-chan *api.JobJobTypeCommand,
 ) {
 	for {
@@ -323,6 +424,7 @@ func jobHandlerWorker(
 				CommandJob: &j.CommandJob,
 				StaleCh:    staleCtx.Done(),
 				QueriedAt:  queriedAt,
+				PolledAt:   polledAt,
 			}
 
 			// The next handler should be the deduper (except in some tests).
@@ -0,0 +1,72 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func commandJob(scheduledAt time.Time, agentQueryRules ...string) *api.JobJobTypeCommand {
+	return &api.JobJobTypeCommand{CommandJob: api.CommandJob{
+		AgentQueryRules: agentQueryRules,
+		ScheduledAt:     scheduledAt,
+	}}
+}
+
+func TestBacklog(t *testing.T) {
+	polledAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	agentTags := map[string]string{"queue": "kubernetes", "os": "linux"}
+	jobs := []*api.JobJobTypeCommand{
+		commandJob(polledAt.Add(-time.Minute), "queue=kubernetes"),
+		commandJob(polledAt.Add(-3*time.Minute), "queue=kubernetes", "os=linux"),
+		// Filtered out, so not the oldest despite being scheduled earliest.
+		commandJob(polledAt.Add(-time.Hour), "queue=kubernetes", "os=windows"),
+		// Without a scheduled time, it's counted but can't be the oldest.
+		commandJob(time.Time{}, "queue=kubernetes"),
+	}
+
+	// Buildkite's count includes jobs beyond the results limit.
+	backlog := backlogOf("kubernetes", agentTags, 10, jobs, polledAt)
+	want := Backlog{
+		Queue:             "kubernetes",
+		ScheduledJobs:     10,
+		FilteredOutJobs:   1,
+		OldestScheduledAt: polledAt.Add(-3 * time.Minute),
+		UpdatedAt:         polledAt,
+	}
+	if backlog != want {
+		t.Errorf("backlogOf() = %+v, want %+v", backlog, want)
+	}
+	if got := backlog.RunnableJobs(); got != 9 {
+		t.Errorf("RunnableJobs() = %d, want 9", got)
+	}
+	if got := backlog.OldestScheduledJobAge(polledAt.Add(time.Minute)); got != 4*time.Minute {
+		t.Errorf("OldestScheduledJobAge() = %v, want 4m", got)
+	}
+
+	m := &Monitor{}
+	m.setBacklog(backlog)
+	if got := m.Backlog(); got != backlog {
+		t.Errorf("Backlog() after setBacklog = %+v, want %+v", got, backlog)
+	}
+	for name, test := range map[string]struct {
+		got, want float64
+	}{
+		"scheduled_jobs":                   {testutil.ToFloat64(scheduledJobsGauge.WithLabelValues("kubernetes")), 10},
+		"scheduled_jobs_filtered_out":      {testutil.ToFloat64(filteredOutJobsGauge.WithLabelValues("kubernetes")), 1},
+		"oldest_scheduled_job_age_seconds": {testutil.ToFloat64(oldestScheduledJobAgeGauge.WithLabelValues("kubernetes")), 180},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s gauge = %v, want %v", name, test.got, test.want)
+		}
+	}
+
+	// With nothing to run, there is no oldest job.
+	empty := backlogOf("kubernetes", agentTags, 0, nil, polledAt)
+	if !empty.OldestScheduledAt.IsZero() || empty.OldestScheduledJobAge(polledAt) != 0 {
+		t.Errorf("backlogOf(no jobs) = %+v, want no oldest scheduled job", empty)
+	}
+}
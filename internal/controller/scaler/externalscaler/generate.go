@@ -0,0 +1,5 @@
+// Package externalscaler contains the messages and service of KEDA's external
+// scaler protocol, generated from externalscaler.proto.
+package externalscaler
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative externalscaler.proto
@@ -0,0 +1,228 @@
+// Package scaler serves the backlog of the controller's queue to KEDA as an
+// external scaler, so that node pools and placeholder capacity can be scaled
+// ahead of demand, instead of waiting for pods to be pending.
+package scaler
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/monitor"
+	pb "github.com/buildkite/agent-stack-k8s/v2/internal/controller/scaler/externalscaler"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// The metrics a ScaledObject or ScaledJob can choose with the "metric" key of
+// its trigger metadata.
+const (
+	// MetricScheduledJobs is the number of scheduled jobs the controller would
+	// run (see monitor.Backlog.RunnableJobs).
+	MetricScheduledJobs = "scheduled-jobs"
+
+	// MetricOldestScheduledJobAge is how long, in seconds, the longest-waiting
+	// job the controller would run has been scheduled for.
+	MetricOldestScheduledJobAge = "oldest-scheduled-job-age"
+)
+
+// defaultTargets are the target values for each metric when the trigger
+// metadata has no "targetValue".
+var defaultTargets = map[string]float64{
+	MetricScheduledJobs:         1,
+	MetricOldestScheduledJobAge: 60,
+}
+
+// BacklogSource provides the backlog of the controller's queue. It is
+// implemented by *monitor.Monitor.
+type BacklogSource interface {
+	Backlog() monitor.Backlog
+}
+
+// Server implements KEDA's externalscaler.ExternalScaler gRPC service (see
+// externalscaler/externalscaler.proto).
+type Server struct {
+	pb.UnimplementedExternalScalerServer
+
+	logger   *zap.Logger
+	source   BacklogSource
+	interval time.Duration
+}
+
+// New creates a Server. StreamIsActive checks the backlog every interval,
+// which should be the monitor's poll interval.
+func New(logger *zap.Logger, source BacklogSource, interval time.Duration) *Server {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &Server{
+		logger:   logger,
+		source:   source,
+		interval: interval,
+	}
+}
+
+// Serve listens on addr and serves the scaler until ctx is done.
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(ctx, lis)
+}
+
+func (s *Server) serve(ctx context.Context, lis net.Listener) error {
+	srv := grpc.NewServer()
+	pb.RegisterExternalScalerServer(srv, s)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() {
+		<-ctx.Done()
+		// Stop, rather than GracefulStop, so that StreamIsActive streams end.
+		srv.Stop()
+	}()
+	s.logger.Info("external scaler listening for requests", zap.String("addr", lis.Addr().String()))
+	return srv.Serve(lis)
+}
+
+// trigger is the configuration of a KEDA trigger, from its metadata.
+type trigger struct {
+	metric string
+	target float64
+}
+
+// parseTrigger reads the trigger metadata:
+//   - metric: one of the Metric constants (default scheduled-jobs),
+//   - targetValue: the value of the metric each replica handles, and
+//   - queue: optionally, the queue the trigger is for, which must be the
+//     controller's queue.
+func (s *Server) parseTrigger(ref *pb.ScaledObjectRef) (trigger, error) {
+	metadata := ref.GetScalerMetadata()
+	t := trigger{metric: MetricScheduledJobs}
+	if metric := metadata["metric"]; metric != "" {
+		t.metric = metric
+	}
+	target, ok := defaultTargets[t.metric]
+	if !ok {
+		return trigger{}, status.Errorf(codes.InvalidArgument, "unknown metric %q", t.metric)
+	}
+	t.target = target
+	if v := metadata["targetValue"]; v != "" {
+		target, err := strconv.ParseFloat(v, 64)
+		if err != nil || target <= 0 {
+			return trigger{}, status.Errorf(codes.InvalidArgument, "invalid targetValue %q: must be a positive number", v)
+		}
+		t.target = target
+	}
+	if queue := metadata["queue"]; queue != "" {
+		if backlogQueue := s.source.Backlog().Queue; backlogQueue != "" && queue != backlogQueue {
+			return trigger{}, status.Errorf(codes.InvalidArgument, "this controller serves queue %q, not %q", backlogQueue, queue)
+		}
+	}
+	return t, nil
+}
+
+// backlog returns the current backlog, or an error if the monitor hasn't
+// queried Buildkite yet.
+func (s *Server) backlog() (monitor.Backlog, error) {
+	backlog := s.source.Backlog()
+	if backlog.UpdatedAt.IsZero() {
+		return monitor.Backlog{}, status.Error(codes.Unavailable, "the controller hasn't queried Buildkite for jobs yet")
+	}
+	return backlog, nil
+}
+
+// IsActive reports whether there are jobs to run.
+func (s *Server) IsActive(_ context.Context, ref *pb.ScaledObjectRef) (*pb.IsActiveResponse, error) {
+	if _, err := s.parseTrigger(ref); err != nil {
+		return nil, err
+	}
+	backlog, err := s.backlog()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.IsActiveResponse{Result: backlog.RunnableJobs() > 0}, nil
+}
+
+// StreamIsActive sends whether there are jobs to run, initially and then
+// whenever it changes.
+func (s *Server) StreamIsActive(ref *pb.ScaledObjectRef, stream grpc.ServerStreamingServer[pb.IsActiveResponse]) error {
+	if _, err := s.parseTrigger(ref); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	sent, wasActive := false, false
+	for {
+		if backlog, err := s.backlog(); err == nil {
+			active := backlog.RunnableJobs() > 0
+			if !sent || active != wasActive {
+				if err := stream.Send(&pb.IsActiveResponse{Result: active}); err != nil {
+					return err
+				}
+				sent, wasActive = true, active
+			}
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetMetricSpec returns the metric chosen by the trigger and its target.
+func (s *Server) GetMetricSpec(_ context.Context, ref *pb.ScaledObjectRef) (*pb.GetMetricSpecResponse, error) {
+	t, err := s.parseTrigger(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetMetricSpecResponse{
+		MetricSpecs: []*pb.MetricSpec{{
+			MetricName:      t.metric,
+			TargetSize:      int64(math.Ceil(t.target)),
+			TargetSizeFloat: t.target,
+		}},
+	}, nil
+}
+
+// GetMetrics returns the current value of the metric chosen by the trigger.
+func (s *Server) GetMetrics(_ context.Context, req *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+	t, err := s.parseTrigger(req.GetScaledObjectRef())
+	if err != nil {
+		return nil, err
+	}
+	backlog, err := s.backlog()
+	if err != nil {
+		return nil, err
+	}
+
+	var value float64
+	switch t.metric {
+	case MetricScheduledJobs:
+		value = float64(backlog.RunnableJobs())
+	case MetricOldestScheduledJobAge:
+		value = backlog.OldestScheduledJobAge(time.Now()).Seconds()
+	}
+	// KEDA asks for the metric by the name returned from GetMetricSpec,
+	// possibly with a prefix of its own, so reply with the name it used.
+	name := req.GetMetricName()
+	if name == "" {
+		name = t.metric
+	}
+	return &pb.GetMetricsResponse{
+		MetricValues: []*pb.MetricValue{{
+			MetricName:       name,
+			MetricValue:      int64(math.Ceil(value)),
+			MetricValueFloat: value,
+		}},
+	}, nil
+}
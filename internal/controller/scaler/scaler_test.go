@@ -0,0 +1,123 @@
+package scaler
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/monitor"
+	pb "github.com/buildkite/agent-stack-k8s/v2/internal/controller/scaler/externalscaler"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zaptest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+type staticBacklog monitor.Backlog
+
+func (b staticBacklog) Backlog() monitor.Backlog { return monitor.Backlog(b) }
+
+func TestServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	now := time.Now()
+	backlog := staticBacklog{
+		Queue:             "kubernetes",
+		ScheduledJobs:     7,
+		FilteredOutJobs:   2,
+		OldestScheduledAt: now.Add(-90 * time.Second),
+		UpdatedAt:         now,
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	go New(zaptest.NewLogger(t), backlog, time.Second).serve(ctx, lis)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("grpc.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := pb.NewExternalScalerClient(conn)
+
+	ref := &pb.ScaledObjectRef{
+		Name:           "placeholders",
+		Namespace:      "buildkite",
+		ScalerMetadata: map[string]string{"queue": "kubernetes", "targetValue": "2"},
+	}
+
+	active, err := client.IsActive(ctx, ref)
+	if err != nil {
+		t.Fatalf("IsActive error = %v", err)
+	}
+	if !active.Result {
+		t.Error("IsActive result = false, want true")
+	}
+
+	spec, err := client.GetMetricSpec(ctx, ref)
+	if err != nil {
+		t.Fatalf("GetMetricSpec error = %v", err)
+	}
+	wantSpec := &pb.GetMetricSpecResponse{
+		MetricSpecs: []*pb.MetricSpec{{MetricName: MetricScheduledJobs, TargetSize: 2, TargetSizeFloat: 2}},
+	}
+	if diff := cmp.Diff(spec, wantSpec, protocmp.Transform()); diff != "" {
+		t.Errorf("GetMetricSpec diff (-got +want):\n%s", diff)
+	}
+
+	req := &pb.GetMetricsRequest{ScaledObjectRef: ref, MetricName: "s0-" + MetricScheduledJobs}
+	metrics, err := client.GetMetrics(ctx, req)
+	if err != nil {
+		t.Fatalf("GetMetrics error = %v", err)
+	}
+	wantMetrics := &pb.GetMetricsResponse{
+		MetricValues: []*pb.MetricValue{{MetricName: "s0-" + MetricScheduledJobs, MetricValue: 5, MetricValueFloat: 5}},
+	}
+	if diff := cmp.Diff(metrics, wantMetrics, protocmp.Transform()); diff != "" {
+		t.Errorf("GetMetrics diff (-got +want):\n%s", diff)
+	}
+
+	ageRef := &pb.ScaledObjectRef{ScalerMetadata: map[string]string{"metric": MetricOldestScheduledJobAge}}
+	metrics, err = client.GetMetrics(ctx, &pb.GetMetricsRequest{ScaledObjectRef: ageRef})
+	if err != nil {
+		t.Fatalf("GetMetrics error = %v", err)
+	}
+	if got := metrics.MetricValues[0].MetricValueFloat; got < 90 || got > 120 {
+		t.Errorf("oldest scheduled job age = %v, want about 90", got)
+	}
+
+	stream, err := client.StreamIsActive(ctx, ref)
+	if err != nil {
+		t.Fatalf("StreamIsActive error = %v", err)
+	}
+	streamed, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("stream.Recv() error = %v", err)
+	}
+	if !streamed.Result {
+		t.Error("StreamIsActive result = false, want true")
+	}
+
+	wrongQueue := &pb.ScaledObjectRef{ScalerMetadata: map[string]string{"queue": "other"}}
+	_, err = client.IsActive(ctx, wrongQueue)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("IsActive(queue: other) error = %v, want InvalidArgument", err)
+	}
+
+	// Other services on the server use the default codec too.
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("health check error = %v", err)
+	}
+	if health.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health check status = %v, want SERVING", health.GetStatus())
+	}
+}
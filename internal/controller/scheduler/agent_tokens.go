@@ -0,0 +1,144 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// errAgentTokenNotCached is returned by AgentTokenCache.secret for secrets it
+// isn't watching.
+var errAgentTokenNotCached = errors.New("agent token secret is not cached")
+
+// AgentTokenCache watches the agent token secrets named in the config, so that
+// failing a job doesn't need to fetch the token from the API server, and so
+// that a missing secret or token is noticed (and reported through metrics and
+// the readiness check) before a job needs failing.
+type AgentTokenCache struct {
+	logger    *zap.Logger
+	k8s       kubernetes.Interface
+	namespace string
+
+	// Secret names, and the listers watching each of them.
+	names   []string
+	listers map[string]corelisters.SecretNamespaceLister
+}
+
+// NewAgentTokenCache creates an AgentTokenCache for the agent token secrets in
+// the config.
+func NewAgentTokenCache(logger *zap.Logger, k8s kubernetes.Interface, cfg *config.Config) *AgentTokenCache {
+	names := []string{cfg.AgentTokenSecret}
+	for _, m := range cfg.AgentTokenSecrets {
+		names = append(names, m.Secret)
+	}
+	slices.Sort(names)
+	return &AgentTokenCache{
+		logger:    logger,
+		k8s:       k8s,
+		namespace: cfg.Namespace,
+		names:     slices.Compact(names),
+		listers:   make(map[string]corelisters.SecretNamespaceLister),
+	}
+}
+
+// Start starts watching each secret, and waits for the caches to sync.
+// Each secret is watched separately (by name), rather than caching every
+// secret in the namespace.
+func (c *AgentTokenCache) Start(ctx context.Context) error {
+	// Set up all the listers before starting any informers, since the event
+	// handlers read c.listers.
+	factories := make(map[string]informers.SharedInformerFactory, len(c.names))
+	for _, name := range c.names {
+		factory := informers.NewSharedInformerFactoryWithOptions(
+			c.k8s,
+			0,
+			informers.WithNamespace(c.namespace),
+			informers.WithTweakListOptions(func(opt *metav1.ListOptions) {
+				opt.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+			}),
+		)
+		informer := factory.Core().V1().Secrets()
+		update := func(any) { c.check(name) }
+		if _, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    update,
+			UpdateFunc: func(_, obj any) { update(obj) },
+			DeleteFunc: update,
+		}); err != nil {
+			return fmt.Errorf("adding event handler for secret %q: %w", name, err)
+		}
+		c.listers[name] = informer.Lister().Secrets(c.namespace)
+		factories[name] = factory
+	}
+
+	for name, factory := range factories {
+		// Start is non-blocking, and must return before WaitForCacheSync,
+		// which only waits for started informers.
+		factory.Start(ctx.Done())
+		for _, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return fmt.Errorf("failed to sync informer cache for secret %q", name)
+			}
+		}
+		// No event handler runs if the secret doesn't exist.
+		c.check(name)
+	}
+	return nil
+}
+
+// secret returns the named secret from the cache.
+func (c *AgentTokenCache) secret(name string) (*corev1.Secret, error) {
+	if c == nil {
+		return nil, errAgentTokenNotCached
+	}
+	lister := c.listers[name]
+	if lister == nil {
+		return nil, errAgentTokenNotCached
+	}
+	return lister.Get(name)
+}
+
+// check updates the missing-token metric for the named secret, and logs a
+// problem with it.
+func (c *AgentTokenCache) check(name string) {
+	if err := c.checkSecret(name); err != nil {
+		c.logger.Error("agent token secret is unusable", zap.String("secret", name), zap.Error(err))
+		agentTokenMissingGauge.WithLabelValues(name).Set(1)
+		return
+	}
+	agentTokenMissingGauge.WithLabelValues(name).Set(0)
+}
+
+func (c *AgentTokenCache) checkSecret(name string) error {
+	secret, err := c.secret(name)
+	if err != nil {
+		return err
+	}
+	if len(secret.Data[agentTokenKey]) == 0 {
+		return fmt.Errorf("secret %q has no %s", name, agentTokenKey)
+	}
+	return nil
+}
+
+// Ready returns an error if any agent token secret is missing, or doesn't
+// contain a token. It is used as a readiness check.
+func (c *AgentTokenCache) Ready() error {
+	var errs []error
+	for _, name := range c.names {
+		if err := c.checkSecret(name); err != nil {
+			errs = append(errs, fmt.Errorf("agent token secret %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,49 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAgentTokenCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	k8s := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent-token", Namespace: "buildkite"},
+		Data:       map[string][]byte{agentTokenKey: []byte("hunter2")},
+	})
+	tokens := NewAgentTokenCache(zaptest.NewLogger(t), k8s, &config.Config{
+		Namespace:        "buildkite",
+		AgentTokenSecret: "agent-token",
+		AgentTokenSecrets: []config.AgentTokenSecretMapping{
+			{Tags: map[string]string{"queue": "deploy"}, Secret: "deploy-token"},
+		},
+	})
+	if err := tokens.Start(ctx); err != nil {
+		t.Fatalf("tokens.Start(ctx) = %v", err)
+	}
+
+	got, err := fetchAgentToken(ctx, zaptest.NewLogger(t), k8s, tokens, "buildkite", "agent-token")
+	if err != nil {
+		t.Fatalf("fetchAgentToken(agent-token) error = %v", err)
+	}
+	if got != "hunter2" {
+		t.Errorf("fetchAgentToken(agent-token) = %q, want %q", got, "hunter2")
+	}
+
+	// deploy-token doesn't exist.
+	if err := tokens.Ready(); err == nil {
+		t.Error("tokens.Ready() = nil, want error for missing deploy-token")
+	}
+	if _, err := fetchAgentToken(ctx, zaptest.NewLogger(t), k8s, tokens, "buildkite", "deploy-token"); err == nil {
+		t.Error("fetchAgentToken(deploy-token) error = nil, want error")
+	}
+}
@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+
+	"github.com/Khan/genqlient/graphql"
+)
+
+// annotateBuild adds (or replaces) an annotation on the build containing the
+// Buildkite job. This is useful for surfacing information after the job has
+// finished, when it is too late to write to the job log.
+func annotateBuild(
+	ctx context.Context,
+	gql graphql.Client,
+	jobUUID string,
+	annotationContext string,
+	style api.AnnotationStyle,
+	body string,
+) error {
+	resp, err := api.GetCommandJobBuild(ctx, gql, jobUUID)
+	if err != nil {
+		return fmt.Errorf("querying build for job: %w", err)
+	}
+	job, ok := resp.Job.(*api.GetCommandJobBuildJobJobTypeCommand)
+	if !ok {
+		return errors.New("job was not a command job")
+	}
+
+	_, err = api.BuildAnnotate(ctx, gql, api.BuildAnnotateInput{
+		BuildID: job.Build.Id,
+		Body:    body,
+		Context: annotationContext,
+		Style:   style,
+	})
+	if err != nil {
+		return fmt.Errorf("annotating build: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package scheduler
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// failureClass is a known cause of a job failing before the agent could run
+// it, recognised from the messages and events that explain the failure.
+type failureClass struct {
+	// name is used as a metric label.
+	name string
+
+	// pattern matches the failure explanation.
+	pattern *regexp.Regexp
+
+	// cause and hint are shown above the explanation in the job log.
+	cause string
+	hint  string
+}
+
+// unclassifiedFailure is used when no failure class matches.
+var unclassifiedFailure = &failureClass{name: "unclassified"}
+
+// failureClasses are tried in order, so more specific patterns come first
+// (e.g. a missing service account before a missing image, since both are
+// "not found").
+var failureClasses = []*failureClass{
+	{
+		name:    "service_account_missing",
+		pattern: regexp.MustCompile(`(?i)serviceaccount "[^"]*" not found|error looking up service account`),
+		cause:   "The pod's service account doesn't exist.",
+		hint:    "Create the service account in the controller's namespace, or fix serviceAccountName in the podSpec.",
+	},
+	{
+		name:    "pod_security_rejected",
+		pattern: regexp.MustCompile(`(?i)violates PodSecurity`),
+		cause:   "The pod was rejected by PodSecurity admission.",
+		hint:    "Change the podSpec to meet the namespace's Pod Security Standard (e.g. run as non-root, drop capabilities, disallow privilege escalation), or relax the namespace's pod-security.kubernetes.io/enforce label.",
+	},
+	{
+		name:    "resource_quota_exceeded",
+		pattern: regexp.MustCompile(`(?i)exceeded quota`),
+		cause:   "Creating the pod would exceed a ResourceQuota in the namespace.",
+		hint:    "Lower the job's resource requests, lower max-in-flight, or raise the namespace's ResourceQuota.",
+	},
+	{
+		name:    "pvc_not_bound",
+		pattern: regexp.MustCompile(`(?i)unbound immediate PersistentVolumeClaims|persistentvolumeclaim "[^"]*" (not found|is being deleted)|PersistentVolumeClaim is not bound`),
+		cause:   "A PersistentVolumeClaim used by the pod isn't bound to a volume.",
+		hint:    "Check that the PersistentVolumeClaim exists, and that its storage class can provision a volume (kubectl describe pvc).",
+	},
+	{
+		name:    "volume_source_missing",
+		pattern: regexp.MustCompile(`(?i)(configmap|secret) "[^"]*" not found|couldn't find key \S+ in (ConfigMap|Secret)`),
+		cause:   "A ConfigMap or Secret used by the pod (as a volume or environment variables) doesn't exist, or lacks a key.",
+		hint:    "Create the ConfigMap or Secret in the controller's namespace, or fix its name or key in the pipeline or controller config.",
+	},
+	{
+		name:    "exec_format_error",
+		pattern: regexp.MustCompile(`(?i)exec format error`),
+		cause:   "A container's executable was built for a different CPU architecture than the node.",
+		hint:    "Use a multi-architecture image, or one built for the node's architecture, or add a nodeSelector for kubernetes.io/arch.",
+	},
+	{
+		// Docker Hub doesn't say which, so that private repositories can't be
+		// discovered.
+		name:    "image_not_found_or_unauthorized",
+		pattern: regexp.MustCompile(`(?i)repository does not exist or may require authorization`),
+		cause:   "An image doesn't exist, or the registry requires credentials to pull it (the registry doesn't say which).",
+		hint:    "Check the image name and tag for typos, and that the image has been pushed. If the repository is private, add imagePullSecrets with credentials for the registry to the podSpec (or the service account).",
+	},
+	{
+		name:    "image_not_found",
+		pattern: regexp.MustCompile(`(?i)manifest unknown|manifest for \S+ not found|repository does not exist|failed to resolve reference "[^"]*": \S+: not found|InvalidImageName`),
+		cause:   "An image doesn't exist, or its name is invalid.",
+		hint:    "Check the image name and tag for typos, and that the image has been pushed.",
+	},
+	{
+		name:    "image_pull_unauthorized",
+		pattern: regexp.MustCompile(`(?i)unauthorized|authentication required|no basic auth credentials|pull access denied|403 Forbidden|denied: `),
+		cause:   "The registry refused to let the node pull an image.",
+		hint:    "Add imagePullSecrets with credentials for the registry to the podSpec (or the service account), and check that they haven't expired.",
+	},
+}
+
+// classifyFailure returns the first failure class whose pattern matches the
+// explanation, or unclassifiedFailure.
+func classifyFailure(explanation string) *failureClass {
+	for _, class := range failureClasses {
+		if class.pattern.MatchString(explanation) {
+			return class
+		}
+	}
+	return unclassifiedFailure
+}
+
+// explain prefixes the explanation with the cause and hint, if known.
+func (c *failureClass) explain(explanation string) string {
+	if c.cause == "" {
+		return explanation
+	}
+	return fmt.Sprintf("%s\nHint: %s\n\n%s", c.cause, c.hint, explanation)
+}
@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		explanation string
+		want        string
+	}{
+		{
+			explanation: `Error creating: pods "buildkite-abc-" is forbidden: error looking up service account buildkite/deployer: serviceaccount "deployer" not found`,
+			want:        "service_account_missing",
+		},
+		{
+			explanation: `Error creating: pods "buildkite-abc-xyz" is forbidden: violates PodSecurity "restricted:latest": allowPrivilegeEscalation != false`,
+			want:        "pod_security_rejected",
+		},
+		{
+			explanation: `Error creating: pods "buildkite-abc-xyz" is forbidden: exceeded quota: compute, requested: cpu=2, used: cpu=15, limited: cpu=16`,
+			want:        "resource_quota_exceeded",
+		},
+		{
+			explanation: `0/3 nodes are available: pod has unbound immediate PersistentVolumeClaims. preemption: 0/3 nodes are available`,
+			want:        "pvc_not_bound",
+		},
+		{
+			explanation: `MountVolume.SetUp failed for volume "config" : configmap "build-config" not found`,
+			want:        "volume_source_missing",
+		},
+		{
+			explanation: `Error: couldn't find key token in Secret buildkite/npm`,
+			want:        "volume_source_missing",
+		},
+		{
+			explanation: `failed to create containerd task: failed to start shim: exec: "/bin/sh": exec format error: unknown`,
+			want:        "exec_format_error",
+		},
+		{
+			explanation: `Failed to pull image "ruby:nope": failed to pull and unpack image "docker.io/library/ruby:nope": failed to resolve reference "docker.io/library/ruby:nope": docker.io/library/ruby:nope: not found`,
+			want:        "image_not_found",
+		},
+		{
+			explanation: `Failed to pull image "acme/private:latest": pull access denied, repository does not exist or may require authorization`,
+			want:        "image_not_found_or_unauthorized",
+		},
+		{
+			explanation: `Failed to pull image "123.dkr.ecr.us-east-1.amazonaws.com/app:1": failed to authorize: failed to fetch anonymous token: unexpected status from GET request: 401 Unauthorized`,
+			want:        "image_pull_unauthorized",
+		},
+		{
+			explanation: `The following init containers failed: checkout exited with status 128`,
+			want:        "unclassified",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.want, func(t *testing.T) {
+			if got := classifyFailure(test.explanation).name; got != test.want {
+				t.Errorf("classifyFailure(%q).name = %q, want %q", test.explanation, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFailureClassExplain(t *testing.T) {
+	explanation := "raw events table"
+	if got := unclassifiedFailure.explain(explanation); got != explanation {
+		t.Errorf("unclassifiedFailure.explain(%q) = %q, want it unchanged", explanation, got)
+	}
+	got := classifyFailure(`violates PodSecurity "baseline:latest"`).explain(explanation)
+	if !strings.HasPrefix(got, "The pod was rejected by PodSecurity admission.\nHint: ") || !strings.HasSuffix(got, "\n\n"+explanation) {
+		t.Errorf("explain(%q) = %q, want the cause and hint followed by the explanation", explanation, got)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/utils/ptr"
 )
@@ -20,8 +21,10 @@ import (
 const defaultTermGracePeriodSeconds = 60
 
 type completionsWatcher struct {
-	logger *zap.Logger
-	k8s    kubernetes.Interface
+	logger   *zap.Logger
+	k8s      kubernetes.Interface
+	recorder record.EventRecorder
+	cfg      *config.Config
 
 	// This is the context passed to RegisterInformer.
 	// It's being stored here (grrrr!) because the k8s ResourceEventHandler
@@ -31,10 +34,12 @@ type completionsWatcher struct {
 	resourceEventHandlerCtx context.Context
 }
 
-func NewPodCompletionWatcher(logger *zap.Logger, k8s kubernetes.Interface) *completionsWatcher {
+func NewPodCompletionWatcher(logger *zap.Logger, k8s kubernetes.Interface, recorder record.EventRecorder, cfg *config.Config) *completionsWatcher {
 	watcher := &completionsWatcher{
-		logger: logger,
-		k8s:    k8s,
+		logger:   logger,
+		k8s:      k8s,
+		recorder: recorder,
+		cfg:      cfg,
 	}
 	return watcher
 }
@@ -70,6 +75,12 @@ func (w *completionsWatcher) OnUpdate(old any, new any) {
 	}
 
 	newPod := new.(*v1.Pod)
+	if getTermination(newPod) == nil {
+		// The job is still running, so a hook can still print the logs of
+		// sidecars that have failed.
+		w.captureSidecarLogs(w.resourceEventHandlerCtx, oldPod, newPod)
+		return
+	}
 	w.cleanupSidecars(w.resourceEventHandlerCtx, newPod)
 }
 
@@ -100,9 +111,12 @@ func (w *completionsWatcher) cleanupSidecars(ctx context.Context, pod *v1.Pod) {
 	}); err != nil {
 		completionWatcherJobCleanupErrorsCounter.WithLabelValues(string(kerrors.ReasonForError(err))).Inc()
 		w.logger.Error("failed to update job with ActiveDeadlineSeconds", zap.Error(err))
+		w.recorder.Eventf(pod, v1.EventTypeWarning, EventReasonSidecarsCleanupError, "Couldn't set an active deadline on the job to stop sidecars: %v", err)
 		return
 	}
 	completionWatcherJobCleanupsCounter.Inc()
+	w.recorder.Eventf(pod, v1.EventTypeNormal, EventReasonSidecarsCleanedUp,
+		"The agent exited with status %d, so the job will be ended within %ds to stop any sidecars", terminated.ExitCode, defaultTermGracePeriodSeconds)
 }
 
 func getTermination(pod *v1.Pod) *v1.ContainerStateTerminated {
@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Pod status reasons set when a pod is terminated because of something that
+// happened to its node, rather than something the job did.
+var disruptedPodReasons = map[string]bool{
+	"Evicted":      true,
+	"Preempting":   true,
+	"NodeLost":     true,
+	"NodeShutdown": true,
+	"Terminated":   true, // graceful node shutdown
+}
+
+// podDisruption reports the reason and message if the pod has been (or is
+// about to be) terminated due to infrastructure: preemption by the scheduler,
+// eviction, node deletion, node shutdown, and so on.
+func podDisruption(pod *corev1.Pod) (reason, message string, disrupted bool) {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.DisruptionTarget && cond.Status == corev1.ConditionTrue {
+			return cond.Reason, cond.Message, true
+		}
+	}
+	if pod.Status.Phase == corev1.PodFailed && disruptedPodReasons[pod.Status.Reason] {
+		return pod.Status.Reason, pod.Status.Message, true
+	}
+	return "", "", false
+}
+
+// failOnDisruption checks if the pod has been disrupted, e.g. because a spot
+// node was reclaimed. If the Buildkite job hasn't been acquired by the agent in
+// the pod, it is failed with the configured preemption exit status so that
+// pipelines can retry infrastructure failures specifically. Otherwise the
+// agent will finish the job, so the disruption is explained with an annotation
+// on the build instead. It reports whether the pod was disrupted.
+func (w *podWatcher) failOnDisruption(ctx context.Context, log *zap.Logger, jobUUID uuid.UUID, pod *corev1.Pod) bool {
+	reason, detail, disrupted := podDisruption(pod)
+	if !disrupted {
+		return false
+	}
+
+	// Only handle each disruption once. Evictions initiated by podWatcher
+	// itself also cause disruption, but those jobs are ignored already.
+	// If the job can't be failed, it's unignored so that the next pod update
+	// tries again, rather than leaving the job scheduled with a dead pod.
+	w.ignoreJob(jobUUID)
+	w.stopWatchingForImageFailure(jobUUID)
+	w.stopWatchingForUnschedulable(jobUUID)
+	w.stopJobCancelChecker(jobUUID)
+	podsDisruptedCounter.WithLabelValues(reason).Inc()
+
+	node := pod.Spec.NodeName
+	if node == "" {
+		node = "(not yet scheduled)"
+	}
+	log = log.With(zap.String("node", node), zap.String("disruption_reason", reason))
+	message := fmt.Sprintf("The pod running this job was disrupted by Kubernetes.\nNode: %s\nReason: %s\n%s", node, reason, detail)
+
+	resp, err := api.GetCommandJob(ctx, w.gql, jobUUID.String())
+	if err != nil {
+		log.Warn("Failed to query command job", zap.Error(err))
+		w.unignoreJob(jobUUID)
+		return true
+	}
+	job, ok := resp.Job.(*api.GetCommandJobJobJobTypeCommand)
+	if !ok {
+		log.Warn("Job was not a command job")
+		w.unignoreJob(jobUUID)
+		return true
+	}
+	log = log.With(zap.String("job_state", string(job.State)))
+
+	switch job.State {
+	case api.JobStatesScheduled:
+		log.Info("Pod was disrupted before the agent acquired the job. Failing.")
+		if err := acquireAndFailForObject(ctx, log, w.k8s, w.tokens, w.recorder, w.cfg, pod, message, w.preemptionExitStatus); err != nil {
+			log.Error("Could not fail Buildkite job", zap.Error(err))
+			podWatcherBuildkiteJobFailErrorsCounter.Inc()
+			w.unignoreJob(jobUUID)
+			return true
+		}
+		podWatcherBuildkiteJobFailsCounter.Inc()
+
+	case api.JobStatesAccepted, api.JobStatesAssigned, api.JobStatesRunning:
+		// The agent in the pod owns the job, so it will report the exit
+		// status. The best we can do is explain what happened.
+		log.Info("Pod running the job was disrupted")
+		body := fmt.Sprintf("Job %s was interrupted because its pod was disrupted by Kubernetes.\n\n```\n%s\n```",
+			jobUUID, message)
+		if err := annotateBuild(ctx, w.gql, jobUUID.String(), "pod-disruption-"+jobUUID.String(), api.AnnotationStyleWarning, body); err != nil {
+			log.Warn("Couldn't annotate build with disruption", zap.Error(err))
+		}
+
+	default:
+		log.Debug("Job not in a state affected by the disruption")
+	}
+	return true
+}
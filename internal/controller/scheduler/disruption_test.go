@@ -0,0 +1,237 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/Khan/genqlient/graphql"
+	"github.com/google/uuid"
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPodDisruption(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        corev1.PodStatus
+		wantReason    string
+		wantDisrupted bool
+	}{
+		{
+			name: "DisruptionTarget condition",
+			status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.DisruptionTarget,
+					Status:  corev1.ConditionTrue,
+					Reason:  "PreemptionByScheduler",
+					Message: "preempted by a higher-priority pod",
+				}},
+			},
+			wantReason:    "PreemptionByScheduler",
+			wantDisrupted: true,
+		},
+		{
+			name: "DisruptionTarget condition not true",
+			status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				Conditions: []corev1.PodCondition{{
+					Type:   corev1.DisruptionTarget,
+					Status: corev1.ConditionFalse,
+				}},
+			},
+		},
+		{
+			name:          "evicted",
+			status:        corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted", Message: "low on memory"},
+			wantReason:    "Evicted",
+			wantDisrupted: true,
+		},
+		{
+			name:          "node lost",
+			status:        corev1.PodStatus{Phase: corev1.PodFailed, Reason: "NodeLost"},
+			wantReason:    "NodeLost",
+			wantDisrupted: true,
+		},
+		{
+			name:   "failed for another reason",
+			status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "DeadlineExceeded"},
+		},
+		{
+			name:   "running",
+			status: corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason, _, disrupted := podDisruption(&corev1.Pod{Status: test.status})
+			if reason != test.wantReason || disrupted != test.wantDisrupted {
+				t.Errorf("podDisruption() = (%q, _, %t), want (%q, _, %t)", reason, disrupted, test.wantReason, test.wantDisrupted)
+			}
+		})
+	}
+}
+
+func TestFailOnDisruption(t *testing.T) {
+	const preemptionExitStatus = 75
+
+	tests := []struct {
+		state          api.JobStates
+		wantFailed     bool
+		wantAnnotation bool
+	}{
+		{state: api.JobStatesScheduled, wantFailed: true},
+		{state: api.JobStatesRunning, wantAnnotation: true},
+		{state: api.JobStatesFinished},
+	}
+	for _, test := range tests {
+		t.Run(string(test.state), func(t *testing.T) {
+			fb := newFakeBuildkite(t, test.state)
+			cfg := &config.Config{Namespace: "buildkite", PreemptionExitStatus: preemptionExitStatus}
+			secret := fb.configure(cfg)
+			w := NewPodWatcher(zaptest.NewLogger(t), fake.NewClientset(secret), fb.gql(), nil, record.NewFakeRecorder(10), cfg)
+
+			jobUUID := uuid.New()
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "buildkite-" + jobUUID.String(),
+					Namespace: "buildkite",
+					Labels: map[string]string{
+						config.UUIDLabel:          jobUUID.String(),
+						"tag.buildkite.com/queue": "kubernetes",
+					},
+				},
+				Spec: corev1.PodSpec{NodeName: "spot-node"},
+				Status: corev1.PodStatus{
+					Phase:   corev1.PodFailed,
+					Reason:  "NodeShutdown",
+					Message: "the node was shut down",
+				},
+			}
+
+			if !w.failOnDisruption(context.Background(), zaptest.NewLogger(t), jobUUID, pod) {
+				t.Fatal("failOnDisruption() = false, want true")
+			}
+			if !w.isIgnored(jobUUID) {
+				t.Error("job not ignored after disruption")
+			}
+
+			status, failed := fb.failedWith(jobUUID.String())
+			if failed != test.wantFailed {
+				t.Fatalf("job failed = %t, want %t", failed, test.wantFailed)
+			}
+			if failed {
+				if want := strconv.Itoa(preemptionExitStatus); status != want {
+					t.Errorf("job failed with exit status %s, want %s", status, want)
+				}
+				if log := fb.log(jobUUID.String()); !strings.Contains(log, "spot-node") {
+					t.Errorf("job log = %q, want it to name the node", log)
+				}
+			}
+
+			annotation := fb.annotated()
+			if got := annotation != ""; got != test.wantAnnotation {
+				t.Fatalf("build annotated = %t, want %t", got, test.wantAnnotation)
+			}
+			if test.wantAnnotation && !strings.Contains(annotation, "NodeShutdown") {
+				t.Errorf("annotation = %q, want it to contain the reason", annotation)
+			}
+		})
+	}
+}
+
+func TestEvictPod_IgnoresJobFirst(t *testing.T) {
+	// Evicting the pod makes it a DisruptionTarget, and the pod update can be
+	// handled before Evict returns. By then the job must already be ignored,
+	// so it isn't handled as a disruption too.
+	for _, evictErr := range []error{nil, errors.New("too many requests")} {
+		k8s := fake.NewClientset()
+		w := NewPodWatcher(zaptest.NewLogger(t), k8s, nil, nil, record.NewFakeRecorder(10), &config.Config{Namespace: "buildkite"})
+		jobUUID := uuid.New()
+
+		ignoredDuringEvict := false
+		k8s.PrependReactor("create", "pods/eviction", func(k8stesting.Action) (bool, runtime.Object, error) {
+			ignoredDuringEvict = w.isIgnored(jobUUID)
+			return true, nil, evictErr
+		})
+
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "buildkite-" + jobUUID.String(), Namespace: "buildkite"}}
+		evicted := w.evictPod(context.Background(), zaptest.NewLogger(t), pod, jobUUID, "unschedulable")
+		if want := evictErr == nil; evicted != want {
+			t.Errorf("evictPod() with Evict error %v = %t, want %t", evictErr, evicted, want)
+		}
+		if !ignoredDuringEvict {
+			t.Errorf("job wasn't ignored while evicting (Evict error %v)", evictErr)
+		}
+		if got, want := w.isIgnored(jobUUID), evictErr == nil; got != want {
+			t.Errorf("isIgnored() after evictPod with Evict error %v = %t, want %t", evictErr, got, want)
+		}
+	}
+}
+
+func TestFailOnDisruption_UnignoresOnError(t *testing.T) {
+	// If the job can't be failed, it mustn't stay ignored, or it would be
+	// left scheduled with a dead pod instead of being retried on the next pod
+	// update.
+	tests := []struct {
+		name string
+		gql  func(*fakeBuildkite) graphql.Client
+		// withSecret adds the agent token secret needed to fail the job.
+		withSecret bool
+	}{
+		{
+			name: "query fails",
+			gql: func(fb *fakeBuildkite) graphql.Client {
+				return api.NewClient("token", fb.srv.URL+"/missing")
+			},
+			withSecret: true,
+		},
+		{
+			name: "failing the job fails",
+			gql:  (*fakeBuildkite).gql,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fb := newFakeBuildkite(t, api.JobStatesScheduled)
+			cfg := &config.Config{Namespace: "buildkite"}
+			secret := fb.configure(cfg)
+			k8s := fake.NewClientset()
+			if test.withSecret {
+				k8s = fake.NewClientset(secret)
+			}
+			w := NewPodWatcher(zaptest.NewLogger(t), k8s, test.gql(fb), nil, record.NewFakeRecorder(10), cfg)
+
+			jobUUID := uuid.New()
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "buildkite-" + jobUUID.String(),
+					Namespace: "buildkite",
+					Labels:    map[string]string{config.UUIDLabel: jobUUID.String()},
+				},
+				Status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted"},
+			}
+
+			if !w.failOnDisruption(context.Background(), zaptest.NewLogger(t), jobUUID, pod) {
+				t.Fatal("failOnDisruption() = false, want true")
+			}
+			if w.isIgnored(jobUUID) {
+				t.Error("job still ignored after failing to fail it")
+			}
+			if _, failed := fb.failedWith(jobUUID.String()); failed {
+				t.Error("job was failed, want it left for a retry")
+			}
+		})
+	}
+}
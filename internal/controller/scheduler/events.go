@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons for the events the controller records on Kubernetes jobs and pods,
+// so that `kubectl describe` shows what the controller decided and why.
+const (
+	EventReasonJobCreated           = "BuildkiteJobCreated"
+	EventReasonJobFailed            = "BuildkiteJobFailed"
+	EventReasonJobFailError         = "BuildkiteJobFailError"
+	EventReasonJobCancelled         = "BuildkiteJobCancelled"
+	EventReasonJobCancelError       = "BuildkiteJobCancelError"
+	EventReasonJobIgnored           = "BuildkiteJobIgnored"
+	EventReasonPodEvicted           = "PodEvicted"
+	EventReasonPodEvictError        = "PodEvictError"
+	EventReasonStalledWithoutPod    = "StalledWithoutPod"
+	EventReasonFinishedWithoutPod   = "FinishedWithoutPod"
+	EventReasonSidecarsCleanedUp    = "SidecarsCleanedUp"
+	EventReasonSidecarsCleanupError = "SidecarsCleanupError"
+)
+
+// eventSource is the component shown as the source of recorded events.
+const eventSource = "agent-stack-k8s"
+
+// NewEventRecorder returns a recorder for events on Kubernetes objects. Events
+// are sent to Kubernetes in the background until ctx is done.
+func NewEventRecorder(ctx context.Context, k8s kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k8s.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventSource})
+}
+
+// fetchEvents lists the events for an object, which might contain useful info
+// for diagnosing a problem, and formats them as a table. If reason is not
+// empty, only events with that reason are listed.
+func fetchEvents(ctx context.Context, log *zap.Logger, k8s kubernetes.Interface, namespace, kind, name, reason string) string {
+	evlist, err := ListEvents(ctx, k8s, namespace, kind, name, reason)
+	if err != nil {
+		log.Error("Couldn't get events", zap.String("kind", kind), zap.Error(err))
+		return fmt.Sprintf("Couldn't get events for %s %s: %v", kind, name, err)
+	}
+	if evlist == nil {
+		return ""
+	}
+	return formatEvents(evlist)
+}
+
+// ListEvents lists the events for an object. If reason is not empty, only
+// events with that reason are listed.
+func ListEvents(ctx context.Context, k8s kubernetes.Interface, namespace, kind, name, reason string) (*corev1.EventList, error) {
+	selectors := []fields.Selector{
+		fields.OneTermEqualSelector("involvedObject.kind", kind),
+		fields.OneTermEqualSelector("involvedObject.name", name),
+	}
+	if reason != "" {
+		selectors = append(selectors, fields.OneTermEqualSelector("reason", reason))
+	}
+	return k8s.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.AndSelectors(selectors...).String(),
+	})
+}
+
+func formatEvents(evlist *corev1.EventList) string {
+	if len(evlist.Items) == 0 {
+		return "Events: none"
+	}
+
+	tw := table.NewWriter()
+	tw.SetStyle(table.StyleColoredDark)
+	tw.AppendHeader(table.Row{"LAST EVENT", "REPEATED", "TYPE", "REASON", "MESSAGE"})
+	tw.AppendSeparator()
+	for _, event := range evlist.Items {
+		if event.Series == nil {
+			tw.AppendRow(table.Row{event.EventTime.Time, "-", event.Type, event.Reason, event.Message})
+			continue
+		}
+		lastTime := event.Series.LastObservedTime.Time
+		firstToLast := duration.HumanDuration(lastTime.Sub(event.EventTime.Time))
+		countMsg := fmt.Sprintf("x%d over %s", event.Series.Count, firstToLast)
+		tw.AppendRow(table.Row{lastTime, countMsg, event.Type, event.Reason, event.Message})
+	}
+	return tw.Render()
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"strings"
 
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/agenttags"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
@@ -14,25 +16,40 @@ import (
 	"github.com/buildkite/agent/v3/logger"
 
 	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
+// eventObject is a Kubernetes object that events can be recorded on.
+type eventObject interface {
+	metav1.Object
+	runtime.Object
+}
+
 // acquireAndFailForObject figures out how to fail the BK job corresponding to
-// the k8s object (a pod or job) by inspecting the object's labels.
+// the k8s object (a pod or job) by inspecting the object's labels. The outcome
+// is recorded as an event on the object.
 func acquireAndFailForObject(
 	ctx context.Context,
 	logger *zap.Logger,
 	k8sClient kubernetes.Interface,
+	tokens *AgentTokenCache,
+	recorder record.EventRecorder,
 	cfg *config.Config,
-	obj metav1.Object,
+	obj eventObject,
 	message string,
-) error {
-	agentToken, err := fetchAgentToken(ctx, logger, k8sClient, obj.GetNamespace(), cfg.AgentTokenSecret)
-	if err != nil {
-		logger.Error("fetching agent token from secret", zap.Error(err))
-		return err
-	}
+	exitStatus int,
+) (err error) {
+	defer func() {
+		if err != nil {
+			recorder.Eventf(obj, corev1.EventTypeWarning, EventReasonJobFailError, "Couldn't fail the Buildkite job: %v", err)
+			return
+		}
+		recorder.Eventf(obj, corev1.EventTypeWarning, EventReasonJobFailed, "Failed the Buildkite job with exit status %d: %s", exitStatus, failureSummary(message))
+	}()
 
 	// Matching tags are required order to connect the temporary agent.
 	labels := obj.GetLabels()
@@ -42,15 +59,33 @@ func acquireAndFailForObject(
 		return errors.New("missing UUID label")
 	}
 	tags := agenttags.TagsFromLabels(labels)
+
+	// Use the same agent token secret as the pod.
+	secretName := config.AgentTokenSecretFor(cfg.AgentTokenSecrets, cfg.AgentTokenSecret, maps.Collect(agenttags.ScanLabels(labels)))
+	agentToken, err := fetchAgentToken(ctx, logger, k8sClient, tokens, obj.GetNamespace(), secretName)
+	if err != nil {
+		logger.Error("fetching agent token from secret", zap.Error(err))
+		return err
+	}
 	opts := cfg.AgentConfig.ControllerOptions()
 
-	if err := acquireAndFail(ctx, logger, agentToken, jobUUID, tags, message, opts...); err != nil {
+	if err := acquireAndFail(ctx, logger, agentToken, jobUUID, tags, message, exitStatus, opts...); err != nil {
 		logger.Error("failed to acquire and fail the job on Buildkite", zap.Error(err))
 		return err
 	}
 	return nil
 }
 
+// failureSummary returns a one-line summary of a failure message for an event:
+// the cause of a known failure, or else the first line of the message.
+func failureSummary(message string) string {
+	if cause := classifyFailure(message).cause; cause != "" {
+		return cause
+	}
+	line, _, _ := strings.Cut(message, "\n")
+	return strings.TrimSpace(line)
+}
+
 // acquireAndFail fails the job in Buildkite. agentToken needs to be the token value.
 // Use fetchAgentToken to fetch it from the k8s secret.
 func acquireAndFail(
@@ -60,8 +95,15 @@ func acquireAndFail(
 	jobUUID string,
 	tags []string,
 	message string,
+	exitStatus int,
 	options ...agentcore.ControllerOption,
 ) error {
+	// Lead with the likely cause of known failures, since the explanation is
+	// often a table of raw events or container statuses.
+	class := classifyFailure(message)
+	message = class.explain(message)
+	zapLogger = zapLogger.With(zap.String("failure_class", class.name))
+
 	opts := append([]agentcore.ControllerOption{
 		agentcore.WithUserAgent("agent-stack-k8s/" + version.Version()),
 		agentcore.WithLogger(logger.NewConsoleLogger(logger.NewTextPrinter(os.Stderr), func(int) {})),
@@ -92,18 +134,23 @@ func acquireAndFail(
 		return fmt.Errorf("writing log: %w", err)
 	}
 
-	if err := jctr.Finish(ctx, agentcore.ProcessExit{Status: 1}); err != nil {
+	if err := jctr.Finish(ctx, agentcore.ProcessExit{Status: exitStatus}); err != nil {
 		zapLogger.Error("finishing job", zap.Error(err))
 		return fmt.Errorf("finishing job: %w", err)
 	}
 
+	jobFailuresByClassCounter.WithLabelValues(class.name).Inc()
 	return nil
 }
 
-// fetchAgentToken fetches the agent token from the agent token secret.
-func fetchAgentToken(ctx context.Context, logger *zap.Logger, k8sClient kubernetes.Interface, namespace, agentTokenSecretName string) (string, error) {
-	// Need to fetch the agent token ourselves.
-	tokenSecret, err := k8sClient.CoreV1().Secrets(namespace).Get(ctx, agentTokenSecretName, metav1.GetOptions{})
+// fetchAgentToken fetches the agent token from the agent token secret, using
+// the cache if it is watching the secret.
+func fetchAgentToken(ctx context.Context, logger *zap.Logger, k8sClient kubernetes.Interface, tokens *AgentTokenCache, namespace, agentTokenSecretName string) (string, error) {
+	tokenSecret, err := tokens.secret(agentTokenSecretName)
+	if errors.Is(err, errAgentTokenNotCached) {
+		// Need to fetch the agent token ourselves.
+		tokenSecret, err = k8sClient.CoreV1().Secrets(namespace).Get(ctx, agentTokenSecretName, metav1.GetOptions{})
+	}
 	if err != nil {
 		logger.Error("fetching agent token from secret", zap.Error(err))
 		return "", err
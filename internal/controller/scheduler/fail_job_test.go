@@ -0,0 +1,57 @@
+package scheduler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestFailureSummary(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{
+			message: "The Kubernetes job spent 5m without starting a pod.\nEvents: none",
+			want:    "The Kubernetes job spent 5m without starting a pod.",
+		},
+		{
+			message: "The following images could not be pulled or were unavailable:\n\nmanifest unknown",
+			want:    "An image doesn't exist, or its name is invalid.",
+		},
+	}
+	for _, test := range tests {
+		if got := failureSummary(test.message); got != test.want {
+			t.Errorf("failureSummary(%q) = %q, want %q", test.message, got, test.want)
+		}
+	}
+}
+
+func TestAcquireAndFailForObject_RecordsError(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "buildkite-abc", Namespace: "buildkite"},
+	}
+	recorder := record.NewFakeRecorder(1)
+
+	err := acquireAndFailForObject(context.Background(), zaptest.NewLogger(t), fake.NewClientset(), nil, recorder, &config.Config{}, pod, "message", 1)
+	if err == nil {
+		t.Fatal("acquireAndFailForObject(pod without UUID label) error = nil, want error")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if want := "Warning " + EventReasonJobFailError + " "; !strings.HasPrefix(event, want) {
+			t.Errorf("recorded event = %q, want prefix %q", event, want)
+		}
+	default:
+		t.Error("acquireAndFailForObject didn't record an event")
+	}
+}
@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/Khan/genqlient/graphql"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// fakeBuildkite fakes the parts of the Buildkite GraphQL and agent APIs used
+// to query, fail, and annotate jobs, and records what was done to them.
+type fakeBuildkite struct {
+	srv *httptest.Server
+
+	mu          sync.Mutex
+	jobState    api.JobStates
+	exitStatus  map[string]string // job UUID -> exit status it was failed with
+	logs        map[string]string // job UUID -> log written when failing it
+	annotations []string          // bodies of build annotations
+}
+
+func newFakeBuildkite(t *testing.T, jobState api.JobStates) *fakeBuildkite {
+	t.Helper()
+	f := &fakeBuildkite{
+		jobState:   jobState,
+		exitStatus: make(map[string]string),
+		logs:       make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /graphql", f.graphql)
+	mux.HandleFunc("POST /v3/register", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id": "agent", "name": "agent", "access_token": "access", "endpoint": %q}`, f.srv.URL+"/v3")
+	})
+	mux.HandleFunc("POST /v3/connect", f.ok)
+	mux.HandleFunc("POST /v3/disconnect", f.ok)
+	mux.HandleFunc("PUT /v3/jobs/{uuid}/acquire", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id": %q}`, r.PathValue("uuid"))
+	})
+	mux.HandleFunc("PUT /v3/jobs/{uuid}/start", f.ok)
+	mux.HandleFunc("POST /v3/jobs/{uuid}/chunks", func(w http.ResponseWriter, r *http.Request) {
+		// Chunks are gzipped.
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("decompressing log chunk: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(zr)
+		f.mu.Lock()
+		f.logs[r.PathValue("uuid")] += string(data)
+		f.mu.Unlock()
+		f.ok(w, r)
+	})
+	mux.HandleFunc("PUT /v3/jobs/{uuid}/finish", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ExitStatus string `json:"exit_status"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding finish request: %v", err)
+		}
+		f.mu.Lock()
+		f.exitStatus[r.PathValue("uuid")] = req.ExitStatus
+		f.mu.Unlock()
+		f.ok(w, r)
+	})
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeBuildkite) ok(w http.ResponseWriter, _ *http.Request) {
+	w.Write([]byte(`{}`))
+}
+
+func (f *fakeBuildkite) graphql(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OperationName string `json:"operationName"`
+		Variables     struct {
+			UUID  string `json:"uuid"`
+			Input struct {
+				Body string `json:"body"`
+			} `json:"input"`
+		} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req.OperationName {
+	case "GetCommandJob":
+		fmt.Fprintf(w, `{"data": {"job": {"__typename": "JobTypeCommand", "id": "job", "state": %q}}}`, f.jobState)
+	case "GetCommandJobBuild":
+		w.Write([]byte(`{"data": {"job": {"__typename": "JobTypeCommand", "build": {"id": "build"}}}}`))
+	case "BuildAnnotate":
+		f.annotations = append(f.annotations, req.Variables.Input.Body)
+		w.Write([]byte(`{"data": {"buildAnnotate": {"clientMutationId": null}}}`))
+	default:
+		w.Write([]byte(`{"data": {}}`))
+	}
+}
+
+// gql returns a GraphQL client for the fake.
+func (f *fakeBuildkite) gql() graphql.Client {
+	return api.NewClient("token", f.srv.URL+"/graphql")
+}
+
+// configure points cfg at the fake for failing jobs, and returns the agent
+// token secret it uses.
+func (f *fakeBuildkite) configure(cfg *config.Config) *corev1.Secret {
+	cfg.AgentTokenSecret = "agent-token"
+	cfg.AgentConfig = &config.AgentConfig{Endpoint: ptr.To(f.srv.URL + "/v3")}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent-token", Namespace: cfg.Namespace},
+		Data:       map[string][]byte{agentTokenKey: []byte("token")},
+	}
+}
+
+// failedWith returns the exit status the job was failed with, if it was.
+func (f *fakeBuildkite) failedWith(jobUUID string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	status, ok := f.exitStatus[jobUUID]
+	return status, ok
+}
+
+// log returns the log written for the job when it was failed.
+func (f *fakeBuildkite) log(jobUUID string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logs[jobUUID]
+}
+
+// annotated returns the bodies of the build annotations, joined.
+func (f *fakeBuildkite) annotated() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return strings.Join(f.annotations, "\n")
+}
@@ -10,17 +10,16 @@ import (
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
 
 	"github.com/google/uuid"
-	"github.com/jedib0t/go-pretty/v6/table"
 	"go.uber.org/zap"
 
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/duration"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/utils/ptr"
 )
@@ -33,8 +32,10 @@ type jobWatcher struct {
 	// Logs go here
 	logger *zap.Logger
 
-	k8s kubernetes.Interface
-	cfg *config.Config
+	k8s      kubernetes.Interface
+	tokens   *AgentTokenCache
+	recorder record.EventRecorder
+	cfg      *config.Config
 
 	// Tracks stalling jobs (jobs that have yet to create pods).
 	stallingJobsMu sync.Mutex
@@ -52,11 +53,14 @@ type jobWatcher struct {
 	resourceEventHandlerCtx context.Context
 }
 
-// NewJobWatcher creates a JobWatcher.
-func NewJobWatcher(logger *zap.Logger, k8sClient kubernetes.Interface, cfg *config.Config) *jobWatcher {
+// NewJobWatcher creates a JobWatcher. Its decisions are recorded as events on
+// the jobs.
+func NewJobWatcher(logger *zap.Logger, k8sClient kubernetes.Interface, tokens *AgentTokenCache, recorder record.EventRecorder, cfg *config.Config) *jobWatcher {
 	w := &jobWatcher{
 		logger:       logger,
 		k8s:          k8sClient,
+		tokens:       tokens,
+		recorder:     recorder,
 		cfg:          cfg,
 		stallingJobs: make(map[uuid.UUID]*batchv1.Job),
 		ignoredJobs:  make(map[uuid.UUID]struct{}),
@@ -180,14 +184,22 @@ func (w *jobWatcher) checkFinishedWithoutPod(ctx context.Context, log *zap.Logge
 	// Because no pod has been created, the agent hasn't started.
 	// We can acquire the Buildkite job and fail it ourselves.
 	log.Info("The Kubernetes job ended without starting a pod. Failing the corresponding Buildkite job")
+	w.recorder.Event(kjob, corev1.EventTypeWarning, EventReasonFinishedWithoutPod, "The job ended without starting a pod")
 	message := "The Kubernetes job ended without starting a pod.\n"
-	message += w.fetchEvents(ctx, log, kjob)
-	w.failJob(ctx, log, kjob, message)
+	message += fetchEvents(ctx, log, w.k8s, kjob.Namespace, "Job", kjob.Name, "")
+	w.failJob(ctx, log, kjob, config.FailureFinishedWithoutPod, message)
 }
 
 func (w *jobWatcher) checkStalledWithoutPod(log *zap.Logger, jobUUID uuid.UUID, kjob *batchv1.Job) {
 	log.Debug("Checking job for stalling without a pod")
 
+	// A suspended job is waiting for admission (e.g. by Kueue), so it isn't
+	// expected to have a pod. When it is resumed, its start time is reset.
+	if kjob.Spec.Suspend != nil && *kjob.Spec.Suspend {
+		w.removeFromStalling(jobUUID)
+		return
+	}
+
 	// If the job is not finished and there is no pod, it should start one
 	// before too long. Otherwise the job is stalled.
 	pods := kjob.Status.Active + kjob.Status.Failed + kjob.Status.Succeeded
@@ -210,28 +222,8 @@ func (w *jobWatcher) checkStalledWithoutPod(log *zap.Logger, jobUUID uuid.UUID,
 	w.addToStalling(jobUUID, kjob)
 }
 
-func (w *jobWatcher) fetchEvents(ctx context.Context, log *zap.Logger, kjob *batchv1.Job) string {
-	// List the events for the job, which might contain useful info for
-	// diagnosing the problem.
-	events := w.k8s.CoreV1().Events(w.cfg.Namespace)
-	evlist, err := events.List(ctx, metav1.ListOptions{
-		FieldSelector: fields.AndSelectors(
-			fields.OneTermEqualSelector("involvedObject.kind", "Job"),
-			fields.OneTermEqualSelector("involvedObject.name", kjob.Name),
-		).String(),
-	})
-	if err != nil {
-		log.Error("Couldn't get events for job", zap.Error(err))
-		return fmt.Sprintf("Couldn't get events for job %s: %v", kjob.Name, err)
-	}
-	if evlist == nil {
-		return ""
-	}
-	return w.formatEvents(evlist)
-}
-
-func (w *jobWatcher) failJob(ctx context.Context, log *zap.Logger, kjob *batchv1.Job, message string) {
-	if err := acquireAndFailForObject(ctx, log, w.k8s, w.cfg, kjob, message); err != nil {
+func (w *jobWatcher) failJob(ctx context.Context, log *zap.Logger, kjob *batchv1.Job, kind, message string) {
+	if err := acquireAndFailForObject(ctx, log, w.k8s, w.tokens, w.recorder, w.cfg, kjob, message, w.cfg.FailureExitStatuses.For(kind)); err != nil {
 		// Maybe the job was cancelled in the meantime?
 		log.Error("Could not fail Buildkite job", zap.Error(err))
 		jobWatcherBuildkiteJobFailErrorsCounter.Inc()
@@ -240,28 +232,6 @@ func (w *jobWatcher) failJob(ctx context.Context, log *zap.Logger, kjob *batchv1
 	jobWatcherBuildkiteJobFailsCounter.Inc()
 }
 
-func (w *jobWatcher) formatEvents(evlist *corev1.EventList) string {
-	if len(evlist.Items) == 0 {
-		return "Events: none"
-	}
-
-	tw := table.NewWriter()
-	tw.SetStyle(table.StyleColoredDark)
-	tw.AppendHeader(table.Row{"LAST EVENT", "REPEATED", "TYPE", "REASON", "MESSAGE"})
-	tw.AppendSeparator()
-	for _, event := range evlist.Items {
-		if event.Series == nil {
-			tw.AppendRow(table.Row{event.EventTime.Time, "-", event.Type, event.Reason, event.Message})
-			continue
-		}
-		lastTime := event.Series.LastObservedTime.Time
-		firstToLast := duration.HumanDuration(lastTime.Sub(event.EventTime.Time))
-		countMsg := fmt.Sprintf("x%d over %s", event.Series.Count, firstToLast)
-		tw.AppendRow(table.Row{lastTime, countMsg, event.Type, event.Reason, event.Message})
-	}
-	return tw.Render()
-}
-
 func (w *jobWatcher) addToStalling(jobUUID uuid.UUID, kjob *batchv1.Job) {
 	w.stallingJobsMu.Lock()
 	defer w.stallingJobsMu.Unlock()
@@ -317,8 +287,9 @@ func (w *jobWatcher) cleanupStalledJob(ctx context.Context, kjob *batchv1.Job) {
 	// Fetch events for the failure message, and try to fail the job.
 	stallDuration := duration.HumanDuration(time.Since(kjob.Status.StartTime.Time))
 	message := fmt.Sprintf("The Kubernetes job spent %s without starting a pod.\n", stallDuration)
-	message += w.fetchEvents(ctx, log, kjob)
-	w.failJob(ctx, log, kjob, message)
+	w.recorder.Eventf(kjob, corev1.EventTypeWarning, EventReasonStalledWithoutPod, "The job spent %s without starting a pod, so it will be ended", stallDuration)
+	message += fetchEvents(ctx, log, w.k8s, kjob.Namespace, "Job", kjob.Name, "")
+	w.failJob(ctx, log, kjob, config.FailureStalledWithoutPod, message)
 
 	// Use ActiveDeadlineSeconds to fail the job, which makes k8s delete the job
 	// in the future.
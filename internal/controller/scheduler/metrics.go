@@ -17,6 +17,18 @@ var jobEndToEndDurationHistogram = promauto.NewHistogram(prometheus.HistogramOpt
 	NativeHistogramZeroThreshold: 0.01,
 })
 
+var agentTokenMissingGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: promNamespace,
+	Name:      "agent_token_secret_missing",
+	Help:      "Whether each agent token secret is missing or has no token (1), or is usable (0)",
+}, []string{"secret"})
+
+var jobFailuresByClassCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: promNamespace,
+	Name:      "job_failures_total",
+	Help:      "Count of jobs failed on Buildkite by the controller, by the likely cause of the failure (unclassified if it wasn't recognised)",
+}, []string{"class"})
+
 // Scheduler metrics
 
 var (
@@ -137,12 +149,13 @@ var (
 	jobCancelCheckerGaugeFunc        = func() int { return 0 }
 	podWatcherIgnoredJobsGaugeFunc   = func() int { return 0 }
 	watchingForImageFailureGaugeFunc = func() int { return 0 }
+	watchingUnschedulableGaugeFunc   = func() int { return 0 }
 
 	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace: promNamespace,
 		Subsystem: "pod_watcher",
 		Name:      "num_job_cancel_checkers",
-		Help:      "Current count of job cancellation checkers",
+		Help:      "Current count of jobs with pending pods being checked for cancellation",
 	}, func() float64 { return float64(jobCancelCheckerGaugeFunc()) })
 	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
 		Namespace: promNamespace,
@@ -156,6 +169,12 @@ var (
 		Name:      "num_watching_for_image_failure",
 		Help:      "Current count of pods being watched for potential image-related failures",
 	}, func() float64 { return float64(watchingForImageFailureGaugeFunc()) })
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: promNamespace,
+		Subsystem: "pod_watcher",
+		Name:      "num_watching_unschedulable",
+		Help:      "Current count of unschedulable pods being watched",
+	}, func() float64 { return float64(watchingUnschedulableGaugeFunc()) })
 
 	podWatcherOnAddEventCounter = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: promNamespace,
@@ -207,6 +226,36 @@ var (
 		Name:      "pods_evicted_total",
 		Help:      "Count of evictions created for pods by podWatcher",
 	}, []string{"eviction_reason"})
+	podsUnschedulableCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "pod_watcher",
+		Name:      "pods_unschedulable_total",
+		Help:      "Count of pods that stayed unschedulable for longer than the grace period",
+	})
+	podFallbacksCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "pod_watcher",
+		Name:      "unschedulable_fallbacks_total",
+		Help:      "Count of jobs replaced using a fallback because their pods were unschedulable",
+	}, []string{"fallback"})
+	podFallbackErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "pod_watcher",
+		Name:      "unschedulable_fallback_errors_total",
+		Help:      "Count of errors while replacing jobs using a fallback",
+	}, []string{"fallback", "reason"})
+	podsDisruptedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "pod_watcher",
+		Name:      "pods_disrupted_total",
+		Help:      "Count of pods found to be preempted, evicted, or lost with their node",
+	}, []string{"reason"})
+	podsOOMKilledCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "pod_watcher",
+		Name:      "containers_oom_killed_total",
+		Help:      "Count of containers found to be OOMKilled, by container role",
+	}, []string{"role"})
 	podEvictionErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Namespace: promNamespace,
 		Subsystem: "pod_watcher",
@@ -243,4 +292,77 @@ var (
 		Name:      "cleanup_errors_total",
 		Help:      "Count of errors during attempts to clean up a job with a finished agent",
 	}, []string{"reason"})
+
+	completionWatcherSidecarLogsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "completion_watcher",
+		Name:      "sidecar_logs_captured_total",
+		Help:      "Count of sidecar container logs saved for jobs after the sidecar failed",
+	})
+	completionWatcherSidecarLogErrorsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "completion_watcher",
+		Name:      "sidecar_log_capture_errors_total",
+		Help:      "Count of errors when fetching sidecar logs or saving them for a job",
+	})
+)
+
+// Orphan reconciler metrics
+var (
+	orphanedJobsDeletedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "orphan_reconciler",
+		Name:      "jobs_deleted_total",
+		Help:      "Count of unfinished Kubernetes jobs deleted because their Buildkite job was in a terminal state, by Buildkite job state",
+	}, []string{"reason"})
+	orphanedJobDeleteErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: promNamespace,
+		Subsystem: "orphan_reconciler",
+		Name:      "job_delete_errors_total",
+		Help:      "Count of errors deleting orphaned Kubernetes jobs",
+	}, []string{"reason", "error"})
+)
+
+// Pod startup watcher metrics
+var (
+	// Startup phases take from seconds (cached images, existing nodes) to
+	// many minutes (autoscaling, large image pulls, large repositories).
+	podStartupBuckets = prometheus.ExponentialBuckets(0.5, 2, 12)
+
+	podStartupScheduledHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                    promNamespace,
+		Subsystem:                    "pod_startup",
+		Name:                         "scheduled_seconds",
+		Help:                         "Duration between creating a Kubernetes job and its pod being scheduled onto a node",
+		Buckets:                      podStartupBuckets,
+		NativeHistogramBucketFactor:  1.1,
+		NativeHistogramZeroThreshold: 0.01,
+	}, []string{"queue", "pipeline"})
+	podStartupInitContainerHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                    promNamespace,
+		Subsystem:                    "pod_startup",
+		Name:                         "init_container_finished_seconds",
+		Help:                         "Duration between a pod being scheduled and each of its init containers finishing successfully, by kind of init container (copy-agent, imagecheck, or other)",
+		Buckets:                      podStartupBuckets,
+		NativeHistogramBucketFactor:  1.1,
+		NativeHistogramZeroThreshold: 0.01,
+	}, []string{"queue", "pipeline", "container"})
+	podStartupCheckoutHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                    promNamespace,
+		Subsystem:                    "pod_startup",
+		Name:                         "checkout_finished_seconds",
+		Help:                         "Duration between a pod's init containers finishing and its checkout container finishing successfully",
+		Buckets:                      podStartupBuckets,
+		NativeHistogramBucketFactor:  1.1,
+		NativeHistogramZeroThreshold: 0.01,
+	}, []string{"queue", "pipeline"})
+	podStartupCommandStartedHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                    promNamespace,
+		Subsystem:                    "pod_startup",
+		Name:                         "command_container_started_seconds",
+		Help:                         "Duration between a pod's init containers finishing and its first command container starting",
+		Buckets:                      podStartupBuckets,
+		NativeHistogramBucketFactor:  1.1,
+		NativeHistogramZeroThreshold: 0.01,
+	}, []string{"queue", "pipeline"})
 )
@@ -0,0 +1,191 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// oomKill describes a container that was killed for exceeding its memory
+// limit.
+type oomKill struct {
+	container   string
+	init        bool
+	memoryLimit string
+}
+
+// oomKilledContainers returns the containers in the pod that were OOMKilled,
+// sorted by name. Containers that were restarted after being OOMKilled (e.g.
+// sidecar init containers) are included.
+func oomKilledContainers(pod *corev1.Pod) []oomKill {
+	limits := make(map[string]string)
+	for _, c := range slices.Concat(pod.Spec.InitContainers, pod.Spec.Containers) {
+		if mem, ok := c.Resources.Limits[corev1.ResourceMemory]; ok {
+			limits[c.Name] = mem.String()
+		}
+	}
+
+	var kills []oomKill
+	check := func(statuses []corev1.ContainerStatus, init bool) {
+		for _, status := range statuses {
+			if !isOOMKilled(status.State.Terminated) && !isOOMKilled(status.LastTerminationState.Terminated) {
+				continue
+			}
+			limit := limits[status.Name]
+			if limit == "" {
+				limit = "(none)"
+			}
+			kills = append(kills, oomKill{
+				container:   status.Name,
+				init:        init,
+				memoryLimit: limit,
+			})
+		}
+	}
+	check(pod.Status.InitContainerStatuses, true)
+	check(pod.Status.ContainerStatuses, false)
+
+	slices.SortFunc(kills, func(a, b oomKill) int { return strings.Compare(a.container, b.container) })
+	return kills
+}
+
+func isOOMKilled(term *corev1.ContainerStateTerminated) bool {
+	return term != nil && term.Reason == "OOMKilled"
+}
+
+// containerRole classifies a container by name, for use as a metric label.
+func containerRole(name string, init bool) string {
+	switch {
+	case init:
+		return "init"
+	case name == AgentContainerName:
+		return "agent"
+	case name == CheckoutContainerName:
+		return "checkout"
+	case strings.HasPrefix(name, "container-"):
+		return "command"
+	default:
+		return "sidecar"
+	}
+}
+
+func formatOOMKills(kills []oomKill) string {
+	var b strings.Builder
+	for _, k := range kills {
+		fmt.Fprintf(&b, "Container %q was OOMKilled: it exceeded its memory limit of %s.\n", k.container, k.memoryLimit)
+	}
+	return b.String()
+}
+
+// reportOOMKills looks for containers that were OOMKilled, and makes that
+// visible: the Kubernetes job is annotated with the container names, and
+// (unless the controller is failing the job itself, which is the case for init
+// containers) the build is annotated with an explanation, because otherwise
+// users only see exit status 137.
+//
+// The explanation can't go in the job log in that case: the agent in the pod
+// has acquired the job, and only the agent that acquired a job can write to
+// its log. For init containers, failOnInitContainerFailure writes it to the
+// log when failing the job.
+func (w *podWatcher) reportOOMKills(ctx context.Context, log *zap.Logger, jobUUID uuid.UUID, pod *corev1.Pod) {
+	kills := oomKilledContainers(pod)
+	if len(kills) == 0 {
+		return
+	}
+
+	// Only report each container once per job.
+	w.oomReportedMu.Lock()
+	reported := w.oomReported[jobUUID]
+	if reported == nil {
+		reported = make(map[string]struct{})
+		w.oomReported[jobUUID] = reported
+	}
+	var fresh []oomKill
+	for _, k := range kills {
+		if _, seen := reported[k.container]; seen {
+			continue
+		}
+		reported[k.container] = struct{}{}
+		fresh = append(fresh, k)
+	}
+	w.oomReportedMu.Unlock()
+
+	if len(fresh) == 0 {
+		return
+	}
+
+	var agentOwned []oomKill
+	for _, k := range fresh {
+		log.Info("Container was OOMKilled",
+			zap.String("container", k.container),
+			zap.String("memory_limit", k.memoryLimit),
+		)
+		podsOOMKilledCounter.WithLabelValues(containerRole(k.container, k.init)).Inc()
+		if !k.init {
+			agentOwned = append(agentOwned, k)
+		}
+	}
+
+	w.annotateJobWithOOMKills(ctx, log, pod, kills)
+
+	if len(agentOwned) == 0 {
+		return
+	}
+	body := fmt.Sprintf("Job [%s](%s) ran out of memory.\n\n```\n%s```\n\nConsider raising the memory limits in the `kubernetes` plugin's `podSpec` or `podSpecPatch`.",
+		jobUUID,
+		pod.Annotations[config.JobURLAnnotation],
+		formatOOMKills(agentOwned),
+	)
+	if err := annotateBuild(ctx, w.gql, jobUUID.String(), "oom-killed-"+jobUUID.String(), api.AnnotationStyleError, body); err != nil {
+		log.Warn("Couldn't annotate build with OOMKilled containers", zap.Error(err))
+	}
+}
+
+// annotateJobWithOOMKills records the names of OOMKilled containers on the
+// Kubernetes job owning the pod, so they are visible to kubectl users after the
+// pod has been cleaned up.
+func (w *podWatcher) annotateJobWithOOMKills(ctx context.Context, log *zap.Logger, pod *corev1.Pod, kills []oomKill) {
+	jobName := pod.Labels["job-name"]
+	if jobName == "" {
+		return
+	}
+	names := make([]string, 0, len(kills))
+	for _, k := range kills {
+		names = append(names, k.container)
+	}
+
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		job, err := w.k8s.BatchV1().Jobs(pod.Namespace).Get(ctx, jobName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if job.Annotations == nil {
+			job.Annotations = make(map[string]string)
+		}
+		job.Annotations[config.OOMKilledAnnotation] = strings.Join(names, ",")
+		_, err = w.k8s.BatchV1().Jobs(pod.Namespace).Update(ctx, job, metav1.UpdateOptions{})
+		return err
+	}); err != nil {
+		log.Warn("Couldn't annotate job with OOMKilled containers",
+			zap.String("reason", string(kerrors.ReasonForError(err))),
+			zap.Error(err),
+		)
+	}
+}
+
+func (w *podWatcher) forgetOOMKills(jobUUID uuid.UUID) {
+	w.oomReportedMu.Lock()
+	defer w.oomReportedMu.Unlock()
+	delete(w.oomReported, jobUUID)
+}
@@ -0,0 +1,179 @@
+package scheduler
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var oomKilledState = corev1.ContainerState{
+	Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"},
+}
+
+func withMemoryLimit(c corev1.Container, limit string) corev1.Container {
+	c.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(limit)}
+	return c
+}
+
+// oomTestPod returns a pod for the job with the given container statuses.
+func oomTestPod(jobUUID uuid.UUID, initStatuses, statuses []corev1.ContainerStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "buildkite-" + jobUUID.String(),
+			Namespace: "buildkite",
+			Labels: map[string]string{
+				config.UUIDLabel:          jobUUID.String(),
+				"tag.buildkite.com/queue": "kubernetes",
+				"job-name":                k8sJobName(jobUUID.String()),
+			},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: CopyAgentContainerName},
+				withMemoryLimit(corev1.Container{Name: CheckoutContainerName}, "1Gi"),
+			},
+			Containers: []corev1.Container{
+				{Name: AgentContainerName},
+				withMemoryLimit(corev1.Container{Name: "container-0"}, "512Mi"),
+				{Name: "redis"},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:                 corev1.PodRunning,
+			InitContainerStatuses: initStatuses,
+			ContainerStatuses:     statuses,
+		},
+	}
+}
+
+func TestOOMKilledContainers(t *testing.T) {
+	pod := oomTestPod(uuid.New(),
+		[]corev1.ContainerStatus{
+			{Name: CopyAgentContainerName, State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 0, Reason: "Completed"},
+			}},
+			// Restarted after being OOMKilled.
+			{Name: CheckoutContainerName, LastTerminationState: oomKilledState},
+		},
+		[]corev1.ContainerStatus{
+			{Name: AgentContainerName},
+			{Name: "redis", State: oomKilledState},
+			{Name: "container-0", State: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"},
+			}},
+		},
+	)
+
+	got := oomKilledContainers(pod)
+	want := []oomKill{
+		{container: CheckoutContainerName, init: true, memoryLimit: "1Gi"},
+		{container: "redis", memoryLimit: "(none)"},
+	}
+	if diff := cmp.Diff(got, want, cmp.AllowUnexported(oomKill{})); diff != "" {
+		t.Errorf("oomKilledContainers() diff (-got +want):\n%s", diff)
+	}
+}
+
+func TestReportOOMKills(t *testing.T) {
+	fb := newFakeBuildkite(t, api.JobStatesRunning)
+	jobUUID := uuid.New()
+	kjob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: k8sJobName(jobUUID.String()), Namespace: "buildkite"},
+	}
+	k8s := fake.NewClientset(kjob)
+	w := NewPodWatcher(zaptest.NewLogger(t), k8s, fb.gql(), nil, record.NewFakeRecorder(10), &config.Config{Namespace: "buildkite"})
+	pod := oomTestPod(jobUUID, nil, []corev1.ContainerStatus{
+		{Name: AgentContainerName},
+		{Name: "container-0", State: oomKilledState},
+	})
+
+	// Pod updates are seen repeatedly, but each kill is reported once.
+	for range 2 {
+		w.reportOOMKills(context.Background(), zaptest.NewLogger(t), jobUUID, pod)
+	}
+
+	got, err := k8s.BatchV1().Jobs("buildkite").Get(context.Background(), kjob.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(job) error = %v", err)
+	}
+	if got := got.Annotations[config.OOMKilledAnnotation]; got != "container-0" {
+		t.Errorf("job annotation %s = %q, want %q", config.OOMKilledAnnotation, got, "container-0")
+	}
+
+	fb.mu.Lock()
+	annotations := len(fb.annotations)
+	fb.mu.Unlock()
+	if annotations != 1 {
+		t.Errorf("build annotated %d times, want once", annotations)
+	}
+	if want := `Container "container-0" was OOMKilled: it exceeded its memory limit of 512Mi.`; !strings.Contains(fb.annotated(), want) {
+		t.Errorf("build annotation = %q, want it to contain %q", fb.annotated(), want)
+	}
+}
+
+func TestFailOnInitContainerFailure_ExitStatus(t *testing.T) {
+	const oomKilledExitStatus = 42
+
+	tests := []struct {
+		name           string
+		state          corev1.ContainerState
+		wantExitStatus int
+		wantLog        string
+	}{
+		{
+			name:           "OOMKilled",
+			state:          oomKilledState,
+			wantExitStatus: oomKilledExitStatus,
+			wantLog:        `Container "checkout" was OOMKilled: it exceeded its memory limit of 1Gi.`,
+		},
+		{
+			name: "failed",
+			state: corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{ExitCode: 128, Reason: "Error"},
+			},
+			wantExitStatus: config.FailureExitStatuses(nil).For(config.FailureInitContainer),
+			wantLog:        "checkout",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fb := newFakeBuildkite(t, api.JobStatesScheduled)
+			cfg := &config.Config{Namespace: "buildkite", OOMKilledExitStatus: oomKilledExitStatus}
+			secret := fb.configure(cfg)
+			w := NewPodWatcher(zaptest.NewLogger(t), fake.NewClientset(secret), fb.gql(), nil, record.NewFakeRecorder(10), cfg)
+
+			jobUUID := uuid.New()
+			pod := oomTestPod(jobUUID, []corev1.ContainerStatus{
+				{Name: CheckoutContainerName, State: test.state},
+			}, nil)
+			pod.Status.Phase = corev1.PodFailed
+
+			w.failOnInitContainerFailure(context.Background(), zaptest.NewLogger(t), pod)
+
+			status, failed := fb.failedWith(jobUUID.String())
+			if !failed {
+				t.Fatal("job wasn't failed")
+			}
+			if want := strconv.Itoa(test.wantExitStatus); status != want {
+				t.Errorf("job failed with exit status %s, want %s", status, want)
+			}
+			if log := fb.log(jobUUID.String()); !strings.Contains(log, test.wantLog) {
+				t.Errorf("job log = %q, want it to contain %q", log, test.wantLog)
+			}
+		})
+	}
+}
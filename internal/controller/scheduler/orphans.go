@@ -0,0 +1,188 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+
+	"github.com/Khan/genqlient/graphql"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	"k8s.io/utils/ptr"
+)
+
+// orphanReconcileInterval is how often the orphan reconciler checks jobs.
+const orphanReconcileInterval = time.Minute
+
+type orphanReconciler struct {
+	logger *zap.Logger
+	k8s    kubernetes.Interface
+	gql    graphql.Client
+	cfg    *config.Config
+
+	// Kubernetes jobs are deleted once their Buildkite job has been in a
+	// terminal state for this long.
+	gracePeriod time.Duration
+
+	jobLister batchlisters.JobLister
+
+	// When each Kubernetes job was first seen with its Buildkite job in a
+	// terminal state.
+	terminalSinceMu sync.Mutex
+	terminalSince   map[types.UID]time.Time
+}
+
+// NewOrphanReconciler creates a reconciler that periodically deletes
+// unfinished Kubernetes jobs whose Buildkite job is in a terminal state
+// (cancelled, expired, finished, etc). This catches jobs that would otherwise
+// linger until their active deadline, e.g. a running pod whose job was
+// cancelled while the agent couldn't reach Buildkite, or a job stuck without
+// a pod.
+func NewOrphanReconciler(logger *zap.Logger, k8s kubernetes.Interface, gql graphql.Client, cfg *config.Config) *orphanReconciler {
+	return &orphanReconciler{
+		logger:        logger,
+		k8s:           k8s,
+		gql:           gql,
+		cfg:           cfg,
+		gracePeriod:   cfg.OrphanedJobGracePeriod,
+		terminalSince: make(map[types.UID]time.Time),
+	}
+}
+
+// RegisterInformer sets up the job lister and starts the reconcile loop.
+func (r *orphanReconciler) RegisterInformer(ctx context.Context, factory informers.SharedInformerFactory) error {
+	informer := factory.Batch().V1().Jobs()
+	r.jobLister = informer.Lister()
+	// Ensure the informer exists before starting the factory.
+	informer.Informer()
+	go factory.Start(ctx.Done())
+	go r.run(ctx)
+	return nil
+}
+
+func (r *orphanReconciler) run(ctx context.Context) {
+	ticker := time.NewTicker(orphanReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.reconcile(ctx); err != nil {
+				r.logger.Warn("Couldn't reconcile orphaned jobs", zap.Error(err))
+			}
+		}
+	}
+}
+
+// reconcile checks the Buildkite state of each unfinished Kubernetes job, and
+// deletes those whose Buildkite job has been terminal for the grace period.
+func (r *orphanReconciler) reconcile(ctx context.Context) error {
+	kjobs, err := r.jobLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("listing jobs: %w", err)
+	}
+
+	candidates := make(map[string]*batchv1.Job)
+	uuids := make([]string, 0, len(kjobs))
+	for _, kjob := range kjobs {
+		if kjob.DeletionTimestamp != nil || model.JobFinished(kjob) || model.JobReplaced(kjob) {
+			continue
+		}
+		jobUUID := kjob.Labels[config.UUIDLabel]
+		if jobUUID == "" {
+			continue
+		}
+		candidates[jobUUID] = kjob
+		uuids = append(uuids, jobUUID)
+	}
+	r.forgetMissing(candidates)
+	if len(uuids) == 0 {
+		return nil
+	}
+
+	// Act on the states that could be queried, even if some couldn't.
+	states, queryErr := api.GetCommandJobStates(ctx, r.gql, uuids)
+	now := time.Now()
+	for jobUUID, state := range states {
+		kjob := candidates[jobUUID]
+		if kjob == nil {
+			continue
+		}
+		if !state.Terminal() {
+			r.forget(kjob.UID)
+			continue
+		}
+		if now.Sub(r.markTerminal(kjob.UID, now)) < r.gracePeriod {
+			continue
+		}
+		r.deleteOrphan(ctx, kjob, state)
+	}
+	return queryErr
+}
+
+// markTerminal records that the job's Buildkite job is terminal, and returns
+// when that was first seen.
+func (r *orphanReconciler) markTerminal(uid types.UID, now time.Time) time.Time {
+	r.terminalSinceMu.Lock()
+	defer r.terminalSinceMu.Unlock()
+	since, seen := r.terminalSince[uid]
+	if !seen {
+		r.terminalSince[uid] = now
+		return now
+	}
+	return since
+}
+
+func (r *orphanReconciler) forget(uid types.UID) {
+	r.terminalSinceMu.Lock()
+	defer r.terminalSinceMu.Unlock()
+	delete(r.terminalSince, uid)
+}
+
+// forgetMissing forgets jobs that are no longer candidates.
+func (r *orphanReconciler) forgetMissing(candidates map[string]*batchv1.Job) {
+	current := make(map[types.UID]struct{}, len(candidates))
+	for _, kjob := range candidates {
+		current[kjob.UID] = struct{}{}
+	}
+	r.terminalSinceMu.Lock()
+	defer r.terminalSinceMu.Unlock()
+	for uid := range r.terminalSince {
+		if _, ok := current[uid]; !ok {
+			delete(r.terminalSince, uid)
+		}
+	}
+}
+
+func (r *orphanReconciler) deleteOrphan(ctx context.Context, kjob *batchv1.Job, state api.JobStates) {
+	reason := strings.ToLower(string(state))
+	log := loggerForObject(r.logger, kjob).With(zap.String("job_state", string(state)))
+
+	err := r.k8s.BatchV1().Jobs(kjob.Namespace).Delete(ctx, kjob.Name, metav1.DeleteOptions{
+		PropagationPolicy: ptr.To(metav1.DeletePropagationBackground),
+		Preconditions:     &metav1.Preconditions{UID: ptr.To(kjob.UID)},
+	})
+	if err != nil && !kerrors.IsNotFound(err) {
+		orphanedJobDeleteErrorsCounter.WithLabelValues(reason, string(kerrors.ReasonForError(err))).Inc()
+		log.Warn("Couldn't delete orphaned job", zap.Error(err))
+		return
+	}
+	r.forget(kjob.UID)
+	orphanedJobsDeletedCounter.WithLabelValues(reason).Inc()
+	log.Info("Deleted Kubernetes job whose Buildkite job is over")
+}
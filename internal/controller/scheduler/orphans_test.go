@@ -0,0 +1,74 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOrphanReconciler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	newJob := func(name, jobUUID string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "buildkite",
+				UID:       types.UID("uid-" + name),
+				Labels:    map[string]string{config.UUIDLabel: jobUUID},
+			},
+		}
+	}
+	k8s := fake.NewClientset(
+		newJob("finished", "11111111-1111-1111-1111-111111111111"),
+		newJob("running", "22222222-2222-2222-2222-222222222222"),
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		// Job order in the query isn't fixed, so answer by UUID.
+		w.Write([]byte(`{"data": {
+			"j0": {"uuid": "11111111-1111-1111-1111-111111111111", "state": "FINISHED"},
+			"j1": {"uuid": "22222222-2222-2222-2222-222222222222", "state": "RUNNING"}
+		}}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	r := NewOrphanReconciler(zaptest.NewLogger(t), k8s, api.NewClient("token", srv.URL), &config.Config{
+		OrphanedJobGracePeriod: time.Nanosecond,
+	})
+	factory := informers.NewSharedInformerFactory(k8s, 0)
+	r.jobLister = factory.Batch().V1().Jobs().Lister()
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+
+	// The first pass only notices the finished job.
+	if err := r.reconcile(ctx); err != nil {
+		t.Fatalf("r.reconcile(ctx) = %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := r.reconcile(ctx); err != nil {
+		t.Fatalf("r.reconcile(ctx) = %v", err)
+	}
+
+	jobs, err := k8s.BatchV1().Jobs("buildkite").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing jobs: %v", err)
+	}
+	if len(jobs.Items) != 1 || jobs.Items[0].Name != "running" {
+		t.Errorf("remaining jobs = %v, want only the running job", jobs.Items)
+	}
+}
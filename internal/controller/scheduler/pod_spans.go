@@ -0,0 +1,120 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	_ "k8s.io/client-go/tools/cache"
+)
+
+// podSpanRecorder records spans for the startup of each pod, as part of the
+// trace of its Buildkite job (see tracing.InjectIntoJob):
+//   - pod.scheduled, from the pod's creation until it was scheduled, and
+//   - agent.start, from then until the agent container started.
+type podSpanRecorder struct {
+	logger *zap.Logger
+
+	// This is the context passed to RegisterInformer.
+	// It's being stored here (grrrr!) because the k8s ResourceEventHandler
+	// interface doesn't have context args. (Working around an interface in a
+	// library outside of our control is a carve-out from the usual rule.)
+	resourceEventHandlerCtx context.Context
+}
+
+// NewPodSpanRecorder creates a podSpanRecorder.
+func NewPodSpanRecorder(logger *zap.Logger) *podSpanRecorder {
+	return &podSpanRecorder{logger: logger}
+}
+
+// RegisterInformer registers the recorder to listen for pod events.
+func (r *podSpanRecorder) RegisterInformer(ctx context.Context, factory informers.SharedInformerFactory) error {
+	informer := factory.Core().V1().Pods().Informer()
+	if _, err := informer.AddEventHandler(r); err != nil {
+		return err
+	}
+	r.resourceEventHandlerCtx = ctx // see note on field
+	go factory.Start(ctx.Done())
+	return nil
+}
+
+// OnAdd records spans for pods that were already scheduled or started when
+// first seen, except for those that existed before the controller started
+// (which were probably recorded by the previous controller).
+func (r *podSpanRecorder) OnAdd(obj any, isInInitialList bool) {
+	if isInInitialList {
+		return
+	}
+	r.recordSpans(nil, obj.(*corev1.Pod))
+}
+
+func (r *podSpanRecorder) OnUpdate(oldObj, newObj any) {
+	r.recordSpans(oldObj.(*corev1.Pod), newObj.(*corev1.Pod))
+}
+
+// ignored
+func (r *podSpanRecorder) OnDelete(obj any) {}
+
+// recordSpans records the spans for the steps the pod completed between
+// oldPod (which may be nil) and newPod.
+func (r *podSpanRecorder) recordSpans(oldPod, newPod *corev1.Pod) {
+	if newPod.Annotations[config.TraceParentAnnotation] == "" {
+		return
+	}
+	scheduledAt := podScheduledAt(newPod)
+	if scheduledAt.IsZero() {
+		return
+	}
+	ctx := tracing.ContextFromAnnotations(r.resourceEventHandlerCtx, newPod.Annotations)
+	attrs := trace.WithAttributes(
+		attribute.String("k8s.pod.name", newPod.Name),
+		attribute.String("k8s.node.name", newPod.Spec.NodeName),
+	)
+
+	if oldPod == nil || podScheduledAt(oldPod).IsZero() {
+		_, span := tracing.Tracer().Start(ctx, "pod.scheduled", attrs, trace.WithTimestamp(newPod.CreationTimestamp.Time))
+		span.End(trace.WithTimestamp(scheduledAt))
+	}
+
+	startedAt := agentStartedAt(newPod)
+	if startedAt.IsZero() || (oldPod != nil && !agentStartedAt(oldPod).IsZero()) {
+		return
+	}
+	_, span := tracing.Tracer().Start(ctx, "agent.start", attrs, trace.WithTimestamp(scheduledAt))
+	span.End(trace.WithTimestamp(startedAt))
+}
+
+// podScheduledAt returns when the pod was scheduled onto a node, or the zero
+// time if it hasn't been.
+func podScheduledAt(pod *corev1.Pod) time.Time {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionTrue {
+			return cond.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// agentStartedAt returns when the agent container started, or the zero time if
+// it hasn't.
+func agentStartedAt(pod *corev1.Pod) time.Time {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != AgentContainerName {
+			continue
+		}
+		switch {
+		case status.State.Running != nil:
+			return status.State.Running.StartedAt.Time
+		case status.State.Terminated != nil:
+			return status.State.Terminated.StartedAt.Time
+		}
+	}
+	return time.Time{}
+}
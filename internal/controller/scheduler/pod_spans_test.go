@@ -0,0 +1,85 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/tracing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodSpanRecorder(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ctx, jobSpan := tracing.Tracer().Start(context.Background(), "job")
+	kjob := &batchv1.Job{}
+	if err := tracing.InjectIntoJob(ctx, kjob, ""); err != nil {
+		t.Fatalf("tracing.InjectIntoJob() error = %v", err)
+	}
+	jobSpan.End()
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	pending := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "buildkite-abc",
+			Annotations:       map[string]string{config.TraceParentAnnotation: kjob.Annotations[config.TraceParentAnnotation]},
+			CreationTimestamp: metav1.NewTime(start),
+		},
+	}
+	scheduled := pending.DeepCopy()
+	scheduled.Status.Conditions = []corev1.PodCondition{{
+		Type:               corev1.PodScheduled,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(start.Add(2 * time.Second)),
+	}}
+	running := scheduled.DeepCopy()
+	running.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name: AgentContainerName,
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{
+			StartedAt: metav1.NewTime(start.Add(5 * time.Second)),
+		}},
+	}}
+
+	r := NewPodSpanRecorder(zaptest.NewLogger(t))
+	r.resourceEventHandlerCtx = context.Background()
+	r.OnUpdate(pending, scheduled)
+	r.OnUpdate(scheduled, running)
+	r.OnUpdate(running, running) // no new spans
+
+	wants := []struct {
+		name       string
+		start, end time.Time
+	}{
+		{name: "job"},
+		{name: "pod.scheduled", start: start, end: start.Add(2 * time.Second)},
+		{name: "agent.start", start: start.Add(2 * time.Second), end: start.Add(5 * time.Second)},
+	}
+	ended := spans.Ended()
+	if len(ended) != len(wants) {
+		t.Fatalf("len(spans.Ended()) = %d, want %d", len(ended), len(wants))
+	}
+	for i, want := range wants[1:] {
+		got := ended[i+1]
+		if got.Name() != want.name {
+			t.Errorf("span %d name = %q, want %q", i+1, got.Name(), want.name)
+		}
+		if got.Parent().SpanID() != jobSpan.SpanContext().SpanID() {
+			t.Errorf("%s parent = %v, want the job span %v", got.Name(), got.Parent().SpanID(), jobSpan.SpanContext().SpanID())
+		}
+		if !got.StartTime().Equal(want.start) || !got.EndTime().Equal(want.end) {
+			t.Errorf("%s = [%v, %v], want [%v, %v]", got.Name(), got.StartTime(), got.EndTime(), want.start, want.end)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/agenttags"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+)
+
+// firstCommandContainerName is the name of the first command container, which
+// Build numbers from 0.
+const firstCommandContainerName = "container-0"
+
+// podStartupWatcher measures how long each phase of a pod's startup took, so
+// that slow starts can be attributed to autoscaling, image pulls, or git. As
+// each phase finishes, it observes a histogram:
+//   - scheduled: from the Kubernetes job's creation until the pod was scheduled
+//     onto a node,
+//   - each init container (copy-agent, imagecheck-*, and others): from then
+//     until the init container finished,
+//   - checkout: from the last init container finishing until the checkout
+//     container finished, and
+//   - command started: from the last init container finishing until the first
+//     command container started.
+//
+// (Traced jobs also get spans for the pod's startup; see podSpanRecorder.)
+type podStartupWatcher struct {
+	logger *zap.Logger
+	jobs   batchlisters.JobLister
+
+	// Whether to label the histograms with the pipeline.
+	pipelineLabel bool
+}
+
+// NewPodStartupWatcher creates a podStartupWatcher.
+func NewPodStartupWatcher(logger *zap.Logger, cfg *config.Config) *podStartupWatcher {
+	return &podStartupWatcher{
+		logger:        logger,
+		pipelineLabel: cfg.StartupMetricsPipelineLabel,
+	}
+}
+
+// RegisterInformer registers the watcher to listen for pod events. The job
+// lister is used to find when each pod's job was created.
+func (w *podStartupWatcher) RegisterInformer(ctx context.Context, factory informers.SharedInformerFactory) error {
+	w.jobs = factory.Batch().V1().Jobs().Lister()
+	informer := factory.Core().V1().Pods().Informer()
+	if _, err := informer.AddEventHandler(w); err != nil {
+		return err
+	}
+	go factory.Start(ctx.Done())
+	return nil
+}
+
+// OnAdd records phases for pods that had already progressed when first seen,
+// except for those that existed before the controller started (which were
+// probably recorded by the previous controller).
+func (w *podStartupWatcher) OnAdd(obj any, isInInitialList bool) {
+	if isInInitialList {
+		return
+	}
+	w.recordPhases(nil, obj.(*corev1.Pod))
+}
+
+func (w *podStartupWatcher) OnUpdate(oldObj, newObj any) {
+	w.recordPhases(oldObj.(*corev1.Pod), newObj.(*corev1.Pod))
+}
+
+// ignored
+func (w *podStartupWatcher) OnDelete(obj any) {}
+
+// recordPhases records the startup phases the pod completed between oldPod
+// (which may be nil, if the pod is new) and newPod.
+func (w *podStartupWatcher) recordPhases(oldPod, newPod *corev1.Pod) {
+	if oldPod == nil {
+		oldPod = &corev1.Pod{}
+	}
+	scheduledAt := podScheduledAt(newPod)
+	if scheduledAt.IsZero() {
+		return
+	}
+
+	queue := maps.Collect(agenttags.ScanLabels(newPod.Labels))["queue"]
+	var pipeline string
+	if w.pipelineLabel {
+		pipeline = newPod.Annotations[config.PipelineSlugAnnotation]
+	}
+
+	if podScheduledAt(oldPod).IsZero() {
+		createdAt := w.jobCreatedAt(newPod)
+		podStartupScheduledHistogram.WithLabelValues(queue, pipeline).Observe(scheduledAt.Sub(createdAt).Seconds())
+	}
+
+	oldFinished := initContainersFinishedAt(oldPod)
+	for name, finishedAt := range initContainersFinishedAt(newPod) {
+		if _, seen := oldFinished[name]; seen {
+			continue
+		}
+		podStartupInitContainerHistogram.WithLabelValues(queue, pipeline, initContainerKind(name)).Observe(finishedAt.Sub(scheduledAt).Seconds())
+	}
+
+	// The checkout and command containers start once the init containers are
+	// done.
+	if initDoneAt, done := initContainersDoneAt(newPod, scheduledAt); done {
+		oldStatuses, newStatuses := containerStatuses(oldPod), containerStatuses(newPod)
+		if finished := successfullyFinishedAt(newStatuses[CheckoutContainerName]); !finished.IsZero() && successfullyFinishedAt(oldStatuses[CheckoutContainerName]).IsZero() {
+			podStartupCheckoutHistogram.WithLabelValues(queue, pipeline).Observe(finished.Sub(initDoneAt).Seconds())
+		}
+		if started := startedAt(newStatuses[firstCommandContainerName]); !started.IsZero() && startedAt(oldStatuses[firstCommandContainerName]).IsZero() {
+			podStartupCommandStartedHistogram.WithLabelValues(queue, pipeline).Observe(started.Sub(initDoneAt).Seconds())
+		}
+	}
+}
+
+// jobCreatedAt returns when the pod's Kubernetes job was created, or failing
+// that, when the pod was created.
+func (w *podStartupWatcher) jobCreatedAt(pod *corev1.Pod) time.Time {
+	if w.jobs != nil {
+		if kjob, err := w.jobs.Jobs(pod.Namespace).Get(pod.Labels["job-name"]); err == nil {
+			return kjob.CreationTimestamp.Time
+		}
+	}
+	return pod.CreationTimestamp.Time
+}
+
+// initContainerKind returns the label for an init container's histogram,
+// collapsing the numbered image checks and init containers added by podSpec
+// patches to keep the number of series bounded.
+func initContainerKind(name string) string {
+	switch {
+	case name == CopyAgentContainerName:
+		return name
+	case strings.HasPrefix(name, ImageCheckContainerNamePrefix):
+		return "imagecheck"
+	default:
+		return "other"
+	}
+}
+
+// initContainersFinishedAt returns when each init container of the pod that
+// has finished successfully did so.
+func initContainersFinishedAt(pod *corev1.Pod) map[string]time.Time {
+	finished := make(map[string]time.Time)
+	for _, status := range pod.Status.InitContainerStatuses {
+		if at := successfullyFinishedAt(status); !at.IsZero() {
+			finished[status.Name] = at
+		}
+	}
+	return finished
+}
+
+// initContainersDoneAt returns when the last of the pod's init containers
+// finished successfully (or scheduledAt, if it has none), and whether they all
+// have.
+func initContainersDoneAt(pod *corev1.Pod, scheduledAt time.Time) (time.Time, bool) {
+	doneAt := scheduledAt
+	for _, status := range pod.Status.InitContainerStatuses {
+		at := successfullyFinishedAt(status)
+		if at.IsZero() {
+			return time.Time{}, false
+		}
+		if at.After(doneAt) {
+			doneAt = at
+		}
+	}
+	return doneAt, true
+}
+
+func containerStatuses(pod *corev1.Pod) map[string]corev1.ContainerStatus {
+	statuses := make(map[string]corev1.ContainerStatus)
+	for _, status := range pod.Status.ContainerStatuses {
+		statuses[status.Name] = status
+	}
+	return statuses
+}
+
+// successfullyFinishedAt returns when the container finished, or the zero time
+// if it hasn't finished or it failed.
+func successfullyFinishedAt(status corev1.ContainerStatus) time.Time {
+	if term := status.State.Terminated; term != nil && term.ExitCode == 0 {
+		return term.FinishedAt.Time
+	}
+	return time.Time{}
+}
+
+// startedAt returns when the container started, or the zero time if it
+// hasn't.
+func startedAt(status corev1.ContainerStatus) time.Time {
+	switch {
+	case status.State.Running != nil:
+		return status.State.Running.StartedAt.Time
+	case status.State.Terminated != nil:
+		return status.State.Terminated.StartedAt.Time
+	}
+	return time.Time{}
+}
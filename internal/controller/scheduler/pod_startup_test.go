@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap/zaptest"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodStartupWatcher(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	pending := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "buildkite-abc",
+			Labels:            map[string]string{"tag.buildkite.com/queue": "pod-startup-test"},
+			CreationTimestamp: metav1.NewTime(start),
+		},
+	}
+	scheduled := pending.DeepCopy()
+	scheduled.Status.Conditions = []corev1.PodCondition{{
+		Type:               corev1.PodScheduled,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(start.Add(2 * time.Second)),
+	}}
+	scheduled.Status.InitContainerStatuses = []corev1.ContainerStatus{
+		{Name: CopyAgentContainerName},
+		{Name: ImageCheckContainerNamePrefix + "0"},
+	}
+	initDone := scheduled.DeepCopy()
+	initDone.Status.InitContainerStatuses = []corev1.ContainerStatus{
+		terminated(CopyAgentContainerName, start.Add(3*time.Second)),
+		terminated(ImageCheckContainerNamePrefix+"0", start.Add(4*time.Second)),
+	}
+	running := initDone.DeepCopy()
+	running.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{
+			Name: AgentContainerName,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{
+				StartedAt: metav1.NewTime(start.Add(5 * time.Second)),
+			}},
+		},
+		terminated(CheckoutContainerName, start.Add(10*time.Second)),
+		{
+			Name: firstCommandContainerName,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{
+				StartedAt: metav1.NewTime(start.Add(11 * time.Second)),
+			}},
+		},
+	}
+
+	w := NewPodStartupWatcher(zaptest.NewLogger(t), &config.Config{})
+	w.OnUpdate(pending, scheduled)
+	w.OnUpdate(scheduled, initDone)
+	w.OnUpdate(initDone, running)
+	w.OnUpdate(running, running) // nothing new
+
+	// Without a job lister, the scheduled phase starts when the pod was
+	// created.
+	histograms := []struct {
+		name      string
+		histogram prometheus.Observer
+		want      float64
+	}{
+		{"scheduled", podStartupScheduledHistogram.WithLabelValues("pod-startup-test", ""), 2},
+		{"copy-agent", podStartupInitContainerHistogram.WithLabelValues("pod-startup-test", "", "copy-agent"), 1},
+		{"imagecheck", podStartupInitContainerHistogram.WithLabelValues("pod-startup-test", "", "imagecheck"), 2},
+		{"checkout", podStartupCheckoutHistogram.WithLabelValues("pod-startup-test", ""), 6},
+		{"command started", podStartupCommandStartedHistogram.WithLabelValues("pod-startup-test", ""), 7},
+	}
+	for _, h := range histograms {
+		var m dto.Metric
+		if err := h.histogram.(prometheus.Histogram).Write(&m); err != nil {
+			t.Fatalf("writing %s histogram: %v", h.name, err)
+		}
+		if got := m.GetHistogram().GetSampleCount(); got != 1 {
+			t.Errorf("%s histogram sample count = %d, want 1", h.name, got)
+		}
+		if got := m.GetHistogram().GetSampleSum(); got != h.want {
+			t.Errorf("%s histogram sample sum = %v, want %v", h.name, got, h.want)
+		}
+	}
+}
+
+func terminated(name string, finishedAt time.Time) corev1.ContainerStatus {
+	return corev1.ContainerStatus{
+		Name: name,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			FinishedAt: metav1.NewTime(finishedAt),
+		}},
+	}
+}
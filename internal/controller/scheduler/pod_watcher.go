@@ -23,43 +23,72 @@ import (
 	policyv1 "k8s.io/api/policy/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 type podWatcher struct {
-	logger *zap.Logger
-	k8s    kubernetes.Interface
-	gql    graphql.Client
-	cfg    *config.Config
+	logger   *zap.Logger
+	k8s      kubernetes.Interface
+	tokens   *AgentTokenCache
+	recorder record.EventRecorder
+	gql      graphql.Client
+	cfg      *config.Config
 
 	// ImagePullBackOff detection waits at least this duration after pod
 	// creation before it cancels the job.
 	imagePullBackOffGracePeriod time.Duration
 
+	// Exit status for jobs failed because their pod was disrupted (preempted,
+	// evicted, node lost) before the agent acquired the job.
+	preemptionExitStatus int
+
+	// Exit status for jobs failed because an init container was OOMKilled.
+	oomKilledExitStatus int
+
 	// Jobs that we've failed, cancelled, or were found to be in a terminal
 	// state.
 	ignoredJobsMu sync.RWMutex
 	ignoredJobs   map[uuid.UUID]struct{}
 
+	// Containers that have already been reported as OOMKilled, per job.
+	oomReportedMu sync.Mutex
+	oomReported   map[uuid.UUID]map[string]struct{}
+
 	// Pods being watched for image-related failures (ImagePullBackOff,
 	// ErrImageNeverPull, etc)
 	watchingForImageFailureMu sync.Mutex
 	watchingForImageFailure   map[uuid.UUID]*corev1.Pod
 
-	// The job cancel checkers query the job state every so often.
+	// Pods that are unschedulable are failed after this duration. Zero
+	// disables the check.
+	unschedulableGracePeriod time.Duration
+
+	// Pods being watched for staying unschedulable for too long.
+	watchingUnschedulableMu sync.Mutex
+	watchingUnschedulable   map[uuid.UUID]*corev1.Pod
+
+	// Pods whose jobs were replaced by a fallback job. When they are deleted,
+	// the state for the Buildkite job belongs to the replacement pod.
+	replacedPodsMu sync.Mutex
+	replacedPods   map[types.UID]struct{}
+
+	// The job cancel checker queries the states of jobs with pending pods
+	// every so often.
 	jobCancelCheckerInterval time.Duration
 
-	// Channels that are closed when a cancel checker should stop.
-	cancelCheckerChsMu sync.Mutex
-	cancelCheckerChs   map[uuid.UUID]*onceChan
+	// Jobs with pending pods being checked for cancellation.
+	cancelChecksMu sync.Mutex
+	cancelChecks   map[uuid.UUID]*cancelCheck
 
 	// This is the context passed to RegisterInformer.
 	// It's being stored here (grrrr!) because the k8s ResourceEventHandler
 	// interface doesn't have context args. (Working around an interface in a
 	// library outside of our control is a carve-out from the usual rule.)
-	// The context is needed to ensure job cancel checkers are cleaned up.
+	// The context is needed for the API calls made by the checks.
 	resourceEventHandlerCtx context.Context
 }
 
@@ -76,7 +105,19 @@ type podWatcher struct {
 //   - If a pod is pending, every so often Buildkite will be checked to see if
 //     the corresponding job has been cancelled so that the pod can be evicted
 //     early.
-func NewPodWatcher(logger *zap.Logger, k8s kubernetes.Interface, cfg *config.Config) *podWatcher {
+//   - If a pod is disrupted (preempted, evicted, or its node is lost) before
+//     the agent acquires the job, the job is failed with a dedicated exit
+//     status.
+//   - If a pod stays unschedulable for longer than the unschedulable grace
+//     period (if enabled), the BK Agent REST API will be used to fail the job
+//     and the pod will be evicted.
+//   - If a pod stays unschedulable and fallbacks are configured, its job is
+//     replaced with one using the next fallback's pod spec patch.
+//   - If a container is OOMKilled, the Kubernetes job and the build are
+//     annotated with the container name and memory limit.
+//
+// Its decisions are recorded as events on the pods.
+func NewPodWatcher(logger *zap.Logger, k8s kubernetes.Interface, gql graphql.Client, tokens *AgentTokenCache, recorder record.EventRecorder, cfg *config.Config) *podWatcher {
 	imagePullBackOffGracePeriod := cfg.ImagePullBackOffGracePeriod
 	if imagePullBackOffGracePeriod <= 0 {
 		imagePullBackOffGracePeriod = config.DefaultImagePullBackOffGracePeriod
@@ -85,17 +126,34 @@ func NewPodWatcher(logger *zap.Logger, k8s kubernetes.Interface, cfg *config.Con
 	if jobCancelCheckerInterval <= 0 {
 		jobCancelCheckerInterval = config.DefaultJobCancelCheckerPollInterval
 	}
+	// 0 would mark the job as passed, which is never what we want.
+	preemptionExitStatus := cfg.PreemptionExitStatus
+	if preemptionExitStatus == 0 {
+		preemptionExitStatus = config.DefaultPreemptionExitStatus
+	}
+	oomKilledExitStatus := cfg.OOMKilledExitStatus
+	if oomKilledExitStatus == 0 {
+		oomKilledExitStatus = config.DefaultOOMKilledExitStatus
+	}
 
 	pw := &podWatcher{
 		logger:                      logger,
 		k8s:                         k8s,
-		gql:                         api.NewClient(cfg.BuildkiteToken, cfg.GraphQLEndpoint),
+		tokens:                      tokens,
+		recorder:                    recorder,
+		gql:                         gql,
 		cfg:                         cfg,
 		imagePullBackOffGracePeriod: imagePullBackOffGracePeriod,
+		preemptionExitStatus:        preemptionExitStatus,
+		oomKilledExitStatus:         oomKilledExitStatus,
 		jobCancelCheckerInterval:    jobCancelCheckerInterval,
 		ignoredJobs:                 make(map[uuid.UUID]struct{}),
+		oomReported:                 make(map[uuid.UUID]map[string]struct{}),
 		watchingForImageFailure:     make(map[uuid.UUID]*corev1.Pod),
-		cancelCheckerChs:            make(map[uuid.UUID]*onceChan),
+		unschedulableGracePeriod:    cfg.UnschedulableGracePeriod,
+		watchingUnschedulable:       make(map[uuid.UUID]*corev1.Pod),
+		replacedPods:                make(map[types.UID]struct{}),
+		cancelChecks:                make(map[uuid.UUID]*cancelCheck),
 	}
 	podWatcherIgnoredJobsGaugeFunc = func() int {
 		pw.ignoredJobsMu.RLock()
@@ -103,15 +161,20 @@ func NewPodWatcher(logger *zap.Logger, k8s kubernetes.Interface, cfg *config.Con
 		return len(pw.ignoredJobs)
 	}
 	jobCancelCheckerGaugeFunc = func() int {
-		pw.cancelCheckerChsMu.Lock()
-		defer pw.cancelCheckerChsMu.Unlock()
-		return len(pw.cancelCheckerChs)
+		pw.cancelChecksMu.Lock()
+		defer pw.cancelChecksMu.Unlock()
+		return len(pw.cancelChecks)
 	}
 	watchingForImageFailureGaugeFunc = func() int {
 		pw.watchingForImageFailureMu.Lock()
 		defer pw.watchingForImageFailureMu.Unlock()
 		return len(pw.watchingForImageFailure)
 	}
+	watchingUnschedulableGaugeFunc = func() int {
+		pw.watchingUnschedulableMu.Lock()
+		defer pw.watchingUnschedulableMu.Unlock()
+		return len(pw.watchingUnschedulable)
+	}
 	return pw
 }
 
@@ -124,6 +187,10 @@ func (w *podWatcher) RegisterInformer(ctx context.Context, factory informers.Sha
 	w.resourceEventHandlerCtx = ctx // 😡
 	go factory.Start(ctx.Done())
 	go w.imageFailureChecker(ctx, w.logger)
+	go w.jobCancelChecker(ctx, w.logger)
+	if w.unschedulableGracePeriod > 0 || len(w.cfg.UnschedulableFallbacks) > 0 {
+		go w.unschedulableChecker(ctx, w.logger)
+	}
 	return nil
 }
 
@@ -142,12 +209,18 @@ func (w *podWatcher) OnDelete(previousState any) {
 		return
 	}
 
+	if w.wasReplaced(pod) {
+		return
+	}
+
 	// No need to continue watching for image-related failures or cancellation.
 	w.stopWatchingForImageFailure(jobUUID)
+	w.stopWatchingForUnschedulable(jobUUID)
 	w.stopJobCancelChecker(jobUUID)
 
 	// The pod is gone, so we can stop ignoring it (if it comes back).
 	w.unignoreJob(jobUUID)
+	w.forgetOOMKills(jobUUID)
 }
 
 func (w *podWatcher) OnAdd(currentState any, _ bool) {
@@ -191,11 +264,13 @@ func (w *podWatcher) runChecks(ctx context.Context, pod *corev1.Pod) {
 		// Running: the agent container has started or is about to start, and it
 		//          can handle the cancellation and exit.
 		w.stopJobCancelChecker(jobUUID)
+		w.stopWatchingForUnschedulable(jobUUID)
 
 	default:
 		// Succeeded, Failed: it's already over.
 		// Unknown: probably shouldn't interfere.
 		w.stopWatchingForImageFailure(jobUUID)
+		w.stopWatchingForUnschedulable(jobUUID)
 		w.stopJobCancelChecker(jobUUID)
 	}
 
@@ -204,17 +279,27 @@ func (w *podWatcher) runChecks(ctx context.Context, pod *corev1.Pod) {
 		return
 	}
 
+	// Check for the pod being preempted, evicted, or lost along with its node.
+	// Nothing else needs checking if so.
+	if w.failOnDisruption(ctx, log, jobUUID, pod) {
+		return
+	}
+
+	// Check for containers that ran out of memory.
+	w.reportOOMKills(ctx, log, jobUUID, pod)
+
 	// Check for an init container that failed for any reason.
 	// (Note: users can define their own init containers through podSpec.)
 	w.failOnInitContainerFailure(ctx, log, pod)
 
 	// Check for Buildkite job cancellation while the pod is pending.
 	// Check that the pod doesn't stay in ImagePullBackOff or ErrImageNeverPull
-	// for too long.
+	// for too long, or stay unschedulable for too long.
 	switch pod.Status.Phase {
 	case corev1.PodPending:
 		w.watchForImageFailure(jobUUID, pod)
-		w.startJobCancelChecker(ctx, log, pod.ObjectMeta, jobUUID)
+		w.watchForUnschedulable(jobUUID, pod)
+		w.startJobCancelChecker(log, pod.ObjectMeta, jobUUID)
 
 	case corev1.PodRunning:
 		w.watchForImageFailure(jobUUID, pod)
@@ -325,7 +410,18 @@ func (w *podWatcher) failOnInitContainerFailure(ctx context.Context, log *zap.Lo
 	// probably shouldn't interfere.
 	log.Info("One or more init containers failed. Failing.")
 	message := w.formatInitContainerFails(containerFails)
-	if err := acquireAndFailForObject(ctx, log, w.k8s, w.cfg, pod, message); err != nil {
+	exitStatus := w.cfg.FailureExitStatuses.For(config.FailureInitContainer)
+	var initOOMKills []oomKill
+	for _, k := range oomKilledContainers(pod) {
+		if k.init && containerFails[k.container] != nil {
+			initOOMKills = append(initOOMKills, k)
+		}
+	}
+	if len(initOOMKills) > 0 {
+		message += "\n\n" + formatOOMKills(initOOMKills)
+		exitStatus = w.oomKilledExitStatus
+	}
+	if err := acquireAndFailForObject(ctx, log, w.k8s, w.tokens, w.recorder, w.cfg, pod, message, exitStatus); err != nil {
 		// Maybe the job was cancelled in the meantime?
 		log.Error("Could not fail Buildkite job", zap.Error(err))
 		podWatcherBuildkiteJobFailErrorsCounter.Inc()
@@ -375,21 +471,34 @@ func (w *podWatcher) formatImagePullFailureMessage(statuses []corev1.ContainerSt
 	return "The following images could not be pulled or were unavailable:\n\n" + tw.Render()
 }
 
-func (w *podWatcher) evictPod(ctx context.Context, log *zap.Logger, pod *corev1.Pod, jobUUID uuid.UUID) {
+// evictionReasons describe the reasons for evicting pods (as used in metric
+// labels) in events.
+var evictionReasons = map[string]string{
+	"image_pull_failure": "an image couldn't be pulled",
+	"unschedulable":      "it couldn't be scheduled onto a node",
+	"bk_job_cancelled":   "the Buildkite job was cancelled",
+}
+
+// evictPod evicts the pod, and reports whether it was evicted.
+func (w *podWatcher) evictPod(ctx context.Context, log *zap.Logger, pod *corev1.Pod, jobUUID uuid.UUID, reason string) bool {
 	eviction := &policyv1.Eviction{
 		ObjectMeta: pod.ObjectMeta,
 	}
+	// Because eviction isn't instantaneous, the pod can continue to exist
+	// for a bit, and evicting it adds a DisruptionTarget condition. Record
+	// that we've failed the job before evicting, so that the pod updates
+	// that follow aren't mistaken for a disruption or tried again.
+	w.ignoreJob(jobUUID)
 	if err := w.k8s.PolicyV1().Evictions(w.cfg.Namespace).Evict(ctx, eviction); err != nil {
-		podEvictionErrorsCounter.WithLabelValues(string(kerrors.ReasonForError(err))).Inc()
+		w.unignoreJob(jobUUID)
+		podEvictionErrorsCounter.WithLabelValues(reason, string(kerrors.ReasonForError(err))).Inc()
 		log.Error("Couldn't evict pod", zap.Error(err))
-		return
+		w.recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonPodEvictError, "Couldn't evict the pod after %s: %v", evictionReasons[reason], err)
+		return false
 	}
-	podsEvictedCounter.WithLabelValues("image_pull_failure").Inc()
-
-	// Because eviction isn't instantaneous, the pod can continue to exist
-	// for a bit. Record that we've failed the job to avoid trying to fail
-	// it again.
-	w.ignoreJob(jobUUID)
+	podsEvictedCounter.WithLabelValues(reason).Inc()
+	w.recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonPodEvicted, "Evicted the pod because %s", evictionReasons[reason])
+	return true
 }
 
 func (w *podWatcher) cancelJob(ctx context.Context, log *zap.Logger, pod *corev1.Pod, jobUUID uuid.UUID) {
@@ -400,12 +509,14 @@ func (w *podWatcher) cancelJob(ctx context.Context, log *zap.Logger, pod *corev1
 	if err != nil {
 		log.Warn("Failed to cancel command job", zap.Error(err))
 		podWatcherBuildkiteJobCancelErrorsCounter.Inc()
+		w.recorder.Eventf(pod, corev1.EventTypeWarning, EventReasonJobCancelError, "Couldn't cancel the Buildkite job after an image couldn't be pulled: %v", err)
 		// Could be network problems
 		// Could be in non-cancelable state
 		// Try again later?
 		return
 	}
 	podWatcherBuildkiteJobCancelsCounter.Inc()
+	w.recorder.Event(pod, corev1.EventTypeWarning, EventReasonJobCancelled, "Cancelled the Buildkite job because an image couldn't be pulled")
 
 	// Note that evicting the pod might prevent the agent from logging its
 	// last-gasp "it could be ImagePullBackOff" message.
@@ -488,7 +599,9 @@ func (w *podWatcher) failForImageFailure(ctx context.Context, log *zap.Logger, f
 		// We can acquire it and fail it ourselves.
 		log.Info("One or more job containers are waiting too long for images. Failing.")
 		message := w.formatImagePullFailureMessage(statuses)
-		switch err := acquireAndFailForObject(ctx, log, w.k8s, w.cfg, pod, message); {
+		// The statuses only say the pull is backing off. The events say why.
+		message += "\n" + fetchEvents(ctx, log, w.k8s, pod.Namespace, "Pod", pod.Name, "Failed")
+		switch err := acquireAndFailForObject(ctx, log, w.k8s, w.tokens, w.recorder, w.cfg, pod, message, w.cfg.FailureExitStatuses.For(config.FailureImagePull)); {
 		case errors.Is(err, agentcore.ErrJobAcquisitionRejected):
 			podWatcherBuildkiteJobFailErrorsCounter.Inc()
 			// If the error was because BK rejected the job acquisition, then
@@ -508,7 +621,7 @@ func (w *podWatcher) failForImageFailure(ctx context.Context, log *zap.Logger, f
 		}
 		podWatcherBuildkiteJobFailsCounter.Inc()
 		// Also evict the pod, because it won't die on its own.
-		w.evictPod(ctx, log, pod, jobUUID)
+		w.evictPod(ctx, log, pod, jobUUID, "image_pull_failure")
 
 	case api.JobStatesAccepted, api.JobStatesAssigned, api.JobStatesRunning:
 		// An agent is already doing something with the job - now canceling
@@ -520,6 +633,7 @@ func (w *podWatcher) failForImageFailure(ctx context.Context, log *zap.Logger, f
 		// If the job is in one of these states, we can neither acquire nor
 		// cancel it (now or in the future).
 		log.Debug("Job not acquirable or cancelable")
+		w.recorder.Eventf(pod, corev1.EventTypeNormal, EventReasonJobIgnored, "An image couldn't be pulled, but the Buildkite job is %s, so the pod is left alone", job.State)
 		w.ignoreJob(jobUUID)
 
 	default:
@@ -531,34 +645,48 @@ func (w *podWatcher) failForImageFailure(ctx context.Context, log *zap.Logger, f
 	}
 }
 
-func (w *podWatcher) startJobCancelChecker(ctx context.Context, log *zap.Logger, podMeta metav1.ObjectMeta, jobUUID uuid.UUID) {
-	w.cancelCheckerChsMu.Lock()
-	defer w.cancelCheckerChsMu.Unlock()
+// cancelCheck is a job with a pending pod, being checked for cancellation.
+type cancelCheck struct {
+	log     *zap.Logger
+	podMeta metav1.ObjectMeta
+
+	// done is set once the job is past the point where the checker can act.
+	done bool
+}
+
+func (w *podWatcher) startJobCancelChecker(log *zap.Logger, podMeta metav1.ObjectMeta, jobUUID uuid.UUID) {
+	w.cancelChecksMu.Lock()
+	defer w.cancelChecksMu.Unlock()
 
-	if w.cancelCheckerChs[jobUUID] != nil {
-		// The checker is already running or has run.
+	if w.cancelChecks[jobUUID] != nil {
+		// The job is already being checked, or has been.
 		return
 	}
-	stopCh := make(chan struct{})
-	w.cancelCheckerChs[jobUUID] = &onceChan{ch: stopCh}
-	go w.jobCancelChecker(ctx, stopCh, log, podMeta, jobUUID)
+	log.Debug("Checking job state for cancellation")
+	w.cancelChecks[jobUUID] = &cancelCheck{log: log, podMeta: podMeta}
 }
 
 func (w *podWatcher) stopJobCancelChecker(jobUUID uuid.UUID) {
-	w.cancelCheckerChsMu.Lock()
-	defer w.cancelCheckerChsMu.Unlock()
-	w.cancelCheckerChs[jobUUID].closeOnce()
-	delete(w.cancelCheckerChs, jobUUID)
+	w.cancelChecksMu.Lock()
+	defer w.cancelChecksMu.Unlock()
+	delete(w.cancelChecks, jobUUID)
 }
 
-// jobCancelChecker runs a loop that queries Buildkite for the job state, and
-// evicts the pod if the job becomes cancelled. This should only be used for
-// pods that are still pending: stopCh should be closed as soon as the agent
-// container starts running.
-func (w *podWatcher) jobCancelChecker(ctx context.Context, stopCh <-chan struct{}, log *zap.Logger, podMeta metav1.ObjectMeta, jobUUID uuid.UUID) {
-	log.Debug("Checking job state for cancellation")
-	defer log.Debug("Stopped checking job state for cancellation")
+// finishJobCancelCheck stops checking the job, without forgetting it, so that
+// it isn't checked again for the same pod.
+func (w *podWatcher) finishJobCancelCheck(jobUUID uuid.UUID) {
+	w.cancelChecksMu.Lock()
+	defer w.cancelChecksMu.Unlock()
+	if check := w.cancelChecks[jobUUID]; check != nil {
+		check.done = true
+	}
+}
 
+// jobCancelChecker runs a loop that queries Buildkite for the states of all
+// jobs with pending pods, in batches, and evicts the pods of jobs that become
+// cancelled. Jobs are only checked while their pods are pending: once the
+// agent container is running, it can handle cancellation itself.
+func (w *podWatcher) jobCancelChecker(ctx context.Context, log *zap.Logger) {
 	ticker := time.NewTicker(w.jobCancelCheckerInterval)
 	defer ticker.Stop()
 
@@ -566,46 +694,67 @@ func (w *podWatcher) jobCancelChecker(ctx context.Context, stopCh <-chan struct{
 		select {
 		case <-ctx.Done():
 			return
+		case <-ticker.C:
+			w.checkJobsForCancellation(ctx, log)
+		}
+	}
+}
 
-		case <-stopCh:
-			return
+func (w *podWatcher) checkJobsForCancellation(ctx context.Context, log *zap.Logger) {
+	w.cancelChecksMu.Lock()
+	checks := make(map[string]cancelCheck, len(w.cancelChecks))
+	uuids := make([]string, 0, len(w.cancelChecks))
+	for jobUUID, check := range w.cancelChecks {
+		if check.done {
+			continue
+		}
+		checks[jobUUID.String()] = *check
+		uuids = append(uuids, jobUUID.String())
+	}
+	w.cancelChecksMu.Unlock()
 
-		case <-ticker.C:
-			resp, err := api.GetCommandJob(ctx, w.gql, jobUUID.String())
-			if err != nil {
-				// *shrug* Check again soon.
-				continue
-			}
-			job, ok := resp.Job.(*api.GetCommandJobJobJobTypeCommand)
-			if !ok {
-				log.Warn("Job was not a command job")
-				continue
-			}
-			log := log.With(zap.String("job_state", string(job.State)))
-
-			switch job.State {
-			case api.JobStatesCanceled, api.JobStatesCanceling:
-				log.Info("Evicting pending pod for cancelled job")
-				eviction := &policyv1.Eviction{ObjectMeta: podMeta}
-				if err := w.k8s.PolicyV1().Evictions(w.cfg.Namespace).Evict(ctx, eviction); err != nil {
-					log.Error("Couldn't evict pod", zap.Error(err))
-					podEvictionErrorsCounter.WithLabelValues("bk_job_cancelled", string(kerrors.ReasonForError(err))).Inc()
-					continue
-				}
-				podsEvictedCounter.WithLabelValues("bk_job_cancelled").Inc()
-				return
-
-			case api.JobStatesScheduled:
-				// The pod can continue waiting for resources / initializing.
-
-			default:
-				// Assigned, Accepted, Running: Too late. Let the agent within
-				// the pod handle cancellation. Finished, etc: it's already over.
-				// If it's any other state, we probably shouldn't interfere.
-				log.Debug("Ending job cancel checker due to job state")
-				return
-			}
+	if len(uuids) == 0 {
+		return
+	}
+
+	states, err := api.GetCommandJobStates(ctx, w.gql, uuids)
+	if err != nil {
+		// *shrug* Check the missing ones again soon.
+		log.Debug("Couldn't query all job states for cancellation", zap.Error(err))
+	}
+	for jobUUIDStr, state := range states {
+		check, ok := checks[jobUUIDStr]
+		if !ok {
+			continue
 		}
+		jobUUID, err := uuid.Parse(jobUUIDStr)
+		if err != nil {
+			continue
+		}
+		w.handleJobCancelCheck(ctx, check.log.With(zap.String("job_state", string(state))), check.podMeta, jobUUID, state)
+	}
+}
+
+// handleJobCancelCheck evicts the pending pod if the job has been cancelled.
+func (w *podWatcher) handleJobCancelCheck(ctx context.Context, log *zap.Logger, podMeta metav1.ObjectMeta, jobUUID uuid.UUID, state api.JobStates) {
+	switch state {
+	case api.JobStatesCanceled, api.JobStatesCanceling:
+		log.Info("Evicting pending pod for cancelled job")
+		// The eviction isn't a disruption worth reporting, and evictPod
+		// ignores the job from then on.
+		if w.evictPod(ctx, log, &corev1.Pod{ObjectMeta: podMeta}, jobUUID, "bk_job_cancelled") {
+			w.finishJobCancelCheck(jobUUID)
+		}
+
+	case api.JobStatesScheduled:
+		// The pod can continue waiting for resources / initializing.
+
+	default:
+		// Assigned, Accepted, Running: Too late. Let the agent within
+		// the pod handle cancellation. Finished, etc: it's already over.
+		// If it's any other state, we probably shouldn't interfere.
+		log.Debug("Stopped checking job state for cancellation")
+		w.finishJobCancelCheck(jobUUID)
 	}
 }
 
@@ -640,20 +789,6 @@ func (w *podWatcher) stopWatchingForImageFailure(jobUUID uuid.UUID) {
 	delete(w.watchingForImageFailure, jobUUID)
 }
 
-// onceChan stores a channel and a [sync.Once] to be used for closing the
-// channel at most once.
-type onceChan struct {
-	once sync.Once
-	ch   chan struct{}
-}
-
-func (oc *onceChan) closeOnce() {
-	if oc == nil {
-		return
-	}
-	oc.once.Do(func() { close(oc.ch) })
-}
-
 // All container-\d containers will have the agent installed as their PID 1.
 // Therefore, their lifecycle is well monitored in our backend, allowing us to terminate them if they fail to start.
 //
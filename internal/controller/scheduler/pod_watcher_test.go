@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"go.uber.org/zap/zaptest"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestNewPodWatcher_ExitStatuses(t *testing.T) {
+	tests := []struct {
+		name           string
+		cfg            config.Config
+		wantPreemption int
+		wantOOMKilled  int
+	}{
+		{
+			name:           "defaults",
+			wantPreemption: config.DefaultPreemptionExitStatus,
+			wantOOMKilled:  config.DefaultOOMKilledExitStatus,
+		},
+		{
+			name:           "flags",
+			cfg:            config.Config{PreemptionExitStatus: 70, OOMKilledExitStatus: 71},
+			wantPreemption: 70,
+			wantOOMKilled:  71,
+		},
+		{
+			// Preemptions and OOMKills aren't kinds of failure-exit-statuses.
+			name: "failure-exit-statuses",
+			cfg: config.Config{
+				FailureExitStatuses: config.FailureExitStatuses{config.FailureInitContainer: 80},
+			},
+			wantPreemption: config.DefaultPreemptionExitStatus,
+			wantOOMKilled:  config.DefaultOOMKilledExitStatus,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := NewPodWatcher(zaptest.NewLogger(t), fake.NewClientset(), nil, nil, record.NewFakeRecorder(10), &test.cfg)
+			if w.preemptionExitStatus != test.wantPreemption {
+				t.Errorf("preemptionExitStatus = %d, want %d", w.preemptionExitStatus, test.wantPreemption)
+			}
+			if w.oomKilledExitStatus != test.wantOOMKilled {
+				t.Errorf("oomKilledExitStatus = %d, want %d", w.oomKilledExitStatus, test.wantOOMKilled)
+			}
+		})
+	}
+}
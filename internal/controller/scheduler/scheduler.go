@@ -17,8 +17,10 @@ import (
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/agenttags"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/model"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/tracing"
 	"github.com/buildkite/agent-stack-k8s/v2/internal/version"
 
+	"github.com/buildkite/agent/v3/agent"
 	"github.com/buildkite/agent/v3/clicommand"
 
 	"github.com/distribution/reference"
@@ -31,6 +33,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/ptr"
 )
 
@@ -44,6 +47,8 @@ const (
 
 var errK8sPluginProhibited = errors.New("the kubernetes plugin is prohibited by this controller, but was configured on this job")
 
+var errSecretNotAllowed = errors.New("secret is not allowed by this controller's pipeline-secrets config")
+
 var (
 	commandContainerCommand = []string{"/workspace/tini-static"}
 	commandContainerArgs    = []string{"--", "/workspace/buildkite-agent", "bootstrap"}
@@ -67,6 +72,16 @@ type Config struct {
 	PodSpecPatch                  *corev1.PodSpec
 	ProhibitK8sPlugin             bool
 	AllowPodSpecPatchUnsafeCmdMod bool
+	PriorityClasses               []config.PriorityClassMapping
+	AdmissionMode                 config.AdmissionMode
+	KueueQueueName                string
+	JobEnv                        *config.JobEnvParams
+	PipelineSecrets               config.PipelineSecrets
+	FailureExitStatuses           config.FailureExitStatuses
+	SidecarLogTailLines           int
+	AgentTokenSecrets             []config.AgentTokenSecretMapping
+	AgentTokens                   *AgentTokenCache
+	Recorder                      record.EventRecorder
 }
 
 func New(logger *zap.Logger, client kubernetes.Interface, cfg Config) *worker {
@@ -93,6 +108,10 @@ type KubernetesPlugin struct {
 	CommandParams            *config.CommandParams  `json:"commandParams,omitempty"`
 	SidecarParams            *config.SidecarParams  `json:"sidecarParams,omitempty"`
 	JobActiveDeadlineSeconds int                    `json:"jobActiveDeadlineSeconds,omitempty"`
+
+	// Secrets maps env var names to keys of Kubernetes secrets, which are set
+	// on command containers and redacted from the job log.
+	Secrets map[string]corev1.SecretKeySelector `json:"secrets,omitempty"`
 }
 
 type worker struct {
@@ -109,7 +128,7 @@ func (w *worker) Handle(ctx context.Context, job model.Job) error {
 	inputs, err := w.ParseJob(job.CommandJob)
 	if err != nil {
 		logger.Warn("Job parsing failed, failing job", zap.Error(err))
-		return w.failJob(ctx, inputs, fmt.Sprintf("agent-stack-k8s failed to parse the job: %v", err))
+		return w.failJob(ctx, inputs, config.FailureParseError, fmt.Sprintf("agent-stack-k8s failed to parse the job: %v", err))
 	}
 
 	// Default command container using default image.
@@ -126,14 +145,28 @@ func (w *worker) Handle(ctx context.Context, job model.Job) error {
 		podSpec = inputs.k8sPlugin.PodSpec
 	}
 
+	_, buildSpan := tracing.Tracer().Start(ctx, "worker.Build")
 	kjob, err := w.Build(podSpec, false, inputs)
+	tracing.End(buildSpan, err)
 	if err != nil {
 		logger.Warn("Job definition error detected, failing job", zap.Error(err))
-		return w.failJob(ctx, inputs, fmt.Sprintf("agent-stack-k8s failed to build a podSpec for the job: %v", err))
+		return w.failJob(ctx, inputs, config.FailureSpecBuildError, fmt.Sprintf("agent-stack-k8s failed to build a podSpec for the job: %v", err))
+	}
+
+	// Pass the trace context on, so the pod and the agent can continue it.
+	var traceContextEncoding string
+	if w.cfg.AgentConfig != nil {
+		traceContextEncoding = ptr.Deref(w.cfg.AgentConfig.TraceContextEncoding, "")
+	}
+	if err := tracing.InjectIntoJob(ctx, kjob, traceContextEncoding); err != nil {
+		logger.Warn("Couldn't add trace context to job", zap.Error(err))
 	}
 
 	jobCreateCallsCounter.Inc()
-	if err := w.createJob(ctx, kjob); err != nil {
+	createCtx, createSpan := tracing.Tracer().Start(ctx, "worker.createJob")
+	created, err := w.createJob(createCtx, kjob)
+	tracing.End(createSpan, err)
+	if err != nil {
 		jobCreateErrorCounter.WithLabelValues(string(kerrors.ReasonForError(err))).Inc()
 
 		switch {
@@ -143,23 +176,24 @@ func (w *worker) Handle(ctx context.Context, job model.Job) error {
 
 		case kerrors.IsInvalid(err):
 			logger.Warn("Job invalid, failing job on Buildkite", zap.Error(err))
-			return w.failJob(ctx, inputs, fmt.Sprintf("Kubernetes rejected the podSpec built by agent-stack-k8s: %v", err))
+			return w.failJob(ctx, inputs, config.FailureKubernetesInvalid, fmt.Sprintf("Kubernetes rejected the podSpec built by agent-stack-k8s: %v", err))
 
 		default:
 			return err
 		}
 	}
 	jobCreateSuccessCounter.Inc()
+	w.cfg.Recorder.Eventf(created, corev1.EventTypeNormal, EventReasonJobCreated, "Created for Buildkite job %s", job.Uuid)
 	jobEndToEndDurationHistogram.Observe(time.Since(job.QueriedAt).Seconds())
 	return nil
 }
 
-func (w *worker) createJob(ctx context.Context, kjob *batchv1.Job) error {
-	_, err := w.client.BatchV1().Jobs(w.cfg.Namespace).Create(ctx, kjob, metav1.CreateOptions{})
+func (w *worker) createJob(ctx context.Context, kjob *batchv1.Job) (*batchv1.Job, error) {
+	created, err := w.client.BatchV1().Jobs(w.cfg.Namespace).Create(ctx, kjob, metav1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to create job: %w", err)
+		return nil, fmt.Errorf("failed to create job: %w", err)
 	}
-	return nil
+	return created, nil
 }
 
 // buildInputs contains the relevant components of a CommandJob needed for Build.
@@ -169,6 +203,7 @@ type buildInputs struct {
 	command         string
 	agentQueryRules []string
 	priority        int
+	pipelineSlug    string
 
 	// Involves some parsing of the job env / plugins map
 	envMap       map[string]string
@@ -182,6 +217,7 @@ func (w *worker) ParseJob(job *api.CommandJob) (buildInputs, error) {
 		command:         job.Command,
 		agentQueryRules: job.AgentQueryRules,
 		priority:        job.Priority.Number,
+		pipelineSlug:    job.Pipeline.Slug,
 		envMap:          make(map[string]string),
 	}
 
@@ -269,13 +305,26 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 		kjob.Annotations[config.JobURLAnnotation] = jobURL
 	}
 	kjob.Annotations[config.PriorityAnnotation] = strconv.Itoa(inputs.priority)
+	kjob.Annotations[config.ControllerVersionAnnotation] = version.Version()
+	if pipelineSlug := inputs.envMap["BUILDKITE_PIPELINE_SLUG"]; pipelineSlug != "" {
+		kjob.Annotations[config.PipelineSlugAnnotation] = pipelineSlug
+	}
 
 	// Prevent k8s cluster autoscaler from terminating the job before it finishes to scale down cluster
 	kjob.Annotations["cluster-autoscaler.kubernetes.io/safe-to-evict"] = "false"
 
+	// With an admission mode, the job waits suspended until it is admitted by
+	// Kueue or the built-in admission loop.
+	if w.cfg.AdmissionMode == config.AdmissionModeKueue {
+		kjob.Labels[config.KueueQueueNameLabel] = w.cfg.KueueQueueName
+	}
+
 	kjob.Spec.Template.Labels = kjob.Labels
 	kjob.Spec.Template.Annotations = kjob.Annotations
 	kjob.Spec.BackoffLimit = ptr.To[int32](0)
+	if w.cfg.AdmissionMode != config.AdmissionModeNone {
+		kjob.Spec.Suspend = ptr.To(true)
+	}
 	kjob.Spec.Template.Spec.TerminationGracePeriodSeconds = ptr.To[int64](defaultTermGracePeriodSeconds)
 
 	// Shared among all containers that run buildkite-agent start or bootstrap.
@@ -292,7 +341,7 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 			Name: agentTokenKey,
 			ValueFrom: &corev1.EnvVarSource{
 				SecretKeyRef: &corev1.SecretKeySelector{
-					LocalObjectReference: corev1.LocalObjectReference{Name: w.cfg.AgentTokenSecretName},
+					LocalObjectReference: corev1.LocalObjectReference{Name: w.agentTokenSecretName(inputs)},
 					Key:                  agentTokenKey,
 				},
 			},
@@ -301,12 +350,6 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 			Name:  "BUILDKITE_AGENT_ACQUIRE_JOB",
 			Value: inputs.uuid,
 		},
-		{
-			// Suppress the ignored env vars message for now.
-			// TODO: remove this when k8s exposes more agent config, e.g. PR#391
-			Name:  "BUILDKITE_IGNORED_ENV",
-			Value: "",
-		},
 	}
 	if len(inputs.otherPlugins) > 0 {
 		otherPluginsJSON, err := json.Marshal(inputs.otherPlugins)
@@ -318,19 +361,25 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 			Value: string(otherPluginsJSON),
 		})
 	}
-	// TODO: revisit the next loop, since it sets **all env vars from the job**
-	// on the checkout and command containers, bypassing the "protected env
-	// vars" agent logic (that supplies many vars from configuration instead).
-	for k, v := range inputs.envMap {
-		switch k {
-		case "BUILDKITE_COMMAND", "BUILDKITE_ARTIFACT_PATHS", "BUILDKITE_PLUGINS": // noop
-		default:
-			env = append(env, corev1.EnvVar{Name: k, Value: v})
-		}
+	jobEnv, sidecarEnv, ignoredEnv := w.splitJobEnv(inputs.envMap)
+	env = append(env, jobEnv...)
+	// The bootstrap warns about protected vars the job tried to set, as it
+	// would if the agent ran the job itself.
+	env = append(env, corev1.EnvVar{
+		Name:  "BUILDKITE_IGNORED_ENV",
+		Value: strings.Join(ignoredEnv, ","),
+	})
+
+	secretEnv, err := w.secretEnv(inputs)
+	if err != nil {
+		return nil, err
 	}
 
 	redactedVars := append([]string(nil), clicommand.RedactedVars.Value.Value()...)
 	redactedVars = append(redactedVars, w.cfg.AdditionalRedactedVars...)
+	for _, ev := range secretEnv {
+		redactedVars = append(redactedVars, ev.Name)
+	}
 	env = append(env, corev1.EnvVar{
 		Name:  clicommand.RedactedVars.EnvVar,
 		Value: strings.Join(redactedVars, ","),
@@ -359,6 +408,27 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 		volumeMounts = append(volumeMounts, inputs.k8sPlugin.ExtraVolumeMounts...)
 	}
 
+	// Command containers also get the sidecar logs captured by the
+	// completions watcher, if there are sidecars to capture logs from.
+	commandVolumeMounts := volumeMounts
+	if w.cfg.SidecarLogTailLines > 0 && inputs.k8sPlugin != nil && len(inputs.k8sPlugin.Sidecars) > 0 {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: sidecarLogsVolumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: sidecarLogsConfigMapName(kjob.Name)},
+					// It doesn't exist until a sidecar fails.
+					Optional: ptr.To(true),
+				},
+			},
+		})
+		commandVolumeMounts = append(slices.Clone(volumeMounts), corev1.VolumeMount{
+			Name:      sidecarLogsVolumeName,
+			MountPath: sidecarLogsPath,
+			ReadOnly:  true,
+		})
+	}
+
 	systemContainerCount := 0
 	if !skipCheckout {
 		systemContainerCount = 1
@@ -407,6 +477,7 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 			Value: "/workspace/sockets",
 		},
 	}...)
+	containerEnv = append(containerEnv, secretEnv...)
 
 	for i, c := range podSpec.Containers {
 		// Default to the command from the pipeline step
@@ -454,7 +525,7 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 			c.WorkingDir = "/workspace"
 		}
 
-		c.VolumeMounts = append(c.VolumeMounts, volumeMounts...)
+		c.VolumeMounts = append(c.VolumeMounts, commandVolumeMounts...)
 		if inputs.k8sPlugin != nil {
 			c.EnvFrom = append(c.EnvFrom, inputs.k8sPlugin.GitEnvFrom...)
 		}
@@ -471,7 +542,7 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 			Command:      commandContainerCommand,
 			Args:         commandContainerArgs,
 			WorkingDir:   "/workspace",
-			VolumeMounts: volumeMounts,
+			VolumeMounts: commandVolumeMounts,
 			Env: append(containerEnv,
 				corev1.EnvVar{
 					Name:  "BUILDKITE_COMMAND",
@@ -498,6 +569,7 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 				c.Name = fmt.Sprintf("%s-%d", "sidecar", i)
 			}
 			c.VolumeMounts = append(c.VolumeMounts, volumeMounts...)
+			c.Env = append(c.Env, sidecarEnv...)
 			w.cfg.DefaultSidecarParams.ApplyTo(&c)
 			inputs.k8sPlugin.SidecarParams.ApplyTo(&c)
 			c.EnvFrom = append(c.EnvFrom, inputs.k8sPlugin.GitEnvFrom...)
@@ -580,6 +652,8 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 	// Only attempt the job once.
 	podSpec.RestartPolicy = corev1.RestartPolicyNever
 
+	w.applyPriorityClass(podSpec, inputs.priority)
+
 	// Allow podSpec to be overridden by the controller config and the k8s plugin.
 	// Patch from the controller config is applied first.
 	if w.cfg.PodSpecPatch != nil {
@@ -839,6 +913,8 @@ func (w *worker) Build(podSpec *corev1.PodSpec, skipCheckout bool, inputs buildI
 	// Only attempt the job once.
 	podSpec.RestartPolicy = corev1.RestartPolicyNever
 
+	w.applyPriorityClass(podSpec, inputs.priority)
+
 	// Allow podSpec to be overridden by the agent configuration and the k8s plugin
 
 	// Patch from the agent is applied first
@@ -989,6 +1065,84 @@ func PatchPodSpec(original *corev1.PodSpec, patch *corev1.PodSpec, cmdParams *co
 	return &patchedSpec, nil
 }
 
+// splitJobEnv sorts the job env into vars to set on the agent, checkout, and
+// command containers, vars to also set on sidecars, and the names of protected
+// vars that were ignored. Protected vars can only be set by agent
+// configuration, which is supplied separately.
+func (w *worker) splitJobEnv(envMap map[string]string) (env, sidecarEnv []corev1.EnvVar, ignored []string) {
+	for _, k := range slices.Sorted(maps.Keys(envMap)) {
+		switch k {
+		case "BUILDKITE_COMMAND", "BUILDKITE_ARTIFACT_PATHS", "BUILDKITE_PLUGINS":
+			// Passed to containers separately.
+			continue
+		}
+		if _, protected := agent.ProtectedEnv[k]; protected {
+			ignored = append(ignored, k)
+			continue
+		}
+		if !w.cfg.JobEnv.Propagate(k) {
+			continue
+		}
+		ev := corev1.EnvVar{Name: k, Value: envMap[k]}
+		env = append(env, ev)
+		if w.cfg.JobEnv.PropagateToSidecars(k) {
+			sidecarEnv = append(sidecarEnv, ev)
+		}
+	}
+	return env, sidecarEnv, ignored
+}
+
+// agentTokenSecretName returns the name of the agent token secret for the job,
+// based on its agent tags.
+func (w *worker) agentTokenSecretName(inputs buildInputs) string {
+	// Invalid tags are logged when converting them to labels.
+	tags, _ := agenttags.TagMapFromTags(inputs.agentQueryRules)
+	return config.AgentTokenSecretFor(w.cfg.AgentTokenSecrets, w.cfg.AgentTokenSecretName, tags)
+}
+
+// secretEnv converts the kubernetes plugin's secrets mapping into env vars
+// that reference the secrets, checking each secret against the pipeline's
+// allowlist. The pipeline is identified by the job's pipeline in Buildkite,
+// not by the job env, which the pipeline can set to anything.
+func (w *worker) secretEnv(inputs buildInputs) ([]corev1.EnvVar, error) {
+	if inputs.k8sPlugin == nil || len(inputs.k8sPlugin.Secrets) == 0 {
+		return nil, nil
+	}
+	pipelineSlug := inputs.pipelineSlug
+	if pipelineSlug == "" {
+		return nil, fmt.Errorf("%w: the job's pipeline is unknown", errSecretNotAllowed)
+	}
+
+	var env []corev1.EnvVar
+	for _, name := range slices.Sorted(maps.Keys(inputs.k8sPlugin.Secrets)) {
+		ref := inputs.k8sPlugin.Secrets[name]
+		if ref.Name == "" || ref.Key == "" {
+			return nil, fmt.Errorf("secret for env var %q must have a name and key", name)
+		}
+		if _, protected := agent.ProtectedEnv[name]; protected {
+			return nil, fmt.Errorf("secret for env var %q: %s is a protected variable", name, name)
+		}
+		if !w.cfg.PipelineSecrets.Allows(pipelineSlug, ref.Name) {
+			return nil, fmt.Errorf("%w: pipeline %q may not reference secret %q", errSecretNotAllowed, pipelineSlug, ref.Name)
+		}
+		env = append(env, corev1.EnvVar{
+			Name:      name,
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref.DeepCopy()},
+		})
+	}
+	return env, nil
+}
+
+// applyPriorityClass sets the pod's PriorityClass according to the Buildkite
+// job priority, unless the podSpec already names one. The podSpec patches are
+// applied afterwards, so they can still override it.
+func (w *worker) applyPriorityClass(podSpec *corev1.PodSpec, priority int) {
+	if podSpec.PriorityClassName != "" {
+		return
+	}
+	podSpec.PriorityClassName = config.PriorityClassFor(w.cfg.PriorityClasses, priority)
+}
+
 func (w *worker) createWorkspaceSetupContainer(podSpec *corev1.PodSpec, workspaceVolume *corev1.Volume) corev1.Container {
 	podUser, podGroup := int64(0), int64(0)
 	if podSpec.SecurityContext != nil {
@@ -1210,17 +1364,18 @@ buildkite-agent-entrypoint bootstrap`,
 	return checkoutContainer
 }
 
-// failJob fails the job in Buildkite.
-func (w *worker) failJob(ctx context.Context, inputs buildInputs, message string) error {
+// failJob fails the job in Buildkite, with the exit status configured for the
+// kind of failure.
+func (w *worker) failJob(ctx context.Context, inputs buildInputs, kind, message string) error {
 	// Need to fetch the agent token ourselves.
-	agentToken, err := fetchAgentToken(ctx, w.logger, w.client, w.cfg.Namespace, w.cfg.AgentTokenSecretName)
+	agentToken, err := fetchAgentToken(ctx, w.logger, w.client, w.cfg.AgentTokens, w.cfg.Namespace, w.agentTokenSecretName(inputs))
 	if err != nil {
 		w.logger.Error("fetching agent token from secret", zap.Error(err))
 		return err
 	}
 
 	opts := w.cfg.AgentConfig.ControllerOptions()
-	if err := acquireAndFail(ctx, w.logger, agentToken, inputs.uuid, inputs.agentQueryRules, message, opts...); err != nil {
+	if err := acquireAndFail(ctx, w.logger, agentToken, inputs.uuid, inputs.agentQueryRules, message, w.cfg.FailureExitStatuses.For(kind), opts...); err != nil {
 		w.logger.Error("failed to acquire and fail the job on Buildkite", zap.Error(err))
 		schedulerBuildkiteJobFailErrorsCounter.Inc()
 		return err
@@ -4,10 +4,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"testing"
 
 	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -423,6 +425,50 @@ func TestJobWithNoKubernetesPlugin(t *testing.T) {
 	require.Nil(t, pluginsEnv)
 }
 
+func TestBuildJobEnv(t *testing.T) {
+	t.Parallel()
+
+	pluginsYAML := `- github.com/buildkite-plugins/kubernetes-buildkite-plugin:
+    sidecars:
+    - image: nginx:latest`
+
+	pluginsJSON, err := yaml.YAMLToJSONStrict([]byte(pluginsYAML))
+	require.NoError(t, err)
+
+	job := &api.CommandJob{
+		Uuid:    "abc",
+		Command: "echo hello world",
+		Env: []string{
+			fmt.Sprintf("BUILDKITE_PLUGINS=%s", pluginsJSON),
+			"BUILDKITE_BUILD_PATH=/tmp/somewhere-else",
+			"BUILDKITE_BRANCH=main",
+			"SECRET_THING=hunter2",
+		},
+		AgentQueryRules: []string{"queue=kubernetes"},
+	}
+	worker := New(zaptest.NewLogger(t), nil, Config{
+		Image: "buildkite/agent:latest",
+		JobEnv: &config.JobEnvParams{
+			Deny:     []string{"SECRET_*"},
+			Sidecars: []string{"BUILDKITE_BRANCH"},
+		},
+	})
+	inputs, err := worker.ParseJob(job)
+	require.NoError(t, err)
+	kjob, err := worker.Build(&corev1.PodSpec{}, false, inputs)
+	require.NoError(t, err)
+
+	commandContainer := findContainer(t, kjob.Spec.Template.Spec.Containers, "container-0")
+	require.Equal(t, "main", findEnv(t, commandContainer.Env, "BUILDKITE_BRANCH").Value)
+	require.Nil(t, findEnv(t, commandContainer.Env, "SECRET_THING"))
+	require.Equal(t, "/workspace/build", findEnv(t, commandContainer.Env, "BUILDKITE_BUILD_PATH").Value)
+	require.Equal(t, "BUILDKITE_BUILD_PATH", findEnv(t, commandContainer.Env, "BUILDKITE_IGNORED_ENV").Value)
+
+	sidecar := findContainer(t, kjob.Spec.Template.Spec.Containers, "sidecar-0")
+	require.Equal(t, "main", findEnv(t, sidecar.Env, "BUILDKITE_BRANCH").Value)
+	require.Nil(t, findEnv(t, sidecar.Env, "SECRET_THING"))
+}
+
 func TestBuild(t *testing.T) {
 	t.Parallel()
 
@@ -538,6 +584,68 @@ func TestBuildSkipCheckout(t *testing.T) {
 	}
 }
 
+func TestBuildSidecarLogsVolume(t *testing.T) {
+	t.Parallel()
+
+	pluginsYAML := `- github.com/buildkite-plugins/kubernetes-buildkite-plugin:
+    sidecars:
+      - image: redis:latest`
+
+	pluginsJSON, err := yaml.YAMLToJSONStrict([]byte(pluginsYAML))
+	require.NoError(t, err)
+
+	job := &api.CommandJob{
+		Uuid:            "abc",
+		Command:         "echo hello world",
+		Env:             []string{fmt.Sprintf("BUILDKITE_PLUGINS=%s", pluginsJSON)},
+		AgentQueryRules: []string{"queue=kubernetes"},
+	}
+
+	for _, tailLines := range []int{0, 50} {
+		worker := New(zaptest.NewLogger(t), nil, Config{
+			Namespace:           "buildkite",
+			Image:               "buildkite/agent:latest",
+			SidecarLogTailLines: tailLines,
+		})
+		inputs, err := worker.ParseJob(job)
+		require.NoError(t, err)
+		kjob, err := worker.Build(&corev1.PodSpec{}, false, inputs)
+		require.NoError(t, err)
+
+		var volume *corev1.Volume
+		for _, v := range kjob.Spec.Template.Spec.Volumes {
+			if v.Name == sidecarLogsVolumeName {
+				volume = &v
+			}
+		}
+		mounted := func(c corev1.Container) bool {
+			return slices.ContainsFunc(c.VolumeMounts, func(m corev1.VolumeMount) bool {
+				return m.Name == sidecarLogsVolumeName
+			})
+		}
+		command := findContainer(t, kjob.Spec.Template.Spec.Containers, "container-0")
+		sidecar := findContainer(t, kjob.Spec.Template.Spec.Containers, "sidecar-0")
+
+		if tailLines == 0 {
+			if volume != nil || mounted(command) {
+				t.Errorf("sidecar-log-tail-lines: 0: sidecar logs volume = %v, mounted = %t, want neither", volume, mounted(command))
+			}
+			continue
+		}
+		require.NotNil(t, volume, "sidecar logs volume")
+		require.NotNil(t, volume.ConfigMap, "sidecar logs volume ConfigMap")
+		if got, want := volume.ConfigMap.Name, sidecarLogsConfigMapName(kjob.Name); got != want {
+			t.Errorf("sidecar logs volume ConfigMap = %q, want %q", got, want)
+		}
+		if !mounted(command) {
+			t.Error("sidecar logs volume isn't mounted into the command container")
+		}
+		if mounted(sidecar) {
+			t.Error("sidecar logs volume is mounted into the sidecar, want only command containers")
+		}
+	}
+}
+
 func TestBuildCheckoutEmptyConfigEnv(t *testing.T) {
 	t.Parallel()
 
@@ -623,6 +731,96 @@ func TestProhibitKubernetesPlugin(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestBuildSecrets(t *testing.T) {
+	t.Parallel()
+	pluginsJSON, err := json.Marshal([]map[string]any{
+		{
+			"github.com/buildkite-plugins/kubernetes-buildkite-plugin": KubernetesPlugin{
+				Secrets: map[string]corev1.SecretKeySelector{
+					"DEPLOY_TOKEN": {
+						LocalObjectReference: corev1.LocalObjectReference{Name: "deploy-secrets"},
+						Key:                  "token",
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		pipelineSlug    string
+		pipelineSecrets config.PipelineSecrets
+		wantErr         bool
+	}{
+		{
+			name:            "allowed",
+			pipelineSlug:    "deploy-prod",
+			pipelineSecrets: config.PipelineSecrets{"deploy-*": {"deploy-secrets"}},
+		},
+		{
+			name:            "other pipeline",
+			pipelineSlug:    "deploy-prod",
+			pipelineSecrets: config.PipelineSecrets{"other": {"deploy-secrets"}},
+			wantErr:         true,
+		},
+		{
+			name:         "no allowlist",
+			pipelineSlug: "deploy-prod",
+			wantErr:      true,
+		},
+		{
+			// The job env claims to be deploy-prod, but it can be set to
+			// anything by the pipeline.
+			name:            "env slug is not trusted",
+			pipelineSlug:    "untrusted",
+			pipelineSecrets: config.PipelineSecrets{"deploy-*": {"deploy-secrets"}},
+			wantErr:         true,
+		},
+		{
+			name:            "unknown pipeline",
+			pipelineSecrets: config.PipelineSecrets{"*": {"deploy-secrets"}},
+			wantErr:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			job := &api.CommandJob{
+				Uuid:    "abc",
+				Command: "deploy",
+				Env: []string{
+					fmt.Sprintf("BUILDKITE_PLUGINS=%s", pluginsJSON),
+					"BUILDKITE_PIPELINE_SLUG=deploy-prod",
+				},
+				AgentQueryRules: []string{"queue=kubernetes"},
+				Pipeline:        api.CommandJobPipeline{Slug: test.pipelineSlug},
+			}
+			worker := New(zaptest.NewLogger(t), nil, Config{
+				Image:           "buildkite/agent:latest",
+				PipelineSecrets: test.pipelineSecrets,
+			})
+			inputs, err := worker.ParseJob(job)
+			require.NoError(t, err)
+			kjob, err := worker.Build(&corev1.PodSpec{}, false, inputs)
+			if test.wantErr {
+				require.ErrorIs(t, err, errSecretNotAllowed)
+				return
+			}
+			require.NoError(t, err)
+
+			commandContainer := findContainer(t, kjob.Spec.Template.Spec.Containers, "container-0")
+			tokenEnv := findEnv(t, commandContainer.Env, "DEPLOY_TOKEN")
+			require.NotNil(t, tokenEnv)
+			require.Equal(t, "deploy-secrets", tokenEnv.ValueFrom.SecretKeyRef.Name)
+			require.Equal(t, "token", tokenEnv.ValueFrom.SecretKeyRef.Key)
+			redacted := findEnv(t, commandContainer.Env, "BUILDKITE_REDACTED_VARS")
+			require.Contains(t, strings.Split(redacted.Value, ","), "DEPLOY_TOKEN")
+		})
+	}
+}
+
 func TestImagePullPolicies(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,175 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// sidecarLogLimitBytes bounds the amount of log kept from each sidecar, so
+	// that a handful of chatty sidecars can't exceed the ConfigMap size limit.
+	sidecarLogLimitBytes = 32 * 1024
+
+	// sidecarLogsVolumeName is the name of the volume, backed by the job's
+	// sidecar logs ConfigMap, that is mounted into command containers.
+	sidecarLogsVolumeName = "sidecar-logs"
+
+	// sidecarLogsPath is where sidecar logs appear in command containers.
+	sidecarLogsPath = "/workspace/sidecar-logs"
+)
+
+// sidecarLogsConfigMapName returns the name of the ConfigMap holding the
+// sidecar logs for the Kubernetes job with the given name.
+func sidecarLogsConfigMapName(jobName string) string {
+	return jobName + "-sidecar-logs"
+}
+
+// captureSidecarLogs fetches the tail of the log of each sidecar container
+// that has exited, restarted, or stopped being ready since oldPod, and saves
+// them in the job's sidecar logs ConfigMap. That is mounted into the command
+// containers at sidecarLogsPath, so that a hook can print them in the job log
+// (where the agent redacts secrets). Sidecars (such as databases) are a common
+// cause of command failures that are otherwise invisible from Buildkite, and
+// their logs are gone once the job TTL expires.
+func (w *completionsWatcher) captureSidecarLogs(ctx context.Context, oldPod, newPod *corev1.Pod) {
+	if w.cfg.SidecarLogTailLines <= 0 {
+		return
+	}
+	log := loggerForObject(w.logger, newPod)
+
+	logs := make(map[string]string)
+	for _, status := range newPod.Status.ContainerStatuses {
+		if isSystemContainer(&status) {
+			continue
+		}
+		old := containerStatus(oldPod, status.Name)
+		if old == nil {
+			continue
+		}
+		restarted := status.RestartCount > old.RestartCount
+		exited := status.State.Terminated != nil && old.State.Terminated == nil
+		unready := old.Ready && !status.Ready
+		if !restarted && !exited && !unready {
+			continue
+		}
+		raw, err := w.k8s.CoreV1().Pods(newPod.Namespace).GetLogs(newPod.Name, &corev1.PodLogOptions{
+			Container: status.Name,
+			TailLines: ptr.To(int64(w.cfg.SidecarLogTailLines)),
+			// After a restart, the interesting log is the one that ended.
+			Previous: restarted && status.State.Terminated == nil,
+		}).DoRaw(ctx)
+		if err != nil {
+			log.Warn("Couldn't fetch sidecar logs", zap.String("container", status.Name), zap.Error(err))
+			completionWatcherSidecarLogErrorsCounter.Inc()
+			continue
+		}
+		logs[status.Name+".log"] = formatSidecarLog(status, string(raw))
+	}
+
+	if len(logs) == 0 {
+		return
+	}
+	if err := w.saveSidecarLogs(ctx, newPod, logs); err != nil {
+		log.Warn("Couldn't save sidecar logs for the job", zap.Error(err))
+		completionWatcherSidecarLogErrorsCounter.Inc()
+		return
+	}
+	completionWatcherSidecarLogsCounter.Add(float64(len(logs)))
+}
+
+// saveSidecarLogs creates or updates the job's sidecar logs ConfigMap with the
+// given logs. The ConfigMap is owned by the job, so it's deleted along with it.
+func (w *completionsWatcher) saveSidecarLogs(ctx context.Context, pod *corev1.Pod, logs map[string]string) error {
+	configMaps := w.k8s.CoreV1().ConfigMaps(pod.Namespace)
+	name := sidecarLogsConfigMapName(pod.Labels["job-name"])
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, name, metav1.GetOptions{})
+		if kerrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: pod.Namespace,
+					Labels:    map[string]string{config.UUIDLabel: pod.Labels[config.UUIDLabel]},
+				},
+				Data: logs,
+			}
+			if owner := metav1.GetControllerOf(pod); owner != nil {
+				cm.OwnerReferences = []metav1.OwnerReference{{
+					APIVersion: owner.APIVersion,
+					Kind:       owner.Kind,
+					Name:       owner.Name,
+					UID:        owner.UID,
+				}}
+			}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		maps.Copy(cm.Data, logs)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// containerStatus returns the status of the named container in the pod, or
+// nil if there isn't one.
+func containerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// formatSidecarLog renders a sidecar's log under a line describing the
+// sidecar's state, keeping only its last sidecarLogLimitBytes.
+func formatSidecarLog(status corev1.ContainerStatus, logs string) string {
+	summary := status.Name
+	if term := status.State.Terminated; term != nil {
+		summary = fmt.Sprintf("%s (exited %d: %s)", status.Name, term.ExitCode, term.Reason)
+	} else if status.RestartCount > 0 {
+		summary = fmt.Sprintf("%s (restarted %d times)", status.Name, status.RestartCount)
+	} else if !status.Ready {
+		summary = fmt.Sprintf("%s (not ready)", status.Name)
+	}
+	logs, truncated := tailBytes(logs, sidecarLogLimitBytes)
+	note := ""
+	if truncated {
+		note = fmt.Sprintf("(truncated to the last %d KiB)\n", sidecarLogLimitBytes/1024)
+	}
+	if logs == "" {
+		logs = "(no output)"
+	}
+	return fmt.Sprintf("Sidecar %s:\n%s%s\n", summary, note, strings.TrimRight(logs, "\n"))
+}
+
+// tailBytes returns at most the last limit bytes of logs, starting at a line
+// boundary, and whether anything was cut off. The end of a log is usually the
+// interesting part (e.g. why a sidecar crashed), so the start is what's cut.
+func tailBytes(logs string, limit int) (string, bool) {
+	if len(logs) <= limit {
+		return logs, false
+	}
+	tail := logs[len(logs)-limit:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 {
+		tail = tail[i+1:]
+	}
+	return tail, true
+}
@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func TestCaptureSidecarLogs(t *testing.T) {
+	jobUUID := uuid.New()
+	jobName := k8sJobName(jobUUID.String())
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	pod := func(statuses ...corev1.ContainerStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "buildkite-" + jobUUID.String(),
+				Namespace: "buildkite",
+				Labels: map[string]string{
+					config.UUIDLabel: jobUUID.String(),
+					"job-name":       jobName,
+				},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "batch/v1",
+					Kind:       "Job",
+					Name:       jobName,
+					UID:        "job-uid",
+					Controller: ptr.To(true),
+				}},
+			},
+			Status: corev1.PodStatus{
+				ContainerStatuses: append([]corev1.ContainerStatus{
+					{Name: AgentContainerName, State: running},
+					{Name: "container-0", State: running},
+				}, statuses...),
+			},
+		}
+	}
+	oldPod := pod(
+		corev1.ContainerStatus{Name: "postgres", State: running, Ready: true},
+		corev1.ContainerStatus{Name: "redis", State: running, Ready: true, RestartCount: 1},
+		corev1.ContainerStatus{Name: "nginx", State: running, Ready: true},
+		corev1.ContainerStatus{Name: "memcached", State: running, Ready: true},
+	)
+	newPod := pod(
+		corev1.ContainerStatus{Name: "postgres", Ready: false, State: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"},
+		}},
+		corev1.ContainerStatus{Name: "redis", State: running, Ready: true, RestartCount: 2},
+		corev1.ContainerStatus{Name: "nginx", State: running, Ready: false},
+		corev1.ContainerStatus{Name: "memcached", State: running, Ready: true},
+	)
+	// The command container exiting doesn't make it a sidecar.
+	newPod.Status.ContainerStatuses[1].State = corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+	}
+
+	tests := []struct {
+		name          string
+		tailLines     int
+		agentExited   bool
+		wantFetched   map[string]bool // container -> Previous
+		wantConfigMap bool
+	}{
+		{
+			name:          "sidecars failed",
+			tailLines:     50,
+			wantFetched:   map[string]bool{"postgres": false, "redis": true, "nginx": false},
+			wantConfigMap: true,
+		},
+		{
+			name:      "disabled",
+			tailLines: 0,
+		},
+		{
+			// By then the job is over, so there's nothing left to print the logs.
+			name:        "agent exited",
+			tailLines:   50,
+			agentExited: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newPod := newPod.DeepCopy()
+			if test.agentExited {
+				newPod.Status.ContainerStatuses[0].State = corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: 1},
+				}
+			}
+			k8s := fake.NewClientset(&batchv1.Job{
+				ObjectMeta: metav1.ObjectMeta{Name: jobName, Namespace: "buildkite"},
+			})
+			cfg := &config.Config{Namespace: "buildkite", SidecarLogTailLines: test.tailLines}
+			w := NewPodCompletionWatcher(zaptest.NewLogger(t), k8s, record.NewFakeRecorder(10), cfg)
+			w.resourceEventHandlerCtx = context.Background()
+
+			w.OnUpdate(oldPod, newPod)
+
+			fetched := make(map[string]bool)
+			for _, action := range k8s.Actions() {
+				if action.GetSubresource() != "log" {
+					continue
+				}
+				opts := action.(k8stesting.GenericAction).GetValue().(*corev1.PodLogOptions)
+				fetched[opts.Container] = opts.Previous
+				if opts.TailLines == nil || *opts.TailLines != int64(test.tailLines) {
+					t.Errorf("log options for %s TailLines = %v, want %d", opts.Container, opts.TailLines, test.tailLines)
+				}
+				// A byte limit would apply from the start of the tail, and
+				// cut off the newest lines.
+				if opts.LimitBytes != nil {
+					t.Errorf("log options for %s LimitBytes = %d, want nil", opts.Container, *opts.LimitBytes)
+				}
+			}
+			if test.wantFetched == nil {
+				test.wantFetched = map[string]bool{}
+			}
+			if diff := cmp.Diff(fetched, test.wantFetched); diff != "" {
+				t.Errorf("containers whose logs were fetched (and Previous) diff (-got +want):\n%s", diff)
+			}
+
+			cm, err := k8s.CoreV1().ConfigMaps("buildkite").Get(context.Background(), sidecarLogsConfigMapName(jobName), metav1.GetOptions{})
+			if !test.wantConfigMap {
+				if err == nil {
+					t.Errorf("sidecar logs ConfigMap = %v, want none", cm.Data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get(sidecar logs ConfigMap) error = %v", err)
+			}
+			keys := slices.Sorted(maps.Keys(cm.Data))
+			if diff := cmp.Diff(keys, []string{"nginx.log", "postgres.log", "redis.log"}); diff != "" {
+				t.Errorf("sidecar logs ConfigMap keys diff (-got +want):\n%s", diff)
+			}
+			for key, want := range map[string]string{
+				"postgres.log": "Sidecar postgres (exited 137: OOMKilled):\n",
+				"redis.log":    "Sidecar redis (restarted 2 times):\n",
+				"nginx.log":    "Sidecar nginx (not ready):\n",
+			} {
+				if !strings.HasPrefix(cm.Data[key], want) {
+					t.Errorf("sidecar logs ConfigMap %s = %q, want prefix %q", key, cm.Data[key], want)
+				}
+			}
+			if got := cm.Labels[config.UUIDLabel]; got != jobUUID.String() {
+				t.Errorf("sidecar logs ConfigMap label %s = %q, want %q", config.UUIDLabel, got, jobUUID.String())
+			}
+			if len(cm.OwnerReferences) != 1 || cm.OwnerReferences[0].UID != "job-uid" {
+				t.Errorf("sidecar logs ConfigMap owner references = %v, want the job", cm.OwnerReferences)
+			}
+
+			// Later failures are added to the same ConfigMap.
+			nextPod := newPod.DeepCopy()
+			nextPod.Status.ContainerStatuses[5].Ready = false
+			w.OnUpdate(newPod, nextPod)
+			cm, err = k8s.CoreV1().ConfigMaps("buildkite").Get(context.Background(), sidecarLogsConfigMapName(jobName), metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Get(sidecar logs ConfigMap) error = %v", err)
+			}
+			if got := len(cm.Data); got != 4 {
+				t.Errorf("sidecar logs ConfigMap has %d logs after memcached failed, want 4", got)
+			}
+		})
+	}
+}
+
+func TestFormatSidecarLog(t *testing.T) {
+	// The oldest line is cut off, the newest (the crash) is kept.
+	line := strings.Repeat("x", 1023) + "\n"
+	long := "oldest\n" + strings.Repeat(line, sidecarLogLimitBytes/len(line)) + "FATAL: crashed\n"
+	wantTail := strings.Repeat(line, sidecarLogLimitBytes/len(line)-1) + "FATAL: crashed\n"
+	tests := []struct {
+		name   string
+		status corev1.ContainerStatus
+		logs   string
+		want   string
+	}{
+		{
+			name:   "not ready",
+			status: corev1.ContainerStatus{Name: "redis"},
+			logs:   "ready\n\n",
+			want:   "Sidecar redis (not ready):\nready\n",
+		},
+		{
+			name: "exited",
+			status: corev1.ContainerStatus{
+				Name: "postgres",
+				State: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Reason: "Error"},
+				},
+			},
+			logs: "FATAL: out of disk\n",
+			want: "Sidecar postgres (exited 1: Error):\nFATAL: out of disk\n",
+		},
+		{
+			name:   "no output",
+			status: corev1.ContainerStatus{Name: "redis", Ready: true, RestartCount: 3},
+			want:   "Sidecar redis (restarted 3 times):\n(no output)\n",
+		},
+		{
+			name:   "truncated",
+			status: corev1.ContainerStatus{Name: "chatty", Ready: true},
+			logs:   long,
+			want:   "Sidecar chatty:\n(truncated to the last 32 KiB)\n" + wantTail,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if diff := cmp.Diff(formatSidecarLog(test.status, test.logs), test.want); diff != "" {
+				t.Errorf("formatSidecarLog() diff (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strconv"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/ptr"
+)
+
+// podUnschedulableSince reports when the scheduler last found the pod to be
+// unschedulable, along with the scheduler's message, if the pod is currently
+// unschedulable.
+func podUnschedulableSince(pod *corev1.Pod) (since time.Time, message string, unschedulable bool) {
+	if pod.Status.Phase != corev1.PodPending {
+		return time.Time{}, "", false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type != corev1.PodScheduled {
+			continue
+		}
+		if cond.Status != corev1.ConditionFalse || cond.Reason != corev1.PodReasonUnschedulable {
+			return time.Time{}, "", false
+		}
+		return cond.LastTransitionTime.Time, cond.Message, true
+	}
+	return time.Time{}, "", false
+}
+
+// watchForUnschedulable starts (or stops) watching the pod for staying
+// unschedulable for too long, depending on its current state.
+func (w *podWatcher) watchForUnschedulable(jobUUID uuid.UUID, pod *corev1.Pod) {
+	if w.unschedulableGracePeriod <= 0 && len(w.cfg.UnschedulableFallbacks) == 0 {
+		return
+	}
+	if _, _, unschedulable := podUnschedulableSince(pod); !unschedulable {
+		w.stopWatchingForUnschedulable(jobUUID)
+		return
+	}
+	w.watchingUnschedulableMu.Lock()
+	defer w.watchingUnschedulableMu.Unlock()
+	w.watchingUnschedulable[jobUUID] = pod
+}
+
+func (w *podWatcher) stopWatchingForUnschedulable(jobUUID uuid.UUID) {
+	w.watchingUnschedulableMu.Lock()
+	defer w.watchingUnschedulableMu.Unlock()
+	delete(w.watchingUnschedulable, jobUUID)
+}
+
+// unschedulableChecker is a goroutine that periodically checks unschedulable
+// pods. Once a pod has been unschedulable for long enough, the next fallback
+// (if any) replaces its job, otherwise the Buildkite job is failed once the
+// grace period is over.
+func (w *podWatcher) unschedulableChecker(ctx context.Context, log *zap.Logger) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			// continue below
+		}
+
+		var stuckPods, fallbackPods []*corev1.Pod
+
+		w.watchingUnschedulableMu.Lock()
+		for jobUUID, pod := range w.watchingUnschedulable {
+			since, _, unschedulable := podUnschedulableSince(pod)
+			if !unschedulable {
+				continue
+			}
+			stuck := time.Since(since)
+			if fb := w.nextFallback(pod); fb != nil {
+				if stuck >= fb.After {
+					fallbackPods = append(fallbackPods, pod)
+					delete(w.watchingUnschedulable, jobUUID)
+				}
+				continue
+			}
+			if w.unschedulableGracePeriod <= 0 || stuck < w.unschedulableGracePeriod {
+				continue
+			}
+			stuckPods = append(stuckPods, pod)
+			delete(w.watchingUnschedulable, jobUUID)
+		}
+		w.watchingUnschedulableMu.Unlock()
+
+		for _, pod := range fallbackPods {
+			w.fallBackForUnschedulable(ctx, pod)
+		}
+		for _, pod := range stuckPods {
+			w.failForUnschedulable(ctx, pod)
+		}
+	}
+}
+
+// failForUnschedulable fails the Buildkite job for a pod that the scheduler
+// couldn't place, and evicts the pod. Since the pod never started, the agent
+// can't have acquired the job.
+func (w *podWatcher) failForUnschedulable(ctx context.Context, pod *corev1.Pod) {
+	log := loggerForObject(w.logger, pod)
+	jobUUID, err := jobUUIDForObject(pod)
+	if err != nil {
+		log.Error("Job UUID label missing or invalid for pod")
+		return
+	}
+	since, schedMessage, _ := podUnschedulableSince(pod)
+
+	log.Info("Pod has been unschedulable for too long. Failing.")
+	podsUnschedulableCounter.Inc()
+	message := fmt.Sprintf("The pod could not be scheduled onto a node for %s: %s\n",
+		duration.HumanDuration(time.Since(since)), schedMessage)
+	message += fetchEvents(ctx, log, w.k8s, pod.Namespace, "Pod", pod.Name, "FailedScheduling")
+
+	if err := acquireAndFailForObject(ctx, log, w.k8s, w.tokens, w.recorder, w.cfg, pod, message, w.cfg.FailureExitStatuses.For(config.FailureUnschedulable)); err != nil {
+		// Maybe the job was cancelled in the meantime?
+		log.Error("Could not fail Buildkite job", zap.Error(err))
+		podWatcherBuildkiteJobFailErrorsCounter.Inc()
+		return
+	}
+	podWatcherBuildkiteJobFailsCounter.Inc()
+
+	// The pod would otherwise wait for a node until the job's active deadline.
+	w.stopJobCancelChecker(jobUUID)
+	w.evictPod(ctx, log, pod, jobUUID, "unschedulable")
+}
+
+// fallbackAttempt returns the number of fallbacks already used for the pod's
+// job.
+func fallbackAttempt(pod *corev1.Pod) int {
+	attempt, err := strconv.Atoi(pod.Annotations[config.FallbackAttemptAnnotation])
+	if err != nil {
+		return 0
+	}
+	return attempt
+}
+
+// nextFallback returns the fallback to use next for the pod, or nil if they
+// have all been used.
+func (w *podWatcher) nextFallback(pod *corev1.Pod) *config.UnschedulableFallback {
+	attempt := fallbackAttempt(pod)
+	if attempt < 0 || attempt >= len(w.cfg.UnschedulableFallbacks) {
+		return nil
+	}
+	return &w.cfg.UnschedulableFallbacks[attempt]
+}
+
+// Labels and annotations added to jobs and pods by the Job controller, which
+// must not be copied to a replacement job.
+var jobControllerKeys = []string{
+	"controller-uid",
+	"job-name",
+	batchv1.ControllerUidLabel,
+	batchv1.JobNameLabel,
+	"batch.kubernetes.io/job-tracking",
+}
+
+// fallbackJob returns a copy of kjob suitable for creating as its replacement,
+// with the fallback's pod spec patch applied. Since kjob may itself be a
+// fallback job, the patches of earlier fallbacks are kept: each fallback
+// relaxes the placement further.
+func (w *podWatcher) fallbackJob(kjob *batchv1.Job, jobUUID uuid.UUID, attempt int, fb *config.UnschedulableFallback) (*batchv1.Job, error) {
+	attemptStr := strconv.Itoa(attempt)
+	replacement := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s-fallback-%d", k8sJobName(jobUUID.String()), attempt),
+			Namespace:   kjob.Namespace,
+			Labels:      maps.Clone(kjob.Labels),
+			Annotations: maps.Clone(kjob.Annotations),
+		},
+		Spec: *kjob.Spec.DeepCopy(),
+	}
+	// Let the Job controller generate a selector for the new job.
+	replacement.Spec.Selector = nil
+	replacement.Spec.ManualSelector = nil
+	for _, key := range jobControllerKeys {
+		delete(replacement.Labels, key)
+		delete(replacement.Annotations, key)
+		delete(replacement.Spec.Template.Labels, key)
+		delete(replacement.Spec.Template.Annotations, key)
+	}
+	if replacement.Annotations == nil {
+		replacement.Annotations = make(map[string]string)
+	}
+	if replacement.Spec.Template.Annotations == nil {
+		replacement.Spec.Template.Annotations = make(map[string]string)
+	}
+	delete(replacement.Annotations, config.ReplacedByAnnotation)
+	replacement.Annotations[config.FallbackAttemptAnnotation] = attemptStr
+	replacement.Spec.Template.Annotations[config.FallbackAttemptAnnotation] = attemptStr
+
+	patched, err := PatchPodSpec(&replacement.Spec.Template.Spec, fb.PodSpecPatch, w.cfg.DefaultCommandParams, nil, w.cfg.AllowPodSpecPatchUnsafeCmdMod)
+	if err != nil {
+		return nil, fmt.Errorf("applying fallback pod spec patch: %w", err)
+	}
+	replacement.Spec.Template.Spec = *patched
+	return replacement, nil
+}
+
+// fallBackForUnschedulable replaces the Kubernetes job for an unschedulable
+// pod with one using the next fallback. The agent can't have acquired the
+// Buildkite job, so the replacement pod can run it instead. The old job is
+// marked as replaced before it is deleted, so that the deduper and limiter
+// continue to treat the Buildkite job as in flight.
+func (w *podWatcher) fallBackForUnschedulable(ctx context.Context, pod *corev1.Pod) {
+	log := loggerForObject(w.logger, pod)
+	jobUUID, err := jobUUIDForObject(pod)
+	if err != nil {
+		log.Error("Job UUID label missing or invalid for pod")
+		return
+	}
+	attempt := fallbackAttempt(pod) + 1
+	fb := w.nextFallback(pod)
+	log = log.With(zap.String("fallback", fb.Name), zap.Int("fallback_attempt", attempt))
+
+	jobs := w.k8s.BatchV1().Jobs(pod.Namespace)
+	kjob, err := jobs.Get(ctx, pod.Labels["job-name"], metav1.GetOptions{})
+	if err != nil {
+		log.Error("Couldn't get job for unschedulable pod", zap.Error(err))
+		podFallbackErrorsCounter.WithLabelValues(fb.Name, string(kerrors.ReasonForError(err))).Inc()
+		return
+	}
+	replacement, err := w.fallbackJob(kjob, jobUUID, attempt, fb)
+	if err != nil {
+		log.Error("Couldn't create fallback job", zap.Error(err))
+		podFallbackErrorsCounter.WithLabelValues(fb.Name, "Invalid").Inc()
+		w.failForUnschedulable(ctx, pod)
+		return
+	}
+
+	// Mark the old job as replaced first, so there is no moment where the
+	// Buildkite job appears to be not in flight.
+	if err := w.setReplacedBy(ctx, kjob, replacement.Name); err != nil {
+		log.Error("Couldn't mark job as replaced", zap.Error(err))
+		podFallbackErrorsCounter.WithLabelValues(fb.Name, string(kerrors.ReasonForError(err))).Inc()
+		return
+	}
+
+	// The old pod's deletion shouldn't stop checks on the new pod.
+	w.stopWatchingForImageFailure(jobUUID)
+	w.stopJobCancelChecker(jobUUID)
+	w.markReplaced(pod)
+
+	created, err := jobs.Create(ctx, replacement, metav1.CreateOptions{})
+	if err != nil {
+		log.Error("Couldn't create fallback job", zap.Error(err))
+		podFallbackErrorsCounter.WithLabelValues(fb.Name, string(kerrors.ReasonForError(err))).Inc()
+		if err := w.setReplacedBy(ctx, kjob, ""); err != nil {
+			log.Error("Couldn't unmark job as replaced", zap.Error(err))
+		}
+		w.unmarkReplaced(pod)
+		w.failForUnschedulable(ctx, pod)
+		return
+	}
+
+	log.Info("Pod was unschedulable. Replaced job with fallback", zap.String("replacement", replacement.Name))
+	podFallbacksCounter.WithLabelValues(fb.Name).Inc()
+	w.recorder.Eventf(created, corev1.EventTypeNormal, EventReasonJobCreated, "Created for Buildkite job %s with fallback %s, replacing job %s", jobUUID, fb.Name, kjob.Name)
+
+	if err := jobs.Delete(ctx, kjob.Name, metav1.DeleteOptions{
+		PropagationPolicy: ptr.To(metav1.DeletePropagationBackground),
+	}); err != nil && !kerrors.IsNotFound(err) {
+		// The old pod still can't be scheduled, and if it ever is the agent
+		// will fail to acquire the job. But it should be cleaned up.
+		log.Error("Couldn't delete replaced job", zap.Error(err))
+		podFallbackErrorsCounter.WithLabelValues(fb.Name, string(kerrors.ReasonForError(err))).Inc()
+	}
+}
+
+// setReplacedBy sets (or if name is empty, removes) the annotation on kjob
+// recording which job replaced it.
+func (w *podWatcher) setReplacedBy(ctx context.Context, kjob *batchv1.Job, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		job, err := w.k8s.BatchV1().Jobs(kjob.Namespace).Get(ctx, kjob.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			delete(job.Annotations, config.ReplacedByAnnotation)
+		} else {
+			if job.Annotations == nil {
+				job.Annotations = make(map[string]string)
+			}
+			job.Annotations[config.ReplacedByAnnotation] = name
+		}
+		_, err = w.k8s.BatchV1().Jobs(kjob.Namespace).Update(ctx, job, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func (w *podWatcher) markReplaced(pod *corev1.Pod) {
+	w.replacedPodsMu.Lock()
+	defer w.replacedPodsMu.Unlock()
+	w.replacedPods[pod.UID] = struct{}{}
+}
+
+func (w *podWatcher) unmarkReplaced(pod *corev1.Pod) {
+	w.replacedPodsMu.Lock()
+	defer w.replacedPodsMu.Unlock()
+	delete(w.replacedPods, pod.UID)
+}
+
+// wasReplaced reports if the pod belonged to a job that was replaced, and
+// forgets about it.
+func (w *podWatcher) wasReplaced(pod *corev1.Pod) bool {
+	w.replacedPodsMu.Lock()
+	defer w.replacedPodsMu.Unlock()
+	_, replaced := w.replacedPods[pod.UID]
+	delete(w.replacedPods, pod.UID)
+	return replaced
+}
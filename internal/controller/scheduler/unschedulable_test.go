@@ -0,0 +1,356 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/api"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap/zaptest"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+// unschedulablePod returns a pending pod for the job that the scheduler
+// has found unschedulable since the given time.
+func unschedulablePod(jobUUID uuid.UUID, since time.Time) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "buildkite-" + jobUUID.String(),
+			Namespace: "buildkite",
+			UID:       types.UID("pod-" + jobUUID.String()),
+			Labels: map[string]string{
+				config.UUIDLabel:          jobUUID.String(),
+				"tag.buildkite.com/queue": "kubernetes",
+				"job-name":                k8sJobName(jobUUID.String()),
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			Conditions: []corev1.PodCondition{{
+				Type:               corev1.PodScheduled,
+				Status:             corev1.ConditionFalse,
+				Reason:             corev1.PodReasonUnschedulable,
+				Message:            "0/3 nodes are available: 3 Insufficient cpu.",
+				LastTransitionTime: metav1.NewTime(since),
+			}},
+		},
+	}
+}
+
+func TestPodUnschedulableSince(t *testing.T) {
+	since := time.Now().Add(-time.Minute).Truncate(time.Second)
+	scheduled := unschedulablePod(uuid.New(), since)
+	scheduled.Status.Conditions[0].Status = corev1.ConditionTrue
+	scheduled.Status.Conditions[0].Reason = ""
+	schedulerError := unschedulablePod(uuid.New(), since)
+	schedulerError.Status.Conditions[0].Reason = corev1.PodReasonSchedulerError
+	running := unschedulablePod(uuid.New(), since)
+	running.Status.Phase = corev1.PodRunning
+
+	tests := []struct {
+		name              string
+		pod               *corev1.Pod
+		wantUnschedulable bool
+	}{
+		{name: "unschedulable", pod: unschedulablePod(uuid.New(), since), wantUnschedulable: true},
+		{name: "no PodScheduled condition", pod: &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}}},
+		{name: "scheduled", pod: scheduled},
+		{name: "scheduler error", pod: schedulerError},
+		{name: "not pending", pod: running},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotSince, message, unschedulable := podUnschedulableSince(test.pod)
+			if unschedulable != test.wantUnschedulable {
+				t.Fatalf("podUnschedulableSince() unschedulable = %t, want %t", unschedulable, test.wantUnschedulable)
+			}
+			if !unschedulable {
+				return
+			}
+			if !gotSince.Equal(since) {
+				t.Errorf("podUnschedulableSince() since = %v, want %v", gotSince, since)
+			}
+			if !strings.Contains(message, "Insufficient cpu") {
+				t.Errorf("podUnschedulableSince() message = %q, want the scheduler's message", message)
+			}
+		})
+	}
+}
+
+func TestWatchForUnschedulable(t *testing.T) {
+	w := NewPodWatcher(zaptest.NewLogger(t), fake.NewClientset(), nil, nil, record.NewFakeRecorder(10), &config.Config{
+		Namespace:                "buildkite",
+		UnschedulableGracePeriod: time.Minute,
+	})
+	jobUUID := uuid.New()
+	watching := func() bool {
+		w.watchingUnschedulableMu.Lock()
+		defer w.watchingUnschedulableMu.Unlock()
+		_, ok := w.watchingUnschedulable[jobUUID]
+		return ok
+	}
+
+	pod := unschedulablePod(jobUUID, time.Now())
+	w.watchForUnschedulable(jobUUID, pod)
+	if !watching() {
+		t.Fatal("unschedulable pod isn't watched")
+	}
+
+	// Once a node is found for it, the pod should no longer be watched.
+	pod = pod.DeepCopy()
+	pod.Status.Conditions[0].Status = corev1.ConditionTrue
+	pod.Status.Conditions[0].Reason = ""
+	w.watchForUnschedulable(jobUUID, pod)
+	if watching() {
+		t.Error("scheduled pod is still watched")
+	}
+
+	// Without a grace period or fallbacks, nothing is watched.
+	w = NewPodWatcher(zaptest.NewLogger(t), fake.NewClientset(), nil, nil, record.NewFakeRecorder(10), &config.Config{Namespace: "buildkite"})
+	w.watchForUnschedulable(jobUUID, unschedulablePod(jobUUID, time.Now()))
+	if watching() {
+		t.Error("unschedulable pod is watched with the grace period disabled")
+	}
+}
+
+func TestUnschedulableChecker(t *testing.T) {
+	fb := newFakeBuildkite(t, api.JobStatesScheduled)
+	cfg := &config.Config{
+		Namespace:                "buildkite",
+		UnschedulableGracePeriod: time.Minute,
+	}
+	secret := fb.configure(cfg)
+
+	stuckUUID, waitingUUID := uuid.New(), uuid.New()
+	stuck := unschedulablePod(stuckUUID, time.Now().Add(-5*time.Minute))
+	waiting := unschedulablePod(waitingUUID, time.Now())
+
+	k8s := fake.NewClientset(secret, stuck, waiting)
+	recorder := record.NewFakeRecorder(100)
+	w := NewPodWatcher(zaptest.NewLogger(t), k8s, fb.gql(), nil, recorder, cfg)
+	w.watchForUnschedulable(stuckUUID, stuck)
+	w.watchForUnschedulable(waitingUUID, waiting)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.unschedulableChecker(ctx, zaptest.NewLogger(t))
+	}()
+
+	// The checker looks at watched pods every second.
+	deadline := time.Now().Add(10 * time.Second)
+	for !w.isIgnored(stuckUUID) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	status, failed := fb.failedWith(stuckUUID.String())
+	if !failed {
+		t.Fatal("job for pod unschedulable past the grace period wasn't failed")
+	}
+	if want := strconv.Itoa(cfg.FailureExitStatuses.For(config.FailureUnschedulable)); status != want {
+		t.Errorf("job failed with exit status %s, want %s", status, want)
+	}
+	if log := fb.log(stuckUUID.String()); !strings.Contains(log, "Insufficient cpu") {
+		t.Errorf("job log = %q, want it to contain the scheduler's message", log)
+	}
+
+	// After failing the job, the pod is evicted.
+	evicted := false
+	for _, action := range k8s.Actions() {
+		if action.GetVerb() == "create" && action.GetSubresource() == "eviction" {
+			evicted = true
+		}
+	}
+	if !evicted {
+		t.Error("pod wasn't evicted after failing its job")
+	}
+	wantEvent := "Warning " + EventReasonPodEvicted + " Evicted the pod because " + evictionReasons["unschedulable"]
+	foundEvent := false
+	for len(recorder.Events) > 0 {
+		if <-recorder.Events == wantEvent {
+			foundEvent = true
+		}
+	}
+	if !foundEvent {
+		t.Errorf("no %q event recorded", wantEvent)
+	}
+
+	// The pod within its grace period is left alone, and still watched.
+	if _, failed := fb.failedWith(waitingUUID.String()); failed {
+		t.Error("job for pod within the grace period was failed")
+	}
+	w.watchingUnschedulableMu.Lock()
+	_, watchingWaiting := w.watchingUnschedulable[waitingUUID]
+	_, watchingStuck := w.watchingUnschedulable[stuckUUID]
+	w.watchingUnschedulableMu.Unlock()
+	if !watchingWaiting {
+		t.Error("pod within the grace period is no longer watched")
+	}
+	if watchingStuck {
+		t.Error("pod whose job was failed is still watched")
+	}
+}
+
+// fallbackTestJob returns the original Kubernetes job for the Buildkite job,
+// as the scheduler would create it, and the pod the Job controller created.
+func fallbackTestJob(jobUUID uuid.UUID) (*batchv1.Job, *corev1.Pod) {
+	kjob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      k8sJobName(jobUUID.String()),
+			Namespace: "buildkite",
+			Labels: map[string]string{
+				config.UUIDLabel:          jobUUID.String(),
+				"tag.buildkite.com/queue": "kubernetes",
+			},
+		},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						config.UUIDLabel:          jobUUID.String(),
+						"tag.buildkite.com/queue": "kubernetes",
+					},
+				},
+				Spec: corev1.PodSpec{
+					Containers:   []corev1.Container{{Name: "container-0", Image: "alpine"}},
+					NodeSelector: map[string]string{"capacity-type": "spot"},
+				},
+			},
+		},
+	}
+	return kjob, podForJob(kjob)
+}
+
+// podForJob returns a pending, unschedulable pod made from the job's template.
+func podForJob(kjob *batchv1.Job) *corev1.Pod {
+	pod := unschedulablePod(uuid.MustParse(kjob.Labels[config.UUIDLabel]), time.Now().Add(-time.Hour))
+	pod.Name = kjob.Name + "-pod"
+	pod.UID = types.UID("pod-" + kjob.Name)
+	pod.Labels["job-name"] = kjob.Name
+	pod.Annotations = kjob.Spec.Template.Annotations
+	pod.Spec = kjob.Spec.Template.Spec
+	return pod
+}
+
+func TestFallBackForUnschedulable_Chain(t *testing.T) {
+	cfg := &config.Config{
+		Namespace: "buildkite",
+		UnschedulableFallbacks: []config.UnschedulableFallback{
+			{
+				Name:         "on-demand",
+				After:        time.Minute,
+				PodSpecPatch: &corev1.PodSpec{NodeSelector: map[string]string{"capacity-type": "on-demand"}},
+			},
+			{
+				Name:  "tainted",
+				After: time.Minute,
+				PodSpecPatch: &corev1.PodSpec{Tolerations: []corev1.Toleration{{
+					Key:      "dedicated",
+					Operator: corev1.TolerationOpExists,
+				}}},
+			},
+		},
+	}
+	jobUUID := uuid.New()
+	kjob, pod := fallbackTestJob(jobUUID)
+	k8s := fake.NewClientset(kjob)
+	w := NewPodWatcher(zaptest.NewLogger(t), k8s, nil, nil, record.NewFakeRecorder(100), cfg)
+	ctx := context.Background()
+
+	for attempt := 1; attempt <= len(cfg.UnschedulableFallbacks); attempt++ {
+		w.fallBackForUnschedulable(ctx, pod)
+
+		if !w.wasReplaced(pod) {
+			t.Errorf("attempt %d: replaced pod wasn't marked as replaced", attempt)
+		}
+		if _, err := k8s.BatchV1().Jobs("buildkite").Get(ctx, kjob.Name, metav1.GetOptions{}); !kerrors.IsNotFound(err) {
+			t.Errorf("attempt %d: Get(replaced job %s) error = %v, want NotFound", attempt, kjob.Name, err)
+		}
+		wantName := fmt.Sprintf("%s-fallback-%d", k8sJobName(jobUUID.String()), attempt)
+		replacement, err := k8s.BatchV1().Jobs("buildkite").Get(ctx, wantName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("attempt %d: Get(fallback job %s) error = %v", attempt, wantName, err)
+		}
+		if got, want := replacement.Spec.Template.Annotations[config.FallbackAttemptAnnotation], strconv.Itoa(attempt); got != want {
+			t.Errorf("attempt %d: fallback attempt annotation = %q, want %q", attempt, got, want)
+		}
+		if got := replacement.Labels[config.UUIDLabel]; got != jobUUID.String() {
+			t.Errorf("attempt %d: replacement UUID label = %q, want %q", attempt, got, jobUUID)
+		}
+		kjob, pod = replacement, podForJob(replacement)
+	}
+
+	// Patches are cumulative: the last job has both fallbacks' patches.
+	spec := kjob.Spec.Template.Spec
+	if got := spec.NodeSelector["capacity-type"]; got != "on-demand" {
+		t.Errorf("last fallback job nodeSelector capacity-type = %q, want on-demand", got)
+	}
+	if len(spec.Tolerations) != 1 || spec.Tolerations[0].Key != "dedicated" {
+		t.Errorf("last fallback job tolerations = %v, want the dedicated toleration", spec.Tolerations)
+	}
+	if fb := w.nextFallback(pod); fb != nil {
+		t.Errorf("nextFallback() after the last fallback = %q, want nil", fb.Name)
+	}
+}
+
+func TestFallBackForUnschedulable_CreateFails(t *testing.T) {
+	fb := newFakeBuildkite(t, api.JobStatesScheduled)
+	cfg := &config.Config{
+		Namespace: "buildkite",
+		UnschedulableFallbacks: []config.UnschedulableFallback{{
+			Name:         "on-demand",
+			After:        time.Minute,
+			PodSpecPatch: &corev1.PodSpec{NodeSelector: map[string]string{"capacity-type": "on-demand"}},
+		}},
+	}
+	secret := fb.configure(cfg)
+	jobUUID := uuid.New()
+	kjob, pod := fallbackTestJob(jobUUID)
+	k8s := fake.NewClientset(secret, kjob, pod)
+	k8s.PrependReactor("create", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kerrors.NewForbidden(batchv1.Resource("jobs"), "", errors.New("exceeded quota"))
+	})
+	w := NewPodWatcher(zaptest.NewLogger(t), k8s, fb.gql(), nil, record.NewFakeRecorder(100), cfg)
+	ctx := context.Background()
+
+	w.fallBackForUnschedulable(ctx, pod)
+
+	// The original job is kept, and no longer marked as replaced.
+	got, err := k8s.BatchV1().Jobs("buildkite").Get(ctx, kjob.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get(original job) error = %v", err)
+	}
+	if name, ok := got.Annotations[config.ReplacedByAnnotation]; ok {
+		t.Errorf("original job still marked as replaced by %q", name)
+	}
+	if w.wasReplaced(pod) {
+		t.Error("pod still marked as replaced")
+	}
+
+	// Without a replacement, the Buildkite job is failed instead.
+	status, failed := fb.failedWith(jobUUID.String())
+	if !failed {
+		t.Fatal("Buildkite job wasn't failed after the fallback job couldn't be created")
+	}
+	if want := strconv.Itoa(cfg.FailureExitStatuses.For(config.FailureUnschedulable)); status != want {
+		t.Errorf("job failed with exit status %s, want %s", status, want)
+	}
+}
@@ -0,0 +1,148 @@
+// Package tracing traces Buildkite jobs through the controller with
+// OpenTelemetry, and propagates the trace context to the pods it creates.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+	"github.com/buildkite/agent-stack-k8s/v2/internal/version"
+
+	"github.com/buildkite/agent/v3/tracetools"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const tracerName = "github.com/buildkite/agent-stack-k8s/v2"
+
+// propagator is used for the trace context stored in annotations and passed
+// to the agent, regardless of the global propagator.
+var propagator = propagation.TraceContext{}
+
+// Tracer returns the tracer used by the controller. Until Start is called,
+// its spans are discarded.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start configures the global tracer provider to export spans with OTLP over
+// gRPC to the endpoint, which is a URL such as http://otel-collector:4317
+// (http for plaintext, https for TLS). Other exporter settings, such as
+// headers, are read from the standard OTEL_EXPORTER_OTLP_* env vars. The
+// returned function flushes any remaining spans and stops exporting.
+func Start(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlptracegrpc.New(ctx, otlptracegrpc.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName("agent-stack-k8s"),
+			semconv.ServiceVersion(version.Version()),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// StartJob starts the root span of the trace for a Buildkite job. The span
+// begins when the monitor began the query that returned the job, and has a
+// child span for the query.
+func StartJob(ctx context.Context, jobUUID string, queriedAt, polledAt time.Time) (context.Context, trace.Span) {
+	ctx, span := Tracer().Start(ctx, "job",
+		trace.WithNewRoot(),
+		trace.WithTimestamp(queriedAt),
+		trace.WithAttributes(attribute.String("buildkite.job.uuid", jobUUID)),
+	)
+	_, poll := Tracer().Start(ctx, "monitor.poll", trace.WithTimestamp(queriedAt))
+	poll.End(trace.WithTimestamp(polledAt))
+	return ctx, span
+}
+
+// End ends the span, recording the error, if any.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectIntoJob stores the trace context of the span in ctx on the Kubernetes
+// job, so that spans recorded later for its pod are part of the same trace:
+//   - as an annotation on the job and its pod template, and
+//   - as BUILDKITE_TRACE_CONTEXT in each container, encoded for the agent
+//     (see the agent's trace-context-encoding, which defaults to gob), so
+//     that the agent's own tracing can continue the trace.
+//
+// It does nothing if the span in ctx isn't sampled.
+func InjectIntoJob(ctx context.Context, kjob *batchv1.Job, encoding string) error {
+	if !trace.SpanContextFromContext(ctx).IsSampled() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+
+	codec, err := tracetools.ParseEncoding(encoding)
+	if err != nil {
+		return err
+	}
+	traceContext, err := encodeTraceContext(carrier, codec)
+	if err != nil {
+		return err
+	}
+
+	traceParent := carrier.Get("traceparent")
+	for _, annotations := range []*map[string]string{&kjob.Annotations, &kjob.Spec.Template.Annotations} {
+		if *annotations == nil {
+			*annotations = make(map[string]string)
+		}
+		(*annotations)[config.TraceParentAnnotation] = traceParent
+	}
+
+	env := corev1.EnvVar{Name: tracetools.EnvVarTraceContextKey, Value: traceContext}
+	podSpec := &kjob.Spec.Template.Spec
+	for i := range podSpec.InitContainers {
+		podSpec.InitContainers[i].Env = append(podSpec.InitContainers[i].Env, env)
+	}
+	for i := range podSpec.Containers {
+		podSpec.Containers[i].Env = append(podSpec.Containers[i].Env, env)
+	}
+	return nil
+}
+
+// encodeTraceContext encodes the carrier in the same way as the agent
+// (tracetools.EncodeTraceContext), which only accepts OpenTracing spans.
+func encodeTraceContext(carrier propagation.MapCarrier, codec tracetools.Codec) (string, error) {
+	var buf bytes.Buffer
+	if err := codec.NewEncoder(&buf).Encode(map[string]string(carrier)); err != nil {
+		return "", fmt.Errorf("encoding trace context: %w", err)
+	}
+	return base64.URLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// ContextFromAnnotations returns ctx with the remote span context stored in
+// the annotations by InjectIntoJob, if any, as the parent of new spans.
+func ContextFromAnnotations(ctx context.Context, annotations map[string]string) context.Context {
+	traceParent := annotations[config.TraceParentAnnotation]
+	if traceParent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
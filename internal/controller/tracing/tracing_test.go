@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/buildkite/agent-stack-k8s/v2/internal/controller/config"
+
+	"github.com/buildkite/agent/v3/tracetools"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// useSpanRecorder sets the global tracer provider to one that records spans in
+// memory, instead of exporting them to a collector.
+func useSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return recorder
+}
+
+func TestStartJob(t *testing.T) {
+	recorder := useSpanRecorder(t)
+
+	queriedAt := time.Now().Add(-time.Second)
+	polledAt := queriedAt.Add(200 * time.Millisecond)
+	_, span := StartJob(context.Background(), "job-uuid", queriedAt, polledAt)
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("len(recorder.Ended()) = %d, want 2", len(spans))
+	}
+	poll, job := spans[0], spans[1]
+	if poll.Name() != "monitor.poll" || job.Name() != "job" {
+		t.Fatalf("span names = %q, %q, want monitor.poll, job", poll.Name(), job.Name())
+	}
+	if poll.Parent().SpanID() != job.SpanContext().SpanID() {
+		t.Errorf("monitor.poll parent = %v, want the job span %v", poll.Parent().SpanID(), job.SpanContext().SpanID())
+	}
+	if !job.StartTime().Equal(queriedAt) || !poll.EndTime().Equal(polledAt) {
+		t.Errorf("job start = %v, poll end = %v, want %v, %v", job.StartTime(), poll.EndTime(), queriedAt, polledAt)
+	}
+}
+
+func TestInjectIntoJob(t *testing.T) {
+	useSpanRecorder(t)
+
+	for _, encoding := range []string{"", "json"} {
+		t.Run("encoding="+encoding, func(t *testing.T) {
+			ctx, span := Tracer().Start(context.Background(), "job")
+			defer span.End()
+
+			kjob := &batchv1.Job{}
+			kjob.Spec.Template.Spec.Containers = []corev1.Container{{Name: "agent"}, {Name: "container-0"}}
+			if err := InjectIntoJob(ctx, kjob, encoding); err != nil {
+				t.Fatalf("InjectIntoJob(ctx, kjob, %q) error = %v", encoding, err)
+			}
+
+			// Spans recorded later for the pod continue the trace.
+			podCtx := ContextFromAnnotations(context.Background(), kjob.Spec.Template.Annotations)
+			if got, want := trace.SpanContextFromContext(podCtx).SpanID(), span.SpanContext().SpanID(); got != want {
+				t.Errorf("span ID from pod annotations = %v, want %v", got, want)
+			}
+			if kjob.Annotations[config.TraceParentAnnotation] == "" {
+				t.Errorf("job annotation %s is empty", config.TraceParentAnnotation)
+			}
+
+			// The agent can decode the trace context.
+			for _, ctr := range kjob.Spec.Template.Spec.Containers {
+				if len(ctr.Env) != 1 || ctr.Env[0].Name != tracetools.EnvVarTraceContextKey {
+					t.Fatalf("container %s env = %v, want only %s", ctr.Name, ctr.Env, tracetools.EnvVarTraceContextKey)
+				}
+				codec, err := tracetools.ParseEncoding(encoding)
+				if err != nil {
+					t.Fatalf("tracetools.ParseEncoding(%q) error = %v", encoding, err)
+				}
+				raw, err := base64.URLEncoding.DecodeString(ctr.Env[0].Value)
+				if err != nil {
+					t.Fatalf("decoding %s: %v", tracetools.EnvVarTraceContextKey, err)
+				}
+				carrier := map[string]string{}
+				if err := codec.NewDecoder(bytes.NewReader(raw)).Decode(&carrier); err != nil {
+					t.Fatalf("decoding %s: %v", tracetools.EnvVarTraceContextKey, err)
+				}
+				if got, want := carrier["traceparent"], kjob.Annotations[config.TraceParentAnnotation]; got != want {
+					t.Errorf("decoded traceparent = %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}